@@ -7,7 +7,9 @@ import (
 	"llm-monitor/internal/api"
 	"llm-monitor/internal/config"
 	"llm-monitor/internal/storage"
+	"net"
 	"net/http"
+	"os"
 
 	"github.com/sirupsen/logrus"
 )
@@ -29,15 +31,34 @@ func main() {
 
 	internal.InitLogging(cfg.Logging)
 
+	// Run the migrate subcommand instead of the server, e.g. "-c config.yaml migrate status"
+	if flag.Arg(0) == "migrate" {
+		if err := internal.RunMigrate(cfg.Storage, flag.Args()[1:], os.Stdout); err != nil {
+			logrus.WithError(err).Fatal("Migration failed")
+		}
+		return
+	}
+
 	store, err := storage.CreateStorage(cfg.Storage)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to connect to storage")
 	}
 
-	apiHandler := api.NewAPIHandler(store)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.API.Port),
+		Handler: api.NewAPIHandler(store),
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create listener")
+	}
 
 	logrus.Infof("API server starting on port %d...", cfg.API.Port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.API.Port), apiHandler); err != nil {
+	if err := internal.Serve(server, listener, internal.ShutdownTimeout(cfg.Shutdown)); err != nil {
 		logrus.WithError(err).Fatal("API server failed")
 	}
+	if err := storage.Close(store); err != nil {
+		logrus.WithError(err).Warn("Failed to close storage")
+	}
+	logrus.Println("API server stopped gracefully")
 }
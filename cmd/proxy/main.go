@@ -1,14 +1,13 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"llm-monitor/internal"
 	"llm-monitor/internal/config"
 	"llm-monitor/internal/proxy"
 	"net"
-	"net/http"
+	"os"
 
 	"github.com/sirupsen/logrus"
 )
@@ -30,14 +29,16 @@ func main() {
 
 	internal.InitLogging(cfg.Logging)
 
-	// Create a custom server
-	server := proxy.CreateServer(*cfg)
-	defer func() {
-		err := server.Close()
-		if err != nil {
-			return
+	// Run the migrate subcommand instead of the server, e.g. "-c config.yaml migrate status"
+	if flag.Arg(0) == "migrate" {
+		if err := internal.RunMigrate(cfg.Storage, flag.Args()[1:], os.Stdout); err != nil {
+			logrus.WithError(err).Fatal("Migration failed")
 		}
-	}()
+		return
+	}
+
+	// Create a custom server
+	server := proxy.CreateServer(*cfg, *configFile)
 
 	// Set up a custom listener for better control
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Proxy.Port))
@@ -48,8 +49,8 @@ func main() {
 	logrus.Println("Proxy server starting...")
 	logrus.Println("Press Ctrl+C to stop")
 
-	// Start the server
-	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	// Serve until SIGINT or SIGTERM, then wait for in-flight streams and queued storage writes
+	if err := internal.Serve(server, listener, internal.ShutdownTimeout(cfg.Shutdown)); err != nil {
 		logrus.WithError(err).Fatal("Server error")
 	}
 
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"llm-monitor/internal/storage"
 	"llm-monitor/web"
 	"net/http"
 	"strconv"
-
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +28,10 @@ func NewAPIHandler(s storage.Storage) http.Handler {
 	mux.HandleFunc("GET /api/v1/conversations/{id}", h.getConversationMessages)
 	mux.HandleFunc("GET /api/v1/search", h.searchMessages)
 	mux.HandleFunc("GET /api/v1/branches/{id}", h.getBranchMessages)
+	mux.HandleFunc("DELETE /api/v1/conversations/{id}", h.deleteConversation)
+	mux.HandleFunc("POST /api/v1/conversations/{id}/archive", h.archiveConversation)
+	mux.HandleFunc("POST /api/v1/conversations/{id}/restore", h.restoreConversation)
+	mux.HandleFunc("DELETE /api/v1/branches/{id}", h.deleteBranch)
 
 	// Serve static UI assets
 	uiHandler := web.NewUIHandler()
@@ -36,7 +42,7 @@ func NewAPIHandler(s storage.Storage) http.Handler {
 		start := time.Now()
 
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 		if r.Method == http.MethodOptions {
@@ -70,7 +76,8 @@ func (rw *responseWriter) WriteHeader(code int) {
 func (h *APIHandler) listConversations(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	p := h.getPagination(r)
-	overviews, err := h.storage.ListConversations(ctx, p)
+	filter := storage.ConversationFilter{Archived: r.URL.Query().Get("archived") == "true"}
+	overviews, err := h.storage.ListConversations(ctx, filter, p)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to list conversations")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -124,13 +131,13 @@ func (h *APIHandler) getConversationMessages(w http.ResponseWriter, r *http.Requ
 
 func (h *APIHandler) searchMessages(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+	filter := h.getMessageFilter(r)
+	if filter.Query == "" && len(filter.Metadata) == 0 {
+		http.Error(w, "Query parameter 'q' or a metadata filter is required", http.StatusBadRequest)
 		return
 	}
 
-	messages, err := h.storage.SearchMessages(ctx, query, h.getPagination(r))
+	messages, err := h.storage.SearchMessages(ctx, filter, h.getPagination(r))
 	if err != nil {
 		logrus.WithError(err).Error("Failed to search messages")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -183,6 +190,47 @@ func (h *APIHandler) getBranchMessages(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, result)
 }
 
+func (h *APIHandler) deleteConversation(w http.ResponseWriter, r *http.Request) {
+	h.modify(w, r, "delete conversation", h.storage.DeleteConversation)
+}
+
+func (h *APIHandler) archiveConversation(w http.ResponseWriter, r *http.Request) {
+	h.modify(w, r, "archive conversation", h.storage.ArchiveConversation)
+}
+
+func (h *APIHandler) restoreConversation(w http.ResponseWriter, r *http.Request) {
+	h.modify(w, r, "restore conversation", h.storage.RestoreConversation)
+}
+
+func (h *APIHandler) deleteBranch(w http.ResponseWriter, r *http.Request) {
+	h.modify(w, r, "delete branch", h.storage.DeleteBranch)
+}
+
+// modify applies a modification to the conversation or branch given by the "id" path parameter and responds with
+// 204 No Content if it succeeded
+func (h *APIHandler) modify(w http.ResponseWriter, r *http.Request, action string, fn func(ctx context.Context, id uuid.UUID) error) {
+	id := r.PathValue("id")
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	err = fn(r.Context(), uid)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, storage.ErrInitialBranch):
+		http.Error(w, "The initial branch can only be deleted together with its conversation", http.StatusConflict)
+	case err != nil:
+		logrus.WithError(err).Errorf("Failed to %s %s", action, id)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		logrus.WithFields(logrus.Fields{"action": action, "id": id}).Info("Modified stored conversations")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func respondJSON(w http.ResponseWriter, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(data); err != nil {
@@ -190,6 +238,23 @@ func respondJSON(w http.ResponseWriter, data any) {
 	}
 }
 
+// getMessageFilter reads the search query from the "q" parameter and metadata filters from parameters prefixed with
+// "metadata.", e.g. "metadata.parameters.temperature=0.7" or "metadata.finish_reason=length".
+func (h *APIHandler) getMessageFilter(r *http.Request) storage.MessageFilter {
+	filter := storage.MessageFilter{Query: r.URL.Query().Get("q")}
+	for key, values := range r.URL.Query() {
+		path, ok := strings.CutPrefix(key, "metadata.")
+		if !ok || path == "" || len(values) == 0 {
+			continue
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = make(map[string]string)
+		}
+		filter.Metadata[path] = values[0]
+	}
+	return filter
+}
+
 func (h *APIHandler) getPagination(r *http.Request) storage.Pagination {
 	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
@@ -7,23 +7,31 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/google/uuid"
 )
 
 type mockStorage struct {
 	storage.Storage
 	listConversationsFunc func(ctx context.Context, p storage.Pagination) ([]storage.ConversationOverview, error)
+	searchMessagesFunc    func(ctx context.Context, filter storage.MessageFilter, p storage.Pagination) ([]storage.Message, error)
 }
 
-func (m *mockStorage) ListConversations(ctx context.Context, p storage.Pagination) ([]storage.ConversationOverview, error) {
+func (m *mockStorage) ListConversations(ctx context.Context, filter storage.ConversationFilter, p storage.Pagination) ([]storage.ConversationOverview, error) {
 	return m.listConversationsFunc(ctx, p)
 }
 
+func (m *mockStorage) SearchMessages(ctx context.Context, filter storage.MessageFilter, p storage.Pagination) ([]storage.Message, error) {
+	return m.searchMessagesFunc(ctx, filter, p)
+}
+
 func TestAPIHandler_ListConversations(t *testing.T) {
+	convID := uuid.New()
 	mock := &mockStorage{
 		listConversationsFunc: func(ctx context.Context, p storage.Pagination) ([]storage.ConversationOverview, error) {
 			return []storage.ConversationOverview{
 				{
-					Conversation: storage.Conversation{ID: "conv1"},
+					Conversation: storage.Conversation{ID: convID},
 					FirstMessage: &storage.Message{SimpleMessage: storage.SimpleMessage{Content: "First"}},
 				},
 			}, nil
@@ -45,7 +53,177 @@ func TestAPIHandler_ListConversations(t *testing.T) {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	if len(resp) != 1 || resp[0].ID != "conv1" {
+	if len(resp) != 1 || resp[0].ID != convID {
 		t.Errorf("Unexpected response: %+v", resp)
 	}
 }
+
+func TestAPIHandler_SearchMessages_MetadataFilter(t *testing.T) {
+	var filter storage.MessageFilter
+	mock := &mockStorage{
+		searchMessagesFunc: func(ctx context.Context, f storage.MessageFilter, p storage.Pagination) ([]storage.Message, error) {
+			filter = f
+			return []storage.Message{}, nil
+		},
+	}
+
+	h := NewAPIHandler(mock)
+	req := httptest.NewRequest("GET", "/api/v1/search?metadata.parameters.temperature=0.7&metadata.finish_reason=length&limit=10", nil)
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	if filter.Query != "" {
+		t.Errorf("Expected empty query, got %q", filter.Query)
+	}
+	if len(filter.Metadata) != 2 || filter.Metadata["parameters.temperature"] != "0.7" || filter.Metadata["finish_reason"] != "length" {
+		t.Errorf("Unexpected metadata filter: %+v", filter.Metadata)
+	}
+
+	// Neither a query nor a metadata filter
+	req = httptest.NewRequest("GET", "/api/v1/search", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestAPIHandler_MemoryStorage(t *testing.T) {
+	store := storage.NewMemoryStorage(0)
+	ctx := context.Background()
+	conv, branch, err := store.CreateConversation(ctx, nil, "chat")
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	m1, err := store.AddMessage(ctx, uuid.Nil, &storage.Message{BranchID: branch.ID, SimpleMessage: storage.SimpleMessage{Role: "user", Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	if _, err := store.AddMessage(ctx, m1.ID, &storage.Message{SimpleMessage: storage.SimpleMessage{Role: "assistant", Content: "Hi"}}); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	fork, err := store.AddMessage(ctx, m1.ID, &storage.Message{SimpleMessage: storage.SimpleMessage{Role: "assistant", Content: "Hey"}})
+	if err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+
+	h := NewAPIHandler(store)
+
+	req := httptest.NewRequest("GET", "/api/v1/conversations/"+conv.ID.String(), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var convResp struct {
+		Conversation storage.Conversation `json:"conversation"`
+		Messages     []storage.Message    `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &convResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if convResp.Conversation.ID != conv.ID || len(convResp.Messages) != 2 || convResp.Messages[1].Content != "Hi" {
+		t.Errorf("Unexpected response: %+v", convResp)
+	}
+	if len(convResp.Messages[0].ChildBranchIDs) != 1 || convResp.Messages[0].ChildBranchIDs[0] != fork.BranchID {
+		t.Errorf("Expected child branch %s, got %v", fork.BranchID, convResp.Messages[0].ChildBranchIDs)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/branches/"+fork.BranchID.String(), nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var branchResp struct {
+		Branch   storage.Branch    `json:"branch"`
+		Messages []storage.Message `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &branchResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(branchResp.Messages) != 2 || branchResp.Messages[0].ID != m1.ID || branchResp.Messages[1].ID != fork.ID {
+		t.Errorf("Unexpected branch history: %+v", branchResp.Messages)
+	}
+
+	// Unknown conversations are not found
+	req = httptest.NewRequest("GET", "/api/v1/conversations/"+uuid.New().String(), nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestAPIHandler_DeleteAndArchive(t *testing.T) {
+	store := storage.NewMemoryStorage(0)
+	ctx := context.Background()
+	conv, branch, err := store.CreateConversation(ctx, nil, "chat")
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	m1, err := store.AddMessage(ctx, uuid.Nil, &storage.Message{BranchID: branch.ID, SimpleMessage: storage.SimpleMessage{Role: "user", Content: "My password is hunter2"}})
+	if err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	if _, err := store.AddMessage(ctx, m1.ID, &storage.Message{SimpleMessage: storage.SimpleMessage{Role: "assistant", Content: "Hi"}}); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	fork, err := store.AddMessage(ctx, m1.ID, &storage.Message{SimpleMessage: storage.SimpleMessage{Role: "assistant", Content: "Hey"}})
+	if err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+
+	h := NewAPIHandler(store)
+	request := func(method, target string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Code
+	}
+	listed := func(target string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		var resp []storage.ConversationOverview
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return len(resp)
+	}
+
+	if code := request("DELETE", "/api/v1/branches/"+branch.ID.String()); code != http.StatusConflict {
+		t.Errorf("Expected status 409 for the initial branch, got %d", code)
+	}
+	if code := request("DELETE", "/api/v1/branches/"+fork.BranchID.String()); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if code := request("GET", "/api/v1/branches/"+fork.BranchID.String()); code != http.StatusNotFound {
+		t.Errorf("Expected deleted branch to be not found, got %d", code)
+	}
+
+	if code := request("POST", "/api/v1/conversations/"+conv.ID.String()+"/archive"); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if active, archived := listed("/api/v1/conversations"), listed("/api/v1/conversations?archived=true"); active != 0 || archived != 1 {
+		t.Errorf("Expected only an archived conversation, got %d active and %d archived", active, archived)
+	}
+	if code := request("POST", "/api/v1/conversations/"+conv.ID.String()+"/restore"); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if active := listed("/api/v1/conversations"); active != 1 {
+		t.Errorf("Expected restored conversation, got %d active", active)
+	}
+
+	if code := request("DELETE", "/api/v1/conversations/"+conv.ID.String()); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if code := request("DELETE", "/api/v1/conversations/"+conv.ID.String()); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a deleted conversation, got %d", code)
+	}
+	if code := request("DELETE", "/api/v1/conversations/invalid"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid ID, got %d", code)
+	}
+}
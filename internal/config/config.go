@@ -10,17 +10,60 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Proxy   ProxyConfig `yaml:"proxy"`
-	API     APIConfig   `yaml:"api"`
-	Logging Logging     `yaml:"logging,omitempty"`
-	Storage Storage     `yaml:"storage,omitempty"`
+	Proxy    ProxyConfig `yaml:"proxy"`
+	API      APIConfig   `yaml:"api"`
+	Logging  Logging     `yaml:"logging,omitempty"`
+	Storage  Storage     `yaml:"storage,omitempty"`
+	Shutdown Shutdown    `yaml:"shutdown,omitempty"`
 }
 
 // ProxyConfig represents the proxy configuration
 type ProxyConfig struct {
-	Upstream   UpstreamConfig `yaml:"upstream"`
-	Port       int            `yaml:"port"`
-	Intercepts []Intercept    `yaml:"intercepts"`
+	Upstream   UpstreamConfig   `yaml:"upstream"`
+	Upstreams  []UpstreamConfig `yaml:"upstreams,omitempty"`
+	Retry      RetryConfig      `yaml:"retry,omitempty"`
+	Port       int              `yaml:"port"`
+	Intercepts []Intercept      `yaml:"intercepts"`
+	Reload     ReloadConfig     `yaml:"reload,omitempty"`
+	Metrics    MetricsConfig    `yaml:"metrics,omitempty"`
+	Tracing    TracingConfig    `yaml:"tracing,omitempty"`
+}
+
+// TracingConfig represents the configuration of the OpenTelemetry tracing of the proxied requests.
+// Spans are exported via OTLP/HTTP to Endpoint, e.g. "http://localhost:4318", or to the endpoint configured by the
+// standard OTEL_EXPORTER_OTLP_* environment variables if it is empty. SampleRatio is the fraction of requests without
+// a parent span which are traced, all requests by default. If CaptureContent is enabled, the prompts and completions
+// are added to the spans as events, after sensitive values have been redacted.
+type TracingConfig struct {
+	Enabled        bool     `yaml:"enabled,omitempty"`
+	Endpoint       string   `yaml:"endpoint,omitempty"`
+	ServiceName    string   `yaml:"service_name,omitempty"`
+	SampleRatio    *float64 `yaml:"sample_ratio,omitempty"`
+	CaptureContent bool     `yaml:"capture_content,omitempty"`
+}
+
+// MetricsConfig represents the configuration of the Prometheus metrics served by the proxy.
+// Path is the path of the metrics endpoint, "/metrics" by default, which is not forwarded upstream.
+type MetricsConfig struct {
+	Disabled bool   `yaml:"disabled,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+}
+
+// ReloadConfig represents the configuration of reloading the config file.
+// Interval is the time between checks of the config file for changes, "0" disables watching the file.
+type ReloadConfig struct {
+	Interval string `yaml:"interval,omitempty"`
+}
+
+// RetryConfig represents the configuration of retries for failed upstream requests.
+// MaxAttempts is the total number of attempts including the first one, retries are disabled for values below 2.
+// With Failover enabled, retries prefer backends of the upstream pool which have not been tried yet.
+type RetryConfig struct {
+	MaxAttempts int    `yaml:"max_attempts,omitempty"`
+	Backoff     string `yaml:"backoff,omitempty"`
+	MaxBackoff  string `yaml:"max_backoff,omitempty"`
+	StatusCodes []int  `yaml:"status_codes,omitempty"`
+	Failover    bool   `yaml:"failover,omitempty"`
 }
 
 // APIConfig represents the API configuration
@@ -28,24 +71,125 @@ type APIConfig struct {
 	Port int `yaml:"port"`
 }
 
-// UpstreamConfig represents the upstream configuration
+// UpstreamConfig represents the upstream configuration.
+// Models contains glob patterns (e.g. "llama3*") of the models served by the upstream. The default upstream
+// configured in ProxyConfig.Upstream serves all requests which are not matched by any other upstream.
+// An upstream either has a single URL or a pool of Backends, which are balanced using the configured Balancer
+// ("round-robin", "least-outstanding" or "consistent-hash").
 type UpstreamConfig struct {
-	URL     string `yaml:"url"`
-	Timeout string `yaml:"timeout,omitempty"`
+	Name        string             `yaml:"name,omitempty"`
+	URL         string             `yaml:"url,omitempty"`
+	Timeout     string             `yaml:"timeout,omitempty"`
+	Models      []string           `yaml:"models,omitempty"`
+	Backends    []BackendConfig    `yaml:"backends,omitempty"`
+	Balancer    string             `yaml:"balancer,omitempty"`
+	HealthCheck *HealthCheckConfig `yaml:"health_check,omitempty"`
+}
+
+// BackendConfig represents a single backend server of an upstream pool
+type BackendConfig struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight,omitempty"`
+}
+
+// HealthCheckConfig represents the configuration of the active health checks of an upstream pool
+type HealthCheckConfig struct {
+	Path     string `yaml:"path,omitempty"`
+	Interval string `yaml:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty"`
 }
 
-// Intercept represents an interceptor configuration
+// Intercept represents an interceptor configuration.
+// Endpoint is a path pattern which may contain parameters like "{name}", "*" and a final "{name...}". With a
+// StripPrefix, the endpoint matches the path without the prefix, and the request is forwarded without it.
+// StoreVectors enables storing the vectors returned by embedding interceptors, which are omitted by default.
+// Redact lists the rules redacting sensitive values from the messages before they are saved to storage.
+// Rewrite rewrites the requests before they are passed to the interceptor and forwarded.
 type Intercept struct {
-	Endpoint    string `yaml:"endpoint"`
-	Method      string `yaml:"method"`
-	Interceptor string `yaml:"interceptor"`
+	Endpoint     string          `yaml:"endpoint"`
+	Method       string          `yaml:"method"`
+	StripPrefix  string          `yaml:"strip_prefix,omitempty"`
+	Interceptor  string          `yaml:"interceptor"`
+	StoreVectors bool            `yaml:"store_vectors,omitempty"`
+	Redact       []RedactionRule `yaml:"redact,omitempty"`
+	Rewrite      *RewriteConfig  `yaml:"rewrite,omitempty"`
+}
+
+// RewriteConfig represents a policy rewriting requests. Format is "openai" or "ollama" and derived from the endpoint
+// if it is empty. SystemPromptMode is "replace" (default), "prepend" or "default".
+type RewriteConfig struct {
+	Format           string            `yaml:"format,omitempty"`
+	Model            string            `yaml:"model,omitempty"`
+	Models           map[string]string `yaml:"models,omitempty"`
+	SystemPrompt     string            `yaml:"system_prompt,omitempty"`
+	SystemPromptMode string            `yaml:"system_prompt_mode,omitempty"`
+	MaxTokens        int               `yaml:"max_tokens,omitempty"`
+	MaxTemperature   *float64          `yaml:"max_temperature,omitempty"`
+	Options          map[string]any    `yaml:"options,omitempty"`
+	Strip            []string          `yaml:"strip,omitempty"`
+}
+
+// RedactionRule represents a redaction of sensitive values. Type is one of the built-in detectors "api_key", "email",
+// "credit_card" and "iban", or "regex" for a custom Pattern. Action is "mask" (default), "hash" or "drop".
+type RedactionRule struct {
+	Name    string `yaml:"name,omitempty"`
+	Type    string `yaml:"type"`
+	Pattern string `yaml:"pattern,omitempty"`
+	Action  string `yaml:"action,omitempty"`
 }
 
-// Storage represents the storage configuration
+// Storage represents the storage configuration.
+// If a Writer is configured, messages are saved asynchronously by a background writer instead of within the request.
+// If a Spool is configured, messages which can't be saved are spooled to disk and replayed later.
+// If a Retention policy is configured, expired conversations are purged periodically.
 type Storage struct {
-	Type     string          `yaml:"type"`
-	Timeout  string          `yaml:"timeout,omitempty"`
-	Postgres *PostgresConfig `yaml:"postgres,omitempty"`
+	Type      string           `yaml:"type"`
+	Timeout   string           `yaml:"timeout,omitempty"`
+	Postgres  *PostgresConfig  `yaml:"postgres,omitempty"`
+	SQLite    *SQLiteConfig    `yaml:"sqlite,omitempty"`
+	Memory    *MemoryConfig    `yaml:"memory,omitempty"`
+	Writer    *WriterConfig    `yaml:"writer,omitempty"`
+	Spool     *SpoolConfig     `yaml:"spool,omitempty"`
+	Retention *RetentionConfig `yaml:"retention,omitempty"`
+}
+
+// RetentionConfig represents the retention policy of the stored conversations, which is applied every Interval.
+// Conversations expire MaxAge after their last message unless a rule matches them, and only the most recent
+// MaxConversations are kept. Empty or zero values disable a limit.
+type RetentionConfig struct {
+	Interval         string                `yaml:"interval,omitempty"`
+	BatchSize        int                   `yaml:"batch_size,omitempty"`
+	MaxAge           string                `yaml:"max_age,omitempty"`
+	MaxConversations int                   `yaml:"max_conversations,omitempty"`
+	Rules            []RetentionRuleConfig `yaml:"rules,omitempty"`
+}
+
+// RetentionRuleConfig overrides the maximum age of the conversations with a model matching the glob pattern Model
+// and/or the request type RequestType. The first matching rule is applied, an empty MaxAge keeps them forever.
+type RetentionRuleConfig struct {
+	Model       string `yaml:"model,omitempty"`
+	RequestType string `yaml:"request_type,omitempty"`
+	MaxAge      string `yaml:"max_age,omitempty"`
+}
+
+// SpoolConfig represents the configuration of the spool for messages which could not be saved to storage.
+// Spooled messages are replayed to storage every ReplayInterval.
+type SpoolConfig struct {
+	Dir            string `yaml:"dir"`
+	ReplayInterval string `yaml:"replay_interval,omitempty"`
+}
+
+// WriterConfig represents the configuration of the asynchronous storage writer.
+// Overflow defines what happens to messages while the queue is full: "drop" discards them, "block" waits for
+// at most BlockTimeout and "spill" writes them to SpillDir until the queue has space again.
+type WriterConfig struct {
+	QueueSize     int    `yaml:"queue_size,omitempty"`
+	Workers       int    `yaml:"workers,omitempty"`
+	BatchSize     int    `yaml:"batch_size,omitempty"`
+	FlushInterval string `yaml:"flush_interval,omitempty"`
+	Overflow      string `yaml:"overflow,omitempty"`
+	BlockTimeout  string `yaml:"block_timeout,omitempty"`
+	SpillDir      string `yaml:"spill_dir,omitempty"`
 }
 
 // PostgresConfig represents the PostgreSQL configuration
@@ -53,11 +197,30 @@ type PostgresConfig struct {
 	DSN string `yaml:"dsn"`
 }
 
+// SQLiteConfig represents the SQLite configuration.
+// Path is the path of the database file, which is created if it doesn't exist.
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+// MemoryConfig represents the configuration of the in-memory storage.
+// If MaxConversations is greater than zero, the least recently used conversations are evicted once it is exceeded.
+type MemoryConfig struct {
+	MaxConversations int `yaml:"max_conversations,omitempty"`
+}
+
 // Logging represents the logging configuration
 type Logging struct {
 	Format string `yaml:"format,omitempty"`
 }
 
+// Shutdown represents the configuration of the graceful shutdown of the proxy and the API server.
+// Timeout is the deadline for in-flight requests and queued storage writes to complete after SIGINT or SIGTERM,
+// the process exits once it has passed.
+type Shutdown struct {
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
 // LoadConfig loads the configuration from a YAML file
 func LoadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
@@ -123,6 +123,8 @@ api:
 storage:
   type: postgres
   timeout: 10s
+shutdown:
+  timeout: 45s
 `
 	tmpfile, err := os.CreateTemp("", "config_timeout_*.yaml")
 	if err != nil {
@@ -148,6 +150,9 @@ storage:
 	if cfg.Storage.Timeout != "10s" {
 		t.Errorf("Expected Storage Timeout 10s, got %s", cfg.Storage.Timeout)
 	}
+	if cfg.Shutdown.Timeout != "45s" {
+		t.Errorf("Expected Shutdown Timeout 45s, got %s", cfg.Shutdown.Timeout)
+	}
 }
 
 func TestLoadConfig_Intercepts(t *testing.T) {
@@ -193,3 +198,311 @@ api:
 		t.Errorf("Unexpected intercept 1: %+v", cfg.Proxy.Intercepts[1])
 	}
 }
+
+func TestLoadConfig_StoreVectors(t *testing.T) {
+	content := `
+proxy:
+  intercepts:
+    - endpoint: "/v1/embeddings"
+      method: "POST"
+      interceptor: "OpenAIEmbeddingsInterceptor"
+      store_vectors: true
+    - endpoint: "/api/embed"
+      method: "POST"
+      interceptor: "OllamaEmbedInterceptor"
+`
+	tmpfile, err := os.CreateTemp("", "config_store_vectors_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.Proxy.Intercepts[0].StoreVectors {
+		t.Errorf("Expected vectors to be stored for intercept 0: %+v", cfg.Proxy.Intercepts[0])
+	}
+	if cfg.Proxy.Intercepts[1].StoreVectors {
+		t.Errorf("Expected vectors not to be stored by default for intercept 1: %+v", cfg.Proxy.Intercepts[1])
+	}
+}
+
+func TestLoadConfig_StripPrefix(t *testing.T) {
+	content := `
+proxy:
+  intercepts:
+    - endpoint: "/api/generate"
+      method: "*"
+      strip_prefix: "/ollama"
+      interceptor: "OllamaGenerateInterceptor"
+`
+	tmpfile, err := os.CreateTemp("", "config_strip_prefix_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Proxy.Intercepts[0].StripPrefix != "/ollama" {
+		t.Errorf("Expected strip prefix /ollama, got %q", cfg.Proxy.Intercepts[0].StripPrefix)
+	}
+}
+
+func TestLoadConfig_Redact(t *testing.T) {
+	content := `
+proxy:
+  intercepts:
+    - endpoint: "/v1/chat/completions"
+      method: "POST"
+      interceptor: "OpenAIChatInterceptor"
+      redact:
+        - type: "email"
+        - type: "regex"
+          name: "employee_id"
+          pattern: "EMP-[0-9]{6}"
+          action: "hash"
+`
+	tmpfile, err := os.CreateTemp("", "config_redact_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	redact := cfg.Proxy.Intercepts[0].Redact
+	expected := RedactionRule{Name: "employee_id", Type: "regex", Pattern: "EMP-[0-9]{6}", Action: "hash"}
+	if len(redact) != 2 || redact[0].Type != "email" || redact[1] != expected {
+		t.Errorf("Unexpected redaction rules: %+v", redact)
+	}
+}
+
+func TestLoadConfig_Upstreams(t *testing.T) {
+	content := `
+proxy:
+  upstream:
+    url: http://localhost:11434
+  upstreams:
+    - name: vllm
+      url: http://vllm:8000
+      timeout: 120s
+      models: ["meta-llama/*", "qwen*"]
+    - url: http://gpu:11434
+      models: ["llama3*"]
+`
+	tmpfile, err := os.CreateTemp("", "config_upstreams_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(cfg.Proxy.Upstreams) != 2 {
+		t.Fatalf("Expected 2 upstreams, got %d", len(cfg.Proxy.Upstreams))
+	}
+	vllm := cfg.Proxy.Upstreams[0]
+	if vllm.Name != "vllm" || vllm.URL != "http://vllm:8000" || vllm.Timeout != "120s" {
+		t.Errorf("Unexpected upstream 0: %+v", vllm)
+	}
+	if len(vllm.Models) != 2 || vllm.Models[0] != "meta-llama/*" {
+		t.Errorf("Unexpected models for upstream 0: %v", vllm.Models)
+	}
+	if cfg.Proxy.Upstreams[1].Name != "" || cfg.Proxy.Upstreams[1].Models[0] != "llama3*" {
+		t.Errorf("Unexpected upstream 1: %+v", cfg.Proxy.Upstreams[1])
+	}
+}
+
+func TestLoadConfig_Retry(t *testing.T) {
+	content := `
+proxy:
+  upstream:
+    url: http://localhost:11434
+  retry:
+    max_attempts: 3
+    backoff: 200ms
+    status_codes: [429, 503]
+    failover: true
+  reload:
+    interval: 5s
+  metrics:
+    path: /internal/metrics
+  tracing:
+    enabled: true
+    endpoint: http://otel-collector:4318
+    sample_ratio: 0.25
+    capture_content: true
+`
+	tmpfile, err := os.CreateTemp("", "config_retry_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	retry := cfg.Proxy.Retry
+	if retry.MaxAttempts != 3 || retry.Backoff != "200ms" || retry.MaxBackoff != "" || !retry.Failover {
+		t.Errorf("Unexpected retry config: %+v", retry)
+	}
+	if len(retry.StatusCodes) != 2 || retry.StatusCodes[0] != 429 || retry.StatusCodes[1] != 503 {
+		t.Errorf("Unexpected retry status codes: %v", retry.StatusCodes)
+	}
+	if cfg.Proxy.Reload.Interval != "5s" {
+		t.Errorf("Expected reload interval 5s, got %s", cfg.Proxy.Reload.Interval)
+	}
+	if cfg.Proxy.Metrics.Path != "/internal/metrics" || cfg.Proxy.Metrics.Disabled {
+		t.Errorf("Unexpected metrics config: %+v", cfg.Proxy.Metrics)
+	}
+	tracing := cfg.Proxy.Tracing
+	if !tracing.Enabled || tracing.Endpoint != "http://otel-collector:4318" || !tracing.CaptureContent || tracing.ServiceName != "" {
+		t.Errorf("Unexpected tracing config: %+v", tracing)
+	}
+	if tracing.SampleRatio == nil || *tracing.SampleRatio != 0.25 {
+		t.Errorf("Expected sample ratio 0.25, got %v", tracing.SampleRatio)
+	}
+}
+
+func TestLoadConfig_Retention(t *testing.T) {
+	content := `
+storage:
+  type: sqlite
+  retention:
+    interval: 30m
+    max_age: 720h
+    max_conversations: 10000
+    rules:
+      - model: "gpt-4*"
+        max_age: 2160h
+      - request_type: embed
+`
+	tmpfile, err := os.CreateTemp("", "config_retention_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	retention := cfg.Storage.Retention
+	if retention == nil || retention.Interval != "30m" || retention.MaxAge != "720h" || retention.MaxConversations != 10000 {
+		t.Fatalf("Unexpected retention config: %+v", retention)
+	}
+	if len(retention.Rules) != 2 || retention.Rules[0].Model != "gpt-4*" || retention.Rules[0].MaxAge != "2160h" ||
+		retention.Rules[1].RequestType != "embed" || retention.Rules[1].MaxAge != "" {
+		t.Errorf("Unexpected retention rules: %+v", retention.Rules)
+	}
+}
+
+func TestLoadConfig_Rewrite(t *testing.T) {
+	content := `
+proxy:
+  intercepts:
+    - endpoint: "/api/chat"
+      method: "POST"
+      interceptor: "OllamaChatInterceptor"
+      rewrite:
+        model: "llama3"
+        models:
+          "gpt-4*": "llama3:70b"
+        system_prompt: "Be concise."
+        system_prompt_mode: "prepend"
+        max_tokens: 512
+        max_temperature: 0.8
+        options:
+          num_ctx: 8192
+        strip: ["keep_alive"]
+`
+	tmpfile, err := os.CreateTemp("", "config_rewrite_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	rewrite := cfg.Proxy.Intercepts[0].Rewrite
+	if rewrite == nil {
+		t.Fatalf("Expected a rewrite policy")
+	}
+	if rewrite.Model != "llama3" || rewrite.Models["gpt-4*"] != "llama3:70b" || rewrite.SystemPrompt != "Be concise." || rewrite.SystemPromptMode != "prepend" {
+		t.Errorf("Unexpected rewrite config: %+v", rewrite)
+	}
+	if rewrite.MaxTokens != 512 || rewrite.MaxTemperature == nil || *rewrite.MaxTemperature != 0.8 {
+		t.Errorf("Unexpected limits: %+v", rewrite)
+	}
+	if rewrite.Options["num_ctx"] != 8192 || len(rewrite.Strip) != 1 || rewrite.Strip[0] != "keep_alive" {
+		t.Errorf("Unexpected options or stripped fields: %+v", rewrite)
+	}
+}
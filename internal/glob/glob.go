@@ -0,0 +1,27 @@
+// Package glob matches names like models against glob patterns from the configuration
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile converts a glob pattern into an anchored regular expression, where '*' matches any sequence of characters
+// (including '/') and '?' matches a single character. All other characters match themselves, so the pattern is
+// always valid.
+func Compile(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
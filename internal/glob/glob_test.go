@@ -0,0 +1,24 @@
+package glob
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"llama3*", "llama3:8b", true},
+		{"llama3*", "codellama3", false},
+		{"meta-llama/*", "meta-llama/Llama-3-8B", true},
+		{"gpt-4?", "gpt-4o", true},
+		{"gpt-4?", "gpt-4.1", false},
+		{"gpt-4.1", "gpt-441", false},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if matches := Compile(tt.pattern).MatchString(tt.name); matches != tt.matches {
+			t.Errorf("Compile(%q).MatchString(%q) = %v, expected %v", tt.pattern, tt.name, matches, tt.matches)
+		}
+	}
+}
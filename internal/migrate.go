@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/storage"
+	"text/tabwriter"
+	"time"
+)
+
+// RunMigrate runs the migrate subcommand with the given arguments:
+//
+//	migrate [up] [-dry-run]   applies the pending migrations, or only prints them with -dry-run
+//	migrate status            lists all migrations and when they have been applied
+func RunMigrate(cfg config.Storage, args []string, out io.Writer) error {
+	command := "up"
+	if len(args) > 0 && (args[0] == "up" || args[0] == "status") {
+		command, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Print the pending migrations without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unknown migrate command %q, expected \"up\" or \"status\"", flags.Arg(0))
+	}
+
+	m, err := storage.OpenMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = m.Close()
+	}()
+	ctx := context.Background()
+
+	switch {
+	case command == "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format(time.DateTime)
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	case *dryRun:
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			_, err = fmt.Fprintln(out, "No pending migrations")
+			return err
+		}
+		for _, migration := range pending {
+			_, _ = fmt.Fprintf(out, "-- Migration %d (%s)\n%s\n", migration.Version, migration.Name, migration.SQL)
+		}
+		return nil
+	default:
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			_, _ = fmt.Fprintf(out, "Applied migration %d (%s)\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			_, _ = fmt.Fprintln(out, "No pending migrations")
+		}
+		return err
+	}
+}
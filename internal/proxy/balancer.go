@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Balancer selects a backend for a request out of a set of healthy candidates
+type Balancer interface {
+	// Select returns one of the candidates. The key identifies the client and is used by balancers
+	// which provide client affinity.
+	Select(candidates []*Backend, key string) *Backend
+}
+
+// NewBalancer creates a balancer by name for the given backends
+func NewBalancer(name string, backends []*Backend) (Balancer, error) {
+	switch name {
+	case "", "round-robin":
+		return newRoundRobinBalancer(), nil
+	case "least-outstanding":
+		return &leastOutstandingBalancer{}, nil
+	case "consistent-hash":
+		return newConsistentHashBalancer(backends), nil
+	default:
+		return nil, fmt.Errorf("invalid balancer type: %s", name)
+	}
+}
+
+// roundRobinBalancer implements smooth weighted round-robin balancing
+type roundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[*Backend]int
+}
+
+func newRoundRobinBalancer() *roundRobinBalancer {
+	return &roundRobinBalancer{
+		current: make(map[*Backend]int),
+	}
+}
+
+func (rr *roundRobinBalancer) Select(candidates []*Backend, _ string) *Backend {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	var best *Backend
+	total := 0
+	for _, b := range candidates {
+		rr.current[b] += b.Weight
+		total += b.Weight
+		if best == nil || rr.current[b] > rr.current[best] {
+			best = b
+		}
+	}
+	if best != nil {
+		rr.current[best] -= total
+	}
+	return best
+}
+
+// leastOutstandingBalancer selects the backend with the fewest outstanding requests relative to its weight
+type leastOutstandingBalancer struct{}
+
+func (lo *leastOutstandingBalancer) Select(candidates []*Backend, _ string) *Backend {
+	var best *Backend
+	var bestOutstanding int64
+	for _, b := range candidates {
+		outstanding := b.Outstanding()
+		// Compare outstanding/weight without using floating point arithmetic
+		if best == nil || outstanding*int64(best.Weight) < bestOutstanding*int64(b.Weight) {
+			best = b
+			bestOutstanding = outstanding
+		}
+	}
+	return best
+}
+
+// consistentHashBalancer maps clients onto a hash ring, such that a client sticks to the same backend
+// as long as the backend is healthy
+type consistentHashBalancer struct {
+	ring []ringEntry
+}
+
+type ringEntry struct {
+	hash    uint32
+	backend *Backend
+}
+
+// virtualNodes is the number of points on the hash ring per unit of weight
+const virtualNodes = 64
+
+func newConsistentHashBalancer(backends []*Backend) *consistentHashBalancer {
+	var ring []ringEntry
+	for _, b := range backends {
+		for i := 0; i < b.Weight*virtualNodes; i++ {
+			hash := crc32.ChecksumIEEE([]byte(b.URL.String() + "#" + strconv.Itoa(i)))
+			ring = append(ring, ringEntry{hash: hash, backend: b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	return &consistentHashBalancer{ring: ring}
+}
+
+func (ch *consistentHashBalancer) Select(candidates []*Backend, key string) *Backend {
+	if len(candidates) == 0 || len(ch.ring) == 0 {
+		return nil
+	}
+
+	allowed := make(map[*Backend]bool, len(candidates))
+	for _, b := range candidates {
+		allowed[b] = true
+	}
+
+	// Walk the ring clockwise starting at the position of the key until a candidate is found
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(ch.ring), func(i int) bool {
+		return ch.ring[i].hash >= hash
+	})
+	for i := 0; i < len(ch.ring); i++ {
+		entry := ch.ring[(start+i)%len(ch.ring)]
+		if allowed[entry.backend] {
+			return entry.backend
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func newTestBackends(t *testing.T, weights ...int) []*Backend {
+	backends := make([]*Backend, len(weights))
+	for i, w := range weights {
+		b, err := NewBackend("http://backend-"+string(rune('a'+i))+":11434", w)
+		if err != nil {
+			t.Fatalf("Failed to create backend: %v", err)
+		}
+		backends[i] = b
+	}
+	return backends
+}
+
+func TestRoundRobinBalancer_Weights(t *testing.T) {
+	backends := newTestBackends(t, 3, 1)
+	balancer, err := NewBalancer("round-robin", backends)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+
+	counts := make(map[*Backend]int)
+	for i := 0; i < 8; i++ {
+		counts[balancer.Select(backends, "")]++
+	}
+	if counts[backends[0]] != 6 || counts[backends[1]] != 2 {
+		t.Errorf("Expected 6/2 distribution, got %d/%d", counts[backends[0]], counts[backends[1]])
+	}
+}
+
+func TestLeastOutstandingBalancer(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 2)
+	balancer, err := NewBalancer("least-outstanding", backends)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+
+	backends[0].Acquire()
+	backends[1].Acquire()
+	backends[1].Acquire()
+	backends[2].Acquire()
+	backends[2].Acquire()
+	backends[2].Acquire()
+
+	// Relative load: 1/1, 2/1, 3/2
+	if got := balancer.Select(backends, ""); got != backends[0] {
+		t.Errorf("Expected backend 0, got %s", got.URL)
+	}
+	backends[0].Acquire()
+	backends[0].Acquire()
+	if got := balancer.Select(backends, ""); got != backends[2] {
+		t.Errorf("Expected backend 2, got %s", got.URL)
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 1)
+	balancer, err := NewBalancer("consistent-hash", backends)
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+
+	// The same client always gets the same backend
+	first := balancer.Select(backends, "10.0.0.1")
+	for i := 0; i < 10; i++ {
+		if got := balancer.Select(backends, "10.0.0.1"); got != first {
+			t.Fatalf("Expected sticky backend %s, got %s", first.URL, got.URL)
+		}
+	}
+
+	// If the backend leaves the candidates, the client moves to another one
+	var remaining []*Backend
+	for _, b := range backends {
+		if b != first {
+			remaining = append(remaining, b)
+		}
+	}
+	if got := balancer.Select(remaining, "10.0.0.1"); got == first || got == nil {
+		t.Errorf("Expected a different backend, got %v", got)
+	}
+}
+
+func TestNewBalancer_Invalid(t *testing.T) {
+	if _, err := NewBalancer("random", nil); err == nil {
+		t.Errorf("Expected error for invalid balancer")
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"llm-monitor/internal/proxy/interceptor"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// controlInterceptor returns the error from RequestInterceptor and records the completion hooks
+type controlInterceptor struct {
+	interceptor.SimpleInterceptor
+	err       error
+	completed bool
+	failed    bool
+}
+
+func (ci *controlInterceptor) RequestInterceptor(req *http.Request, state interceptor.State) error {
+	return ci.err
+}
+
+func (ci *controlInterceptor) OnComplete(_ interceptor.State) {
+	ci.completed = true
+}
+
+func (ci *controlInterceptor) OnError(_ interceptor.State, _ error) {
+	ci.failed = true
+}
+
+func TestProxyHandler_InterceptorChain(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedBody   string
+		expectedCalls  int32
+		expectedFailed bool
+	}{
+		{"Continue", errors.New("ignored"), http.StatusOK, "upstream", 1, false},
+		{"Abort", interceptor.Abort(http.StatusForbidden, errors.New("model not allowed")), http.StatusForbidden, "Forbidden\n", 0, true},
+		{"Short-circuit", interceptor.Respond(http.StatusOK, http.Header{"X-Cache": {"hit"}}, []byte("cached")), http.StatusOK, "cached", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			ph, err := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+			if err != nil {
+				t.Fatalf("Failed to create proxy handler: %v", err)
+			}
+			first := &exchangeInterceptor{}
+			second := &controlInterceptor{err: tt.err}
+			ph.RegisterInterceptor("/api/chat", "*", first)
+			ph.RegisterInterceptor("/api/chat", "POST", second)
+
+			req := httptest.NewRequest("POST", "/api/chat", bytes.NewBufferString(`{"model":"llama3"}`))
+			w := httptest.NewRecorder()
+			ph.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus || w.Body.String() != tt.expectedBody {
+				t.Errorf("Expected %d %q, got %d %q", tt.expectedStatus, tt.expectedBody, w.Code, w.Body.String())
+			}
+			if calls.Load() != tt.expectedCalls {
+				t.Errorf("Expected %d upstream calls, got %d", tt.expectedCalls, calls.Load())
+			}
+			if first.exchange == nil {
+				t.Errorf("Expected the first interceptor of the chain to be called")
+			}
+			if second.failed != tt.expectedFailed || second.completed == tt.expectedFailed {
+				t.Errorf("Expected failed=%v, got completed=%v failed=%v", tt.expectedFailed, second.completed, second.failed)
+			}
+			if tt.name == "Short-circuit" && w.Header().Get("X-Cache") != "hit" {
+				t.Errorf("Expected the headers of the short-circuit response, got %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestProxyHandler_RoutePattern(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	var upstreamPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		w.Write([]byte("{}"))
+	}))
+	defer upstream.Close()
+
+	ph, err := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	ei := &exchangeInterceptor{}
+	route := interceptor.Route{Pattern: "/openai/deployments/{deployment}/chat/completions", Method: "POST", StripPrefix: "/azure"}
+	if err := ph.RegisterRoute(route, ei); err != nil {
+		t.Fatalf("Failed to register route: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/azure/openai/deployments/gpt-4o/chat/completions?api-version=2024-06-01", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if upstreamPath != "/openai/deployments/gpt-4o/chat/completions" {
+		t.Errorf("Expected the prefix to be stripped, got %s", upstreamPath)
+	}
+	if ei.exchange == nil || ei.exchange.Params()["deployment"] != "gpt-4o" {
+		t.Fatalf("Expected the deployment in the params of the exchange")
+	}
+	params, ok := ei.exchange.Metadata()["path_params"].(map[string]string)
+	if !ok || params["deployment"] != "gpt-4o" {
+		t.Errorf("Expected the params in the metadata, got %v", ei.exchange.Metadata())
+	}
+}
@@ -0,0 +1,462 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MessagesInterceptor intercepts messages between client and Anthropic compatible server
+type MessagesInterceptor struct {
+	interceptor.SavingInterceptor
+}
+
+// contentBlock represents a single content block of an Anthropic message
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitzero"`
+	Thinking  string          `json:"thinking,omitzero"`
+	ID        string          `json:"id,omitzero"`
+	Name      string          `json:"name,omitzero"`
+	Input     json.RawMessage `json:"input,omitzero"`
+	ToolUseID string          `json:"tool_use_id,omitzero"`
+	Content   messageContent  `json:"content,omitzero"`
+	IsError   bool            `json:"is_error,omitzero"`
+}
+
+// messageContent represents the content of a message, which is either a plain string or a list of content blocks
+type messageContent []contentBlock
+
+// UnmarshalJSON accepts both a plain string and a list of content blocks
+func (c *messageContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = messageContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []contentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// text returns the concatenated text of all text blocks
+func (c messageContent) text() string {
+	var parts []string
+	for _, block := range c {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// thinking returns the concatenated thinking of all thinking blocks
+func (c messageContent) thinking() string {
+	var parts []string
+	for _, block := range c {
+		if block.Type == "thinking" && block.Thinking != "" {
+			parts = append(parts, block.Thinking)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// toolCalls returns all tool_use blocks as tool calls
+func (c messageContent) toolCalls() []storage.ToolCall {
+	var toolCalls []storage.ToolCall
+	for _, block := range c {
+		if block.Type != "tool_use" {
+			continue
+		}
+		arguments := string(block.Input)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		toolCall := storage.ToolCall{
+			ID:   block.ID,
+			Type: "function",
+		}
+		toolCall.Function.Name = block.Name
+		toolCall.Function.Arguments = arguments
+		toolCalls = append(toolCalls, toolCall)
+	}
+	return toolCalls
+}
+
+// message represents an Anthropic message
+type message struct {
+	Role    string         `json:"role"`
+	Content messageContent `json:"content"`
+}
+
+// toolDefinition represents a tool offered to the model
+type toolDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitzero"`
+	InputSchema json.RawMessage `json:"input_schema,omitzero"`
+}
+
+// messagesRequest represents the structure of an Anthropic messages request
+type messagesRequest struct {
+	Model         string           `json:"model"`
+	System        messageContent   `json:"system,omitzero"`
+	Messages      []message        `json:"messages"`
+	MaxTokens     int              `json:"max_tokens,omitzero"`
+	Stream        bool             `json:"stream,omitzero"`
+	Tools         []toolDefinition `json:"tools,omitzero"`
+	ToolChoice    json.RawMessage  `json:"tool_choice,omitzero"`
+	Temperature   *float64         `json:"temperature,omitzero"`
+	TopP          *float64         `json:"top_p,omitzero"`
+	TopK          *int             `json:"top_k,omitzero"`
+	StopSequences []string         `json:"stop_sequences,omitzero"`
+	Thinking      json.RawMessage  `json:"thinking,omitzero"`
+}
+
+// messagesUsage represents token usage in an Anthropic response. Cached input tokens are not contained
+// in InputTokens and are reported separately.
+type messagesUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitzero"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitzero"`
+}
+
+// promptTokens returns the total number of input tokens including cached tokens
+func (u messagesUsage) promptTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// messagesResponse represents the structure of an Anthropic messages response
+type messagesResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      messageContent `json:"content"`
+	StopReason   string         `json:"stop_reason,omitzero"`
+	StopSequence string         `json:"stop_sequence,omitzero"`
+	Usage        messagesUsage  `json:"usage,omitzero"`
+}
+
+// streamError represents an error reported by the server
+type streamError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// streamEvent represents a single Server-Sent Event of a streaming response
+type streamEvent struct {
+	Type         string           `json:"type"`
+	Message      messagesResponse `json:"message,omitzero"`
+	Index        int              `json:"index"`
+	ContentBlock contentBlock     `json:"content_block,omitzero"`
+	Delta        struct {
+		Type         string `json:"type"`
+		Text         string `json:"text,omitzero"`
+		Thinking     string `json:"thinking,omitzero"`
+		PartialJSON  string `json:"partial_json,omitzero"`
+		StopReason   string `json:"stop_reason,omitzero"`
+		StopSequence string `json:"stop_sequence,omitzero"`
+	} `json:"delta,omitzero"`
+	Usage messagesUsage `json:"usage,omitzero"`
+	Error streamError   `json:"error,omitzero"`
+}
+
+// messagesState holds the state information for Anthropic requests
+type messagesState struct {
+	request      messagesRequest
+	response     messagesResponse
+	partialJSON  map[int]*strings.Builder
+	startTime    time.Time
+	endTime      time.Time
+	statusCode   int
+	clientHost   string
+	upstreamHost string
+	exchange     *interceptor.Exchange
+	parameters   map[string]any
+	decoder      interceptor.SSEDecoder
+}
+
+// CreateState creates a new state for the interceptor
+func (ai *MessagesInterceptor) CreateState() interceptor.State {
+	return &messagesState{
+		partialJSON: make(map[int]*strings.Builder),
+		startTime:   time.Now(),
+	}
+}
+
+// RequestInterceptor intercepts the request to extract model and context information
+func (ai *MessagesInterceptor) RequestInterceptor(req *http.Request, state interceptor.State) error {
+	logrus.Printf("[%s] Intercepting request to %s", ai.Name, req.URL.Path)
+
+	// Read the request body
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(req.Body)
+
+	// Extract host information
+	anthropicState, _ := state.(*messagesState)
+	anthropicState.clientHost = req.Header.Get("X-Forwarded-For")
+	anthropicState.exchange = interceptor.ExchangeFromContext(req.Context())
+	anthropicState.parameters = interceptor.RequestParameters(body, "model", "system", "messages", "stream", "tools", "metadata")
+
+	// Parse the messages request
+	var messagesReq messagesRequest
+	if err := json.Unmarshal(body, &messagesReq); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse request body", ai.Name)
+	} else {
+		anthropicState.request = messagesReq
+	}
+
+	// Create a new body reader
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return nil
+}
+
+// ResponseInterceptor intercepts the response to extract response messages
+func (ai *MessagesInterceptor) ResponseInterceptor(resp *http.Response, state interceptor.State) error {
+	anthropicState, _ := state.(*messagesState)
+	anthropicState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		anthropicState.upstreamHost = resp.Request.URL.Host
+	}
+	return nil
+}
+
+// ContentInterceptor intercepts content to extract response messages (non-streaming)
+func (ai *MessagesInterceptor) ContentInterceptor(content []byte, state interceptor.State) ([]byte, error) {
+	anthropicState, _ := state.(*messagesState)
+
+	// Parse the response
+	var messagesResp messagesResponse
+	if err := json.Unmarshal(content, &messagesResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response body", ai.Name)
+	} else {
+		anthropicState.response = messagesResp
+	}
+
+	return content, nil
+}
+
+// ChunkInterceptor intercepts chunks for streaming responses
+func (ai *MessagesInterceptor) ChunkInterceptor(chunk []byte, state interceptor.State) ([]byte, error) {
+	anthropicState, _ := state.(*messagesState)
+
+	// Anthropic Server-Sent Events (SSE) format: event: <type>\ndata: {...}
+	ai.applyEvents(anthropicState, anthropicState.decoder.Decode(chunk))
+
+	return chunk, nil
+}
+
+// applyEvents parses decoded stream events and aggregates them into the response
+func (ai *MessagesInterceptor) applyEvents(anthropicState *messagesState, events []interceptor.SSEEvent) {
+	for _, sseEvent := range events {
+		var event streamEvent
+		if err := json.Unmarshal([]byte(sseEvent.Data), &event); err != nil {
+			logrus.WithError(err).Warningf("[%s] Warning: Could not parse response chunk", ai.Name)
+			continue
+		}
+		ai.applyEvent(anthropicState, &event)
+	}
+}
+
+// applyEvent aggregates a single stream event into the response
+func (ai *MessagesInterceptor) applyEvent(anthropicState *messagesState, event *streamEvent) {
+	response := &anthropicState.response
+	switch event.Type {
+	case "message_start":
+		*response = event.Message
+	case "content_block_start":
+		for len(response.Content) <= event.Index {
+			response.Content = append(response.Content, contentBlock{})
+		}
+		response.Content[event.Index] = event.ContentBlock
+	case "content_block_delta":
+		if event.Index >= len(response.Content) {
+			logrus.Warningf("[%s] Warning: Received delta for unknown content block %d", ai.Name, event.Index)
+			return
+		}
+		block := &response.Content[event.Index]
+		switch event.Delta.Type {
+		case "text_delta":
+			block.Text += event.Delta.Text
+		case "thinking_delta":
+			block.Thinking += event.Delta.Thinking
+		case "input_json_delta":
+			partial, ok := anthropicState.partialJSON[event.Index]
+			if !ok {
+				partial = &strings.Builder{}
+				anthropicState.partialJSON[event.Index] = partial
+			}
+			partial.WriteString(event.Delta.PartialJSON)
+		}
+	case "content_block_stop":
+		// The input of a tool_use block is streamed as partial JSON, which is only complete at the end of the block
+		if partial, ok := anthropicState.partialJSON[event.Index]; ok && event.Index < len(response.Content) {
+			if partial.Len() > 0 {
+				response.Content[event.Index].Input = json.RawMessage(partial.String())
+			}
+			delete(anthropicState.partialJSON, event.Index)
+		}
+	case "message_delta":
+		if event.Delta.StopReason != "" {
+			response.StopReason = event.Delta.StopReason
+		}
+		if event.Delta.StopSequence != "" {
+			response.StopSequence = event.Delta.StopSequence
+		}
+		// The usage of message_delta is cumulative
+		if event.Usage.OutputTokens > 0 {
+			response.Usage.OutputTokens = event.Usage.OutputTokens
+		}
+		if event.Usage.InputTokens > 0 {
+			response.Usage.InputTokens = event.Usage.InputTokens
+		}
+	case "error":
+		logrus.Warningf("[%s] Upstream reported error in stream: %s: %s", ai.Name, event.Error.Type, event.Error.Message)
+	}
+}
+
+// OnComplete handles completion of the request
+func (ai *MessagesInterceptor) OnComplete(state interceptor.State) {
+	anthropicState, _ := state.(*messagesState)
+
+	anthropicState.endTime = time.Now()
+	ai.applyEvents(anthropicState, anthropicState.decoder.Flush())
+
+	logrus.Printf("[%s] Request completed for model: %s", ai.Name, anthropicState.request.Model)
+	ai.logRequestResponse(anthropicState)
+
+	ai.saveLog(anthropicState)
+}
+
+// OnError handles errors during request processing
+func (ai *MessagesInterceptor) OnError(state interceptor.State, err error) {
+	anthropicState, _ := state.(*messagesState)
+	anthropicState.endTime = time.Now()
+	ai.applyEvents(anthropicState, anthropicState.decoder.Flush())
+	logrus.WithError(err).Warningf("[%s] Error occurred", ai.Name)
+	ai.logRequestResponse(anthropicState)
+
+	ai.saveLog(anthropicState)
+}
+
+func (ai *MessagesInterceptor) logRequestResponse(anthropicState *messagesState) {
+	if len(anthropicState.request.System) > 0 {
+		logrus.Printf("[%s] Request [system]: %s", ai.Name, anthropicState.request.System.text())
+	}
+	for _, m := range anthropicState.request.Messages {
+		logrus.Printf("[%s] Request [%s]: %s", ai.Name, m.Role, m.Content.text())
+	}
+	logrus.Printf("[%s] Response [%s]: %s", ai.Name, anthropicState.response.Role, anthropicState.response.Content.text())
+}
+
+// convertMessage converts an Anthropic message into simple messages. Since tool results are sent as content
+// blocks of a user message, each tool_result block is converted into a separate message with the role "tool".
+func convertMessage(m message) []storage.SimpleMessage {
+	var result []storage.SimpleMessage
+	var remaining messageContent
+	for _, block := range m.Content {
+		if block.Type == "tool_result" {
+			result = append(result, storage.SimpleMessage{
+				Role:       "tool",
+				Content:    block.Content.text(),
+				ToolCallID: block.ToolUseID,
+			})
+		} else {
+			remaining = append(remaining, block)
+		}
+	}
+
+	text := remaining.text()
+	toolCalls := remaining.toolCalls()
+	if text != "" || len(toolCalls) > 0 || len(result) == 0 {
+		msg := storage.SimpleMessage{
+			Role:      m.Role,
+			Content:   text,
+			ToolCalls: toolCalls,
+		}
+		if thinking := remaining.thinking(); thinking != "" {
+			msg.Metadata = map[string]any{"thinking": thinking}
+		}
+		result = append(result, msg)
+	}
+	return result
+}
+
+func (ai *MessagesInterceptor) saveLog(anthropicState *messagesState) {
+	ctx, cancel := context.WithTimeout(interceptor.WithExchange(context.Background(), anthropicState.exchange), ai.Timeout)
+	defer cancel()
+
+	request := anthropicState.request
+	tools := make([]storage.Tool, len(request.Tools))
+	for i, t := range request.Tools {
+		tools[i] = storage.Tool{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  t.InputSchema,
+		}
+	}
+
+	var history []storage.SimpleMessage
+	if len(request.System) > 0 {
+		history = append(history, storage.SimpleMessage{Role: "system", Content: request.System.text()})
+	}
+	for _, m := range request.Messages {
+		history = append(history, convertMessage(m)...)
+	}
+	for i := range history {
+		history[i].Model = request.Model
+		history[i].ClientHost = anthropicState.clientHost
+		history[i].Tools = tools
+	}
+
+	// Responses without content, e.g. errors, are stored as an empty assistant message keeping the metadata of the
+	// exchange, like the attempts of failed retries
+	response := anthropicState.response
+	metadata := anthropicState.exchange.Metadata()
+	if thinking := response.Content.thinking(); thinking != "" {
+		metadata["thinking"] = thinking
+	}
+	if response.StopReason != "" {
+		metadata["finish_reason"] = response.StopReason
+	}
+	if anthropicState.parameters != nil {
+		metadata["parameters"] = anthropicState.parameters
+	}
+
+	assistantMsg := storage.SimpleMessage{
+		Role:             response.Role,
+		Content:          response.Content.text(),
+		Model:            response.Model,
+		PromptTokens:     response.Usage.promptTokens(),
+		CompletionTokens: response.Usage.OutputTokens,
+		EvalDuration:     anthropicState.endTime.Sub(anthropicState.startTime),
+		UpstreamHost:     anthropicState.upstreamHost,
+		Metadata:         metadata,
+		Tools:            tools,
+		ToolCalls:        response.Content.toolCalls(),
+	}
+	if assistantMsg.Role == "" {
+		assistantMsg.Role = "assistant"
+	}
+
+	ai.SaveToStorage(ctx, history, assistantMsg, anthropicState.statusCode, "chat")
+}
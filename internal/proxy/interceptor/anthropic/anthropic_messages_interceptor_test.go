@@ -0,0 +1,236 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"io"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessagesInterceptor_RequestInterceptor_ParsesContent(t *testing.T) {
+	interceptor := &MessagesInterceptor{}
+	state := interceptor.CreateState()
+
+	requestBody := `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "You are a weather bot."}],
+		"messages": [
+			{"role": "user", "content": "What's the weather in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "toolu_01", "name": "get_weather", "input": {"location": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_01", "content": [{"type": "text", "text": "15 degrees"}]}
+			]}
+		],
+		"tools": [{"name": "get_weather", "description": "Get the weather", "input_schema": {"type": "object"}}]
+	}`
+
+	req, _ := http.NewRequest("POST", "/v1/messages", bytes.NewBufferString(requestBody))
+	err := interceptor.RequestInterceptor(req, state)
+	assert.NoError(t, err)
+
+	// The request body must be passed on unmodified
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, requestBody, string(body))
+
+	anthropicState := state.(*messagesState)
+	assert.Equal(t, "claude-sonnet-4-5", anthropicState.request.Model)
+	assert.Equal(t, "You are a weather bot.", anthropicState.request.System.text())
+	assert.Len(t, anthropicState.request.Messages, 3)
+	assert.Equal(t, "What's the weather in Paris?", anthropicState.request.Messages[0].Content.text())
+	assert.Len(t, anthropicState.request.Tools, 1)
+	assert.Equal(t, map[string]any{"max_tokens": float64(1024)}, anthropicState.parameters)
+}
+
+func TestMessagesInterceptor_ContentInterceptor(t *testing.T) {
+	interceptor := &MessagesInterceptor{}
+	state := interceptor.CreateState()
+
+	responseBody := `{
+		"id": "msg_01",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [
+			{"type": "thinking", "thinking": "The user wants the weather.", "signature": "abc"},
+			{"type": "text", "text": "I'll look that up."},
+			{"type": "tool_use", "id": "toolu_01", "name": "get_weather", "input": {"location": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 20, "cache_read_input_tokens": 5, "output_tokens": 12}
+	}`
+
+	_, err := interceptor.ContentInterceptor([]byte(responseBody), state)
+	assert.NoError(t, err)
+
+	response := state.(*messagesState).response
+	assert.Equal(t, "I'll look that up.", response.Content.text())
+	assert.Equal(t, "The user wants the weather.", response.Content.thinking())
+	assert.Equal(t, "tool_use", response.StopReason)
+	assert.Equal(t, 25, response.Usage.promptTokens())
+
+	toolCalls := response.Content.toolCalls()
+	assert.Len(t, toolCalls, 1)
+	assert.Equal(t, "toolu_01", toolCalls[0].ID)
+	assert.Equal(t, "get_weather", toolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"location": "Paris"}`, toolCalls[0].Function.Arguments)
+}
+
+func TestMessagesInterceptor_ChunkInterceptor_AggregatesEvents(t *testing.T) {
+	interceptor := &MessagesInterceptor{}
+	state := interceptor.CreateState()
+
+	chunks := []string{
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_01\",\"type\":\"message\",\"role\":\"assistant\",\"model\":\"claude-sonnet-4-5\",\"content\":[],\"stop_reason\":null,\"usage\":{\"input_tokens\":25,\"output_tokens\":1}}}\n\n",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n",
+		"event: ping\ndata: {\"type\":\"ping\"}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Let me \"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"check.\"}}\n\n",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_01\",\"name\":\"get_weather\",\"input\":{}}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"location\\\":\"}}\n\n",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\" \\\"Paris\\\"}\"}}\n\n",
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n",
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\",\"stop_sequence\":null},\"usage\":{\"output_tokens\":42}}\n\n",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+	}
+
+	for _, chunk := range chunks {
+		out, err := interceptor.ChunkInterceptor([]byte(chunk), state)
+		assert.NoError(t, err)
+		assert.Equal(t, chunk, string(out))
+	}
+
+	response := state.(*messagesState).response
+	assert.Equal(t, "msg_01", response.ID)
+	assert.Equal(t, "assistant", response.Role)
+	assert.Equal(t, "claude-sonnet-4-5", response.Model)
+	assert.Equal(t, "Let me check.", response.Content.text())
+	assert.Equal(t, "tool_use", response.StopReason)
+	assert.Equal(t, 25, response.Usage.InputTokens)
+	assert.Equal(t, 42, response.Usage.OutputTokens)
+
+	toolCalls := response.Content.toolCalls()
+	assert.Len(t, toolCalls, 1)
+	assert.Equal(t, "toolu_01", toolCalls[0].ID)
+	assert.Equal(t, `{"location": "Paris"}`, toolCalls[0].Function.Arguments)
+}
+
+func TestMessagesInterceptor_SaveLog_MapsToolBlocks(t *testing.T) {
+	mockStorage := &mockStorage{}
+	interceptor := &MessagesInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: mockStorage,
+			Timeout: 1 * time.Second,
+		},
+	}
+	state := interceptor.CreateState().(*messagesState)
+	state.statusCode = 200
+	state.request = messagesRequest{
+		Model:  "claude-sonnet-4-5",
+		System: messageContent{{Type: "text", Text: "You are a weather bot."}},
+		Messages: []message{
+			{Role: "user", Content: messageContent{{Type: "text", Text: "Weather in Paris?"}}},
+			{Role: "assistant", Content: messageContent{
+				{Type: "tool_use", ID: "toolu_01", Name: "get_weather", Input: []byte(`{"location":"Paris"}`)},
+			}},
+			{Role: "user", Content: messageContent{
+				{Type: "tool_result", ToolUseID: "toolu_01", Content: messageContent{{Type: "text", Text: "15 degrees"}}},
+				{Type: "text", Text: "And in London?"},
+			}},
+		},
+		Tools: []toolDefinition{{Name: "get_weather", InputSchema: []byte(`{"type":"object"}`)}},
+	}
+	state.response = messagesResponse{
+		Role:    "assistant",
+		Model:   "claude-sonnet-4-5",
+		Content: messageContent{{Type: "text", Text: "It's 15 degrees in Paris."}},
+		Usage:   messagesUsage{InputTokens: 30, OutputTokens: 10},
+	}
+
+	interceptor.saveLog(state)
+
+	messages := mockStorage.messages
+	assert.Len(t, messages, 6)
+	assert.Equal(t, "system", messages[0].Role)
+	assert.Equal(t, "user", messages[1].Role)
+	assert.Equal(t, "assistant", messages[2].Role)
+	assert.Len(t, messages[2].ToolCalls, 1)
+	assert.Equal(t, "get_weather", messages[2].ToolCalls[0].Function.Name)
+	assert.Equal(t, `{"location":"Paris"}`, messages[2].ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "tool", messages[3].Role)
+	assert.Equal(t, "toolu_01", messages[3].ToolCallID)
+	assert.Equal(t, "15 degrees", messages[3].Content)
+	assert.Equal(t, "user", messages[4].Role)
+	assert.Equal(t, "And in London?", messages[4].Content)
+
+	assistant := messages[5]
+	assert.Equal(t, "assistant", assistant.Role)
+	assert.Equal(t, "It's 15 degrees in Paris.", assistant.Content)
+	assert.Equal(t, 30, assistant.PromptTokens)
+	assert.Equal(t, 10, assistant.CompletionTokens)
+	assert.Len(t, assistant.Tools, 1)
+	assert.Equal(t, 200, mockStorage.lastStatusCode)
+}
+
+func TestMessagesInterceptor_SaveLog_ErrorKeepsExchangeMetadata(t *testing.T) {
+	mockStorage := &mockStorage{}
+	interceptor := &MessagesInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: mockStorage,
+			Timeout: 1 * time.Second,
+		},
+	}
+	state := interceptor.CreateState().(*messagesState)
+	state.statusCode = 529
+	state.upstreamHost = "upstream:8080"
+	state.exchange = interceptor2.NewExchange()
+	state.exchange.SetMetadata("attempts", 3)
+	state.request = messagesRequest{
+		Model:    "claude-sonnet-4-5",
+		Messages: []message{{Role: "user", Content: messageContent{{Type: "text", Text: "Hello"}}}},
+	}
+
+	interceptor.saveLog(state)
+
+	// The failed request is stored without content but with the metadata of the exchange
+	messages := mockStorage.messages
+	assert.Len(t, messages, 2)
+	assistant := messages[1]
+	assert.Equal(t, "assistant", assistant.Role)
+	assert.Empty(t, assistant.Content)
+	assert.Equal(t, "upstream:8080", assistant.UpstreamHost)
+	assert.Equal(t, 3, assistant.Metadata["attempts"])
+	assert.Equal(t, 529, mockStorage.lastStatusCode)
+}
+
+type mockStorage struct {
+	storage.Storage
+	messages       []storage.SimpleMessage
+	lastStatusCode int
+}
+
+func (m *mockStorage) FindMessageByHistory(ctx context.Context, history []storage.SimpleMessage, requestType string) (uuid.UUID, error) {
+	return uuid.Nil, nil
+}
+
+func (m *mockStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*storage.Conversation, *storage.Branch, error) {
+	return &storage.Conversation{ID: uuid.New()}, &storage.Branch{ID: uuid.New()}, nil
+}
+
+func (m *mockStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *storage.Message) (*storage.Message, error) {
+	m.messages = append(m.messages, message.SimpleMessage)
+	m.lastStatusCode = message.UpstreamStatusCode
+	return &storage.Message{ID: uuid.New(), SimpleMessage: message.SimpleMessage}, nil
+}
@@ -0,0 +1,195 @@
+package interceptor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AbortError stops the processing of a request. Returned by RequestInterceptor or ResponseInterceptor, the proxy
+// responds with the status code instead of the upstream response. Returned while the body is processed, the response
+// ends early, as its status code has already been sent.
+type AbortError struct {
+	StatusCode int
+	Err        error
+}
+
+// Abort returns an error aborting the request with the status code
+func Abort(statusCode int, err error) error {
+	return &AbortError{StatusCode: statusCode, Err: err}
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("request aborted with status code %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// ShortCircuit answers a request without forwarding it upstream. Returned by RequestInterceptor, the proxy sends the
+// response to the client, passing it to the response and content hooks of the interceptors which have handled the
+// request before.
+type ShortCircuit struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Respond returns an error short-circuiting the request with the response
+func Respond(statusCode int, header http.Header, body []byte) error {
+	return &ShortCircuit{StatusCode: statusCode, Header: header, Body: body}
+}
+
+func (s *ShortCircuit) Error() string {
+	return fmt.Sprintf("request short-circuited with status code %d", s.StatusCode)
+}
+
+// Response returns the response for the request
+func (s *ShortCircuit) Response(req *http.Request) *http.Response {
+	header := s.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(s.Body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", s.StatusCode, http.StatusText(s.StatusCode)),
+		StatusCode:    s.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(s.Body)),
+		ContentLength: int64(len(s.Body)),
+		Request:       req,
+	}
+}
+
+// isControlError reports whether an error returned by an interceptor stops the chain
+func isControlError(err error) bool {
+	var abort *AbortError
+	var shortCircuit *ShortCircuit
+	return errors.As(err, &abort) || errors.As(err, &shortCircuit)
+}
+
+// Chain combines several interceptors handling the same requests. Request hooks run in order, response, content and
+// chunk hooks as well as OnComplete and OnError in reverse order, so the first interceptor sees the request first and
+// the response last, like the outermost layer of a middleware stack. Content and chunks are passed from one
+// interceptor to the next.
+//
+// Other errors than AbortError and ShortCircuit are logged and the chain continues with the next interceptor, keeping
+// the content of the previous one for content and chunk hooks. An AbortError stops the chain and is returned.
+// A ShortCircuit stops the chain as well, the response is only passed to the interceptors before the one which
+// returned it.
+type Chain struct {
+	interceptors []Interceptor
+}
+
+// chainState contains the states of the interceptors of a chain
+type chainState struct {
+	states []State
+	// entered is the number of interceptors whose RequestInterceptor has been called,
+	// responders the number of interceptors which see the response
+	entered    int
+	responders int
+}
+
+// NewChain creates a chain of the interceptors
+func NewChain(interceptors ...Interceptor) *Chain {
+	return &Chain{interceptors: interceptors}
+}
+
+// Interceptors returns the interceptors of the chain in order
+func (c *Chain) Interceptors() []Interceptor {
+	return c.interceptors
+}
+
+func (c *Chain) CreateState() State {
+	states := make([]State, len(c.interceptors))
+	for i, interceptor := range c.interceptors {
+		states[i] = interceptor.CreateState()
+	}
+	return &chainState{states: states}
+}
+
+func (c *Chain) RequestInterceptor(req *http.Request, state State) error {
+	cs := state.(*chainState)
+	for i, interceptor := range c.interceptors {
+		cs.entered, cs.responders = i+1, i+1
+		if err := interceptor.RequestInterceptor(req, cs.states[i]); err != nil {
+			if isControlError(err) {
+				var shortCircuit *ShortCircuit
+				if errors.As(err, &shortCircuit) {
+					cs.responders = i
+				}
+				return err
+			}
+			logrus.WithError(err).Warnf("Error in intercepting request by interceptor %d of the chain", i+1)
+		}
+	}
+	return nil
+}
+
+func (c *Chain) ResponseInterceptor(resp *http.Response, state State) error {
+	cs := state.(*chainState)
+	for i := cs.responders - 1; i >= 0; i-- {
+		if err := c.interceptors[i].ResponseInterceptor(resp, cs.states[i]); err != nil {
+			if isControlError(err) {
+				return err
+			}
+			logrus.WithError(err).Warnf("Error in intercepting response by interceptor %d of the chain", i+1)
+		}
+	}
+	return nil
+}
+
+func (c *Chain) ContentInterceptor(content []byte, state State) ([]byte, error) {
+	cs := state.(*chainState)
+	for i := cs.responders - 1; i >= 0; i-- {
+		processed, err := c.interceptors[i].ContentInterceptor(content, cs.states[i])
+		if err != nil {
+			if isControlError(err) {
+				return nil, err
+			}
+			logrus.WithError(err).Warnf("Error in intercepting body by interceptor %d of the chain", i+1)
+			continue
+		}
+		content = processed
+	}
+	return content, nil
+}
+
+func (c *Chain) ChunkInterceptor(chunk []byte, state State) ([]byte, error) {
+	cs := state.(*chainState)
+	for i := cs.responders - 1; i >= 0; i-- {
+		processed, err := c.interceptors[i].ChunkInterceptor(chunk, cs.states[i])
+		if err != nil {
+			if isControlError(err) {
+				return nil, err
+			}
+			logrus.WithError(err).Warnf("Error in intercepting chunk by interceptor %d of the chain", i+1)
+			continue
+		}
+		chunk = processed
+	}
+	return chunk, nil
+}
+
+func (c *Chain) OnComplete(state State) {
+	cs := state.(*chainState)
+	for i := cs.entered - 1; i >= 0; i-- {
+		c.interceptors[i].OnComplete(cs.states[i])
+	}
+}
+
+func (c *Chain) OnError(state State, err error) {
+	cs := state.(*chainState)
+	for i := cs.entered - 1; i >= 0; i-- {
+		c.interceptors[i].OnError(cs.states[i], err)
+	}
+}
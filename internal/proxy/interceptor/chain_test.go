@@ -0,0 +1,136 @@
+package interceptor
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// recordingInterceptor records the hooks called on it in a log shared with other interceptors
+type recordingInterceptor struct {
+	name       string
+	log        *[]string
+	requestErr error
+	contentErr error
+}
+
+func (ri *recordingInterceptor) CreateState() State { return &EmptyState{} }
+func (ri *recordingInterceptor) RequestInterceptor(_ *http.Request, _ State) error {
+	*ri.log = append(*ri.log, ri.name+".request")
+	return ri.requestErr
+}
+func (ri *recordingInterceptor) ResponseInterceptor(_ *http.Response, _ State) error {
+	*ri.log = append(*ri.log, ri.name+".response")
+	return nil
+}
+func (ri *recordingInterceptor) ContentInterceptor(content []byte, _ State) ([]byte, error) {
+	*ri.log = append(*ri.log, ri.name+".content")
+	if ri.contentErr != nil {
+		return nil, ri.contentErr
+	}
+	return append(content, ri.name...), nil
+}
+func (ri *recordingInterceptor) ChunkInterceptor(chunk []byte, _ State) ([]byte, error) {
+	return append(chunk, ri.name...), nil
+}
+func (ri *recordingInterceptor) OnComplete(_ State) {
+	*ri.log = append(*ri.log, ri.name+".complete")
+}
+func (ri *recordingInterceptor) OnError(_ State, _ error) {
+	*ri.log = append(*ri.log, ri.name+".error")
+}
+
+func TestChain_Order(t *testing.T) {
+	var log []string
+	chain := NewChain(
+		&recordingInterceptor{name: "a", log: &log},
+		&recordingInterceptor{name: "b", log: &log, requestErr: errors.New("ignored"), contentErr: errors.New("ignored")},
+		&recordingInterceptor{name: "c", log: &log},
+	)
+	state := chain.CreateState()
+
+	req, _ := http.NewRequest("POST", "/api/chat", nil)
+	if err := chain.RequestInterceptor(req, state); err != nil {
+		t.Fatalf("Expected errors to be ignored, got %v", err)
+	}
+	if err := chain.ResponseInterceptor(&http.Response{}, state); err != nil {
+		t.Fatalf("ResponseInterceptor failed: %v", err)
+	}
+	content, err := chain.ContentInterceptor([]byte(">"), state)
+	if err != nil || string(content) != ">ca" {
+		t.Errorf("Expected content passed in reverse order skipping the failed interceptor, got %q (%v)", content, err)
+	}
+	chunk, _ := chain.ChunkInterceptor([]byte(">"), state)
+	if string(chunk) != ">cba" {
+		t.Errorf("Expected chunk passed in reverse order, got %q", chunk)
+	}
+	chain.OnComplete(state)
+
+	expected := []string{
+		"a.request", "b.request", "c.request",
+		"c.response", "b.response", "a.response",
+		"c.content", "b.content", "a.content",
+		"c.complete", "b.complete", "a.complete",
+	}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Unexpected order of hooks:\n%v\nexpected\n%v", log, expected)
+	}
+}
+
+func TestChain_Abort(t *testing.T) {
+	var log []string
+	chain := NewChain(
+		&recordingInterceptor{name: "a", log: &log},
+		&recordingInterceptor{name: "b", log: &log, requestErr: Abort(http.StatusForbidden, errors.New("model not allowed"))},
+		&recordingInterceptor{name: "c", log: &log},
+	)
+	state := chain.CreateState()
+
+	req, _ := http.NewRequest("POST", "/api/chat", nil)
+	err := chain.RequestInterceptor(req, state)
+	var abort *AbortError
+	if !errors.As(err, &abort) || abort.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the request to be aborted, got %v", err)
+	}
+	chain.OnError(state, err)
+
+	expected := []string{"a.request", "b.request", "b.error", "a.error"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Unexpected order of hooks:\n%v\nexpected\n%v", log, expected)
+	}
+}
+
+func TestChain_ShortCircuit(t *testing.T) {
+	var log []string
+	chain := NewChain(
+		&recordingInterceptor{name: "a", log: &log},
+		&recordingInterceptor{name: "b", log: &log, requestErr: Respond(http.StatusOK, nil, []byte("cached"))},
+		&recordingInterceptor{name: "c", log: &log},
+	)
+	state := chain.CreateState()
+
+	req, _ := http.NewRequest("POST", "/api/chat", nil)
+	err := chain.RequestInterceptor(req, state)
+	var shortCircuit *ShortCircuit
+	if !errors.As(err, &shortCircuit) {
+		t.Fatalf("Expected the request to be short-circuited, got %v", err)
+	}
+	resp := shortCircuit.Response(req)
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 6 || resp.Header.Get("Content-Length") != "6" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if err := chain.ResponseInterceptor(resp, state); err != nil {
+		t.Fatalf("ResponseInterceptor failed: %v", err)
+	}
+	content, _ := chain.ContentInterceptor(shortCircuit.Body, state)
+	if string(content) != "cacheda" {
+		t.Errorf("Expected the response to be passed to the interceptors before the short-circuit, got %q", content)
+	}
+	chain.OnComplete(state)
+
+	expected := []string{"a.request", "b.request", "a.response", "a.content", "b.complete", "a.complete"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Unexpected order of hooks:\n%v\nexpected\n%v", log, expected)
+	}
+}
@@ -0,0 +1,165 @@
+package interceptor
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Exchange collects information about a single proxied request, which is gathered by the proxy outside
+// the interceptors. The proxy attaches it to the request context before calling RequestInterceptor, so
+// interceptors can keep a reference in their state and store the information alongside their messages.
+//
+// In turn, interceptors report the record they have saved for the exchange, from which the proxy derives metrics.
+type Exchange struct {
+	mu         sync.Mutex
+	metadata   map[string]any
+	params     map[string]string
+	upstream   string
+	firstChunk time.Time
+	record     *Record
+	saveErr    error
+}
+
+type exchangeKey struct{}
+
+// NewExchange creates a new empty exchange
+func NewExchange() *Exchange {
+	return &Exchange{
+		metadata: make(map[string]any),
+	}
+}
+
+// WithExchange returns a copy of the context carrying the exchange
+func WithExchange(ctx context.Context, exchange *Exchange) context.Context {
+	return context.WithValue(ctx, exchangeKey{}, exchange)
+}
+
+// ExchangeFromContext returns the exchange of a request context, or nil if there is none.
+// All methods of Exchange can safely be called on a nil exchange.
+func ExchangeFromContext(ctx context.Context) *Exchange {
+	exchange, _ := ctx.Value(exchangeKey{}).(*Exchange)
+	return exchange
+}
+
+// SetMetadata sets a metadata entry which should be stored with the response message
+func (e *Exchange) SetMetadata(key string, value any) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metadata[key] = value
+}
+
+// Metadata returns a copy of all metadata entries. The result is never nil, so callers may add further entries.
+func (e *Exchange) Metadata() map[string]any {
+	if e == nil {
+		return make(map[string]any)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return maps.Clone(e.metadata)
+}
+
+// SetParams sets the parameters of the route matched by the request, e.g. the deployment name of an Azure OpenAI
+// path. They are also stored as "path_params" metadata with the response message.
+func (e *Exchange) SetParams(params map[string]string) {
+	if e == nil || len(params) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.params = maps.Clone(params)
+	e.metadata["path_params"] = maps.Clone(params)
+}
+
+// Params returns a copy of the parameters of the route matched by the request, or nil if there are none
+func (e *Exchange) Params() map[string]string {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return maps.Clone(e.params)
+}
+
+// SetUpstream sets the name of the upstream the request has been forwarded to
+func (e *Exchange) SetUpstream(name string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.upstream = name
+}
+
+// Upstream returns the name of the upstream the request has been forwarded to, or "" if it hasn't been forwarded
+func (e *Exchange) Upstream() string {
+	if e == nil {
+		return ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.upstream
+}
+
+// MarkFirstChunk records the time the first chunk of a streaming response has been sent to the client. Only the
+// first call has an effect.
+func (e *Exchange) MarkFirstChunk() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.firstChunk.IsZero() {
+		e.firstChunk = time.Now()
+	}
+}
+
+// FirstChunk returns the time the first chunk of a streaming response has been sent, or the zero time if the
+// response hasn't been streamed
+func (e *Exchange) FirstChunk() time.Time {
+	if e == nil {
+		return time.Time{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.firstChunk
+}
+
+// SetRecord sets the record saved for the exchange and the error saving it, if it couldn't be saved or queued
+func (e *Exchange) SetRecord(record Record, err error) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.record = &record
+	e.saveErr = err
+}
+
+// Record returns the record saved for the exchange. It returns false if no record has been saved, e.g. because the
+// request has not been intercepted.
+func (e *Exchange) Record() (Record, bool) {
+	if e == nil {
+		return Record{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.record == nil {
+		return Record{}, false
+	}
+	return *e.record, true
+}
+
+// SaveError returns the error saving the record of the exchange, or nil if it has been saved or queued
+func (e *Exchange) SaveError() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.saveErr
+}
@@ -38,46 +38,74 @@ type Interceptor interface {
 
 // Manager InterceptorManager manages all interceptors
 type Manager struct {
-	interceptors map[string]map[string]Interceptor
-	mu           sync.RWMutex
+	routes []*route
+	mu     sync.RWMutex
 }
 
 // NewInterceptorManager creates a new interceptor manager
 func NewInterceptorManager() *Manager {
-	return &Manager{
-		interceptors: make(map[string]map[string]Interceptor),
-	}
+	return &Manager{}
+}
+
+// RegisterInterceptor registers an interceptor for an endpoint pattern and method, see Route for the syntax of the
+// patterns.
+func (im *Manager) RegisterInterceptor(endpoint string, method string, interceptor Interceptor) error {
+	return im.RegisterRoute(Route{Pattern: endpoint, Method: method}, interceptor)
 }
 
-// RegisterInterceptor registers an interceptor for a specific endpoint and method
-func (im *Manager) RegisterInterceptor(endpoint string, method string, interceptor Interceptor) {
+// RegisterRoute registers an interceptor for the requests matching the route. Interceptors registered for the same
+// pattern and prefix are combined in the order of their registration.
+func (im *Manager) RegisterRoute(r Route, interceptor Interceptor) error {
+	compiled, err := newRoute(r, interceptor)
+	if err != nil {
+		return err
+	}
 	im.mu.Lock()
 	defer im.mu.Unlock()
-	if _, exists := im.interceptors[endpoint]; !exists {
-		im.interceptors[endpoint] = make(map[string]Interceptor)
-	}
-	im.interceptors[endpoint][method] = interceptor
+	im.routes = append(im.routes, compiled)
+	return nil
 }
 
-// GetInterceptor retrieves an interceptor for an endpoint and method
-func (im *Manager) GetInterceptor(endpoint string, method string) Interceptor {
+// GetInterceptor retrieves the interceptor for a path and method, or nil if no route matches
+func (im *Manager) GetInterceptor(path string, method string) Interceptor {
+	match, _ := im.Match(path, method)
+	return match.Interceptor
+}
+
+// Match finds the route of a request. If several routes match, the most specific pattern takes precedence, see
+// compareRoutes, and the route registered first among equally specific ones. The interceptors of all routes with
+// the same pattern and prefix which match the method are combined in a Chain in the order of their registration.
+func (im *Manager) Match(path string, method string) (RouteMatch, bool) {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 
-	methods, exists := im.interceptors[endpoint]
-	if !exists {
-		return nil
+	var best *route
+	var match RouteMatch
+	for _, r := range im.routes {
+		if r.Method != method && r.Method != "*" {
+			continue
+		}
+		if best != nil && compareRoutes(r, best) <= 0 {
+			continue
+		}
+		if params, stripped, ok := r.match(path); ok {
+			best = r
+			match = RouteMatch{Route: r.Route, Params: params, Path: stripped}
+		}
 	}
-
-	// Try exact method match
-	if interceptor, exists := methods[method]; exists {
-		return interceptor
+	if best == nil {
+		return RouteMatch{}, false
 	}
 
-	// Try wildcard method match
-	if interceptor, exists := methods["*"]; exists {
-		return interceptor
+	var interceptors []Interceptor
+	for _, r := range im.routes {
+		if r.key() == best.key() && (r.Method == method || r.Method == "*") {
+			interceptors = append(interceptors, r.interceptor)
+		}
 	}
-
-	return nil
+	match.Interceptor = interceptors[0]
+	if len(interceptors) > 1 {
+		match.Interceptor = NewChain(interceptors...)
+	}
+	return match, true
 }
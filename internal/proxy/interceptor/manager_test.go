@@ -59,3 +59,24 @@ func TestManager_GetInterceptor(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_GetInterceptor_Chain(t *testing.T) {
+	m := NewInterceptorManager()
+
+	logging := &MockInterceptor{}
+	saving := &MockInterceptor{}
+	m.RegisterInterceptor("/api/chat", "*", logging)
+	m.RegisterInterceptor("/api/chat", "POST", saving)
+
+	chain, ok := m.GetInterceptor("/api/chat", "POST").(*Chain)
+	if !ok {
+		t.Fatalf("Expected a chain for several interceptors")
+	}
+	interceptors := chain.Interceptors()
+	if len(interceptors) != 2 || interceptors[0] != logging || interceptors[1] != saving {
+		t.Errorf("Expected interceptors in the order of their registration, got %v", interceptors)
+	}
+	if got := m.GetInterceptor("/api/chat", "GET"); got != logging {
+		t.Errorf("Expected only the wildcard interceptor for GET, got %v", got)
+	}
+}
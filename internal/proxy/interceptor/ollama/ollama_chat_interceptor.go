@@ -7,6 +7,7 @@ import (
 	"io"
 	interceptor2 "llm-monitor/internal/proxy/interceptor"
 	"llm-monitor/internal/storage"
+	"maps"
 	"net/http"
 	"time"
 
@@ -20,15 +21,57 @@ type ChatInterceptor struct {
 
 // chatMessage represents a chat message
 type chatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string         `json:"role"`
+	Content   string         `json:"content"`
+	Thinking  string         `json:"thinking,omitempty"`
+	Images    []string       `json:"images,omitempty"`
+	ToolCalls []chatToolCall `json:"tool_calls,omitempty"`
+	ToolName  string         `json:"tool_name,omitempty"`
+}
+
+// chatToolCall represents a tool call of the model. In contrast to OpenAI, the arguments are a JSON object.
+type chatToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Index     int             `json:"index,omitempty"`
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// chatTool represents a tool definition of a chat request
+type chatTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
 }
 
 // chatRequest represents the structure of a chat request
 type chatRequest struct {
-	Model    string        `json:"model"`
-	Messages []chatMessage `json:"messages"`
-	Stream   bool          `json:"stream"`
+	Model     string                 `json:"model"`
+	Messages  []chatMessage          `json:"messages"`
+	Tools     []chatTool             `json:"tools,omitempty"`
+	Stream    *bool                  `json:"stream,omitempty"`
+	Format    json.RawMessage        `json:"format,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	Think     json.RawMessage        `json:"think,omitempty"`
+	KeepAlive json.RawMessage        `json:"keep_alive,omitempty"`
+}
+
+// parameters returns the options of the request together with format, think and keep_alive
+func (r *chatRequest) parameters() map[string]any {
+	parameters := make(map[string]any, len(r.Options)+3)
+	maps.Copy(parameters, r.Options)
+	for key, raw := range map[string]json.RawMessage{"format": r.Format, "think": r.Think, "keep_alive": r.KeepAlive} {
+		var value any
+		if len(raw) > 0 && json.Unmarshal(raw, &value) == nil && value != nil {
+			parameters[key] = value
+		}
+	}
+	return parameters
 }
 
 // chatResponse represents the structure of a chat response
@@ -55,6 +98,8 @@ type chatState struct {
 	statusCode   int
 	clientHost   string
 	upstreamHost string
+	exchange     *interceptor2.Exchange
+	decoder      interceptor2.NDJSONDecoder
 }
 
 // CreateState creates a new state for the interceptor
@@ -79,8 +124,8 @@ func (oi *ChatInterceptor) RequestInterceptor(req *http.Request, state intercept
 
 	// Extract model name
 	ollamaState, _ := state.(*chatState)
-	ollamaState.upstreamHost = req.Host
 	ollamaState.clientHost = req.Header.Get("X-Forwarded-For")
+	ollamaState.exchange = interceptor2.ExchangeFromContext(req.Context())
 
 	// Parse the chat request
 	var chatReq chatRequest
@@ -90,9 +135,6 @@ func (oi *ChatInterceptor) RequestInterceptor(req *http.Request, state intercept
 		ollamaState.request = chatReq
 	}
 
-	// Store available request information
-	oi.saveLog(ollamaState)
-
 	// Create a new body reader
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
 
@@ -103,6 +145,10 @@ func (oi *ChatInterceptor) RequestInterceptor(req *http.Request, state intercept
 func (oi *ChatInterceptor) ResponseInterceptor(resp *http.Response, state interceptor2.State) error {
 	ollamaState, _ := state.(*chatState)
 	ollamaState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		ollamaState.upstreamHost = resp.Request.URL.Host
+	}
 	return nil
 }
 
@@ -125,25 +171,44 @@ func (oi *ChatInterceptor) ContentInterceptor(content []byte, state interceptor2
 func (oi *ChatInterceptor) ChunkInterceptor(chunk []byte, state interceptor2.State) ([]byte, error) {
 	ollamaState, _ := state.(*chatState)
 
-	// Parse the response to extract details
+	// Ollama streams newline delimited JSON objects
+	for _, line := range ollamaState.decoder.Decode(chunk) {
+		oi.applyChunk(ollamaState, line)
+	}
+
+	return chunk, nil
+}
+
+// applyChunk merges a single streamed response object into the response
+func (oi *ChatInterceptor) applyChunk(ollamaState *chatState, line []byte) {
 	var chatResp chatResponse
-	if err := json.Unmarshal(chunk, &chatResp); err != nil {
+	if err := json.Unmarshal(line, &chatResp); err != nil {
 		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response chunk", oi.Name)
-	} else {
-		currentResponse := ollamaState.response.Message.Content + chatResp.Message.Content
-		if chatResp.Done {
-			ollamaState.response = chatResp
-		}
-		ollamaState.response.Message.Content = currentResponse
+		return
 	}
 
-	return chunk, nil
+	// Content and thinking are streamed incrementally, while tool calls are sent as a whole
+	message := ollamaState.response.Message
+	message.Content += chatResp.Message.Content
+	message.Thinking += chatResp.Message.Thinking
+	message.Images = append(message.Images, chatResp.Message.Images...)
+	message.ToolCalls = append(message.ToolCalls, chatResp.Message.ToolCalls...)
+	if chatResp.Message.Role != "" {
+		message.Role = chatResp.Message.Role
+	}
+	if chatResp.Done || ollamaState.response.Model == "" {
+		ollamaState.response = chatResp
+	}
+	ollamaState.response.Message = message
 }
 
 // OnComplete handles completion of the request
 func (oi *ChatInterceptor) OnComplete(state interceptor2.State) {
 	ollamaState, _ := state.(*chatState)
 	ollamaState.endTime = time.Now()
+	for _, line := range ollamaState.decoder.Flush() {
+		oi.applyChunk(ollamaState, line)
+	}
 
 	logrus.Printf("[%s] Request completed for model: %s", oi.Name, ollamaState.response.Model)
 	oi.logRequestResponse(ollamaState)
@@ -155,6 +220,9 @@ func (oi *ChatInterceptor) OnComplete(state interceptor2.State) {
 func (oi *ChatInterceptor) OnError(state interceptor2.State, err error) {
 	ollamaState, _ := state.(*chatState)
 	ollamaState.endTime = time.Now()
+	for _, line := range ollamaState.decoder.Flush() {
+		oi.applyChunk(ollamaState, line)
+	}
 	logrus.WithError(err).Warningf("[%s] Error occurred", oi.Name)
 	oi.logRequestResponse(ollamaState)
 
@@ -168,33 +236,90 @@ func (oi *ChatInterceptor) logRequestResponse(ollamaState *chatState) {
 	logrus.Printf("[%s] Response [%s]: %s", oi.Name, ollamaState.response.Message.Role, ollamaState.response.Message.Content)
 }
 
-func (oi *ChatInterceptor) saveLog(ollamaState *chatState) {
-	if oi.Storage != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), oi.Timeout)
-		defer cancel()
-
-		history := make([]storage.SimpleMessage, len(ollamaState.request.Messages))
-		for i, m := range ollamaState.request.Messages {
-			history[i] = storage.SimpleMessage{Role: m.Role, Content: m.Content, Model: ollamaState.request.Model, ClientHost: ollamaState.clientHost}
+// convertMessage converts an Ollama chat message into a simple message. Thinking and images are kept
+// in the metadata of the message.
+func convertMessage(m chatMessage) storage.SimpleMessage {
+	toolCalls := make([]storage.ToolCall, len(m.ToolCalls))
+	for i, tc := range m.ToolCalls {
+		toolCalls[i] = storage.ToolCall{
+			ID:   tc.ID,
+			Type: "function",
 		}
-		var evalDuration time.Duration
-		if ollamaState.response.EvalDuration > 0 {
-			evalDuration = time.Duration(ollamaState.response.EvalDuration)
-		} else if !ollamaState.endTime.IsZero() {
-			evalDuration = ollamaState.endTime.Sub(ollamaState.startTime)
+		toolCalls[i].Function.Name = tc.Function.Name
+		toolCalls[i].Function.Arguments = string(tc.Function.Arguments)
+	}
+
+	metadata := make(map[string]any)
+	if m.Thinking != "" {
+		metadata["thinking"] = m.Thinking
+	}
+	if len(m.Images) > 0 {
+		attachments := make([]map[string]any, len(m.Images))
+		for i, image := range m.Images {
+			attachments[i] = map[string]any{"type": "image", "data": image}
 		}
+		metadata["attachments"] = attachments
+	}
+	if m.ToolName != "" {
+		metadata["tool_name"] = m.ToolName
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
 
-		assistantMsg := storage.SimpleMessage{
-			Role:               ollamaState.response.Message.Role,
-			Content:            ollamaState.response.Message.Content,
-			Model:              ollamaState.response.Model,
-			PromptTokens:       ollamaState.response.PromptEvalCount,
-			CompletionTokens:   ollamaState.response.EvalCount,
-			PromptEvalDuration: time.Duration(ollamaState.response.PromptEvalDuration),
-			EvalDuration:       evalDuration,
-			UpstreamHost:       ollamaState.upstreamHost,
+	return storage.SimpleMessage{
+		Role:      m.Role,
+		Content:   m.Content,
+		Metadata:  metadata,
+		ToolCalls: toolCalls,
+	}
+}
+
+func (oi *ChatInterceptor) saveLog(ollamaState *chatState) {
+	ctx, cancel := context.WithTimeout(interceptor2.WithExchange(context.Background(), ollamaState.exchange), oi.Timeout)
+	defer cancel()
+
+	tools := make([]storage.Tool, len(ollamaState.request.Tools))
+	for i, t := range ollamaState.request.Tools {
+		tools[i] = storage.Tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			Parameters:  t.Function.Parameters,
 		}
+	}
+
+	history := make([]storage.SimpleMessage, len(ollamaState.request.Messages))
+	for i, m := range ollamaState.request.Messages {
+		history[i] = convertMessage(m)
+		history[i].Model = ollamaState.request.Model
+		history[i].ClientHost = ollamaState.clientHost
+		history[i].Tools = tools
+	}
+	var evalDuration time.Duration
+	if ollamaState.response.EvalDuration > 0 {
+		evalDuration = time.Duration(ollamaState.response.EvalDuration)
+	} else if !ollamaState.endTime.IsZero() {
+		evalDuration = ollamaState.endTime.Sub(ollamaState.startTime)
+	}
 
-		oi.SaveToStorage(ctx, history, assistantMsg, ollamaState.statusCode, "chat")
+	assistantMsg := convertMessage(ollamaState.response.Message)
+	metadata := ollamaState.exchange.Metadata()
+	maps.Copy(metadata, assistantMsg.Metadata)
+	if parameters := ollamaState.request.parameters(); len(parameters) > 0 {
+		metadata["parameters"] = parameters
+	}
+	if ollamaState.response.DoneReason != "" {
+		metadata["finish_reason"] = ollamaState.response.DoneReason
 	}
+
+	assistantMsg.Model = ollamaState.response.Model
+	assistantMsg.PromptTokens = ollamaState.response.PromptEvalCount
+	assistantMsg.CompletionTokens = ollamaState.response.EvalCount
+	assistantMsg.PromptEvalDuration = time.Duration(ollamaState.response.PromptEvalDuration)
+	assistantMsg.EvalDuration = evalDuration
+	assistantMsg.UpstreamHost = ollamaState.upstreamHost
+	assistantMsg.Metadata = metadata
+	assistantMsg.Tools = tools
+
+	oi.SaveToStorage(ctx, history, assistantMsg, ollamaState.statusCode, "chat")
 }
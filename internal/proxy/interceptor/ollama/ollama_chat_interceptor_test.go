@@ -0,0 +1,109 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatInterceptor_SaveLog_PreservesToolsImagesAndThinking(t *testing.T) {
+	store := &mockStorage{}
+	interceptor := &ChatInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: store,
+			Timeout: 1 * time.Second,
+		},
+	}
+	state := interceptor.CreateState()
+
+	requestBody := `{
+		"model": "qwen3:8b",
+		"messages": [
+			{"role": "user", "content": "What is on this picture and how is the weather there?", "images": ["aW1hZ2U="]},
+			{"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}}]},
+			{"role": "tool", "content": "15 degrees", "tool_name": "get_weather"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "description": "Get the weather", "parameters": {"type": "object"}}}],
+		"options": {"temperature": 0.2, "num_ctx": 8192},
+		"format": "json",
+		"think": true,
+		"keep_alive": "5m"
+	}`
+	req, _ := http.NewRequest("POST", "/api/chat", bytes.NewBufferString(requestBody))
+	assert.NoError(t, interceptor.RequestInterceptor(req, state))
+	state.(*chatState).statusCode = 200
+
+	chunks := []string{
+		`{"model":"qwen3:8b","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"","thinking":"The user wants "},"done":false}`,
+		`{"model":"qwen3:8b","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"","thinking":"the weather."},"done":false}`,
+		`{"model":"qwen3:8b","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"It shows the Eiffel Tower. ","tool_calls":[{"function":{"name":"get_forecast","arguments":{"city":"Paris","days":2}}}]},"done":false}`,
+		`{"model":"qwen3:8b","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"It is 15 degrees."},"done":false}`,
+		`{"model":"qwen3:8b","created_at":"2025-01-01T00:00:01Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":20}`,
+	}
+	for _, chunk := range chunks {
+		_, err := interceptor.ChunkInterceptor([]byte(chunk+"\n"), state)
+		assert.NoError(t, err)
+	}
+	interceptor.OnComplete(state)
+
+	// History messages
+	user := store.messages[0]
+	assert.Equal(t, "user", user.Role)
+	assert.Equal(t, []map[string]any{{"type": "image", "data": "aW1hZ2U="}}, user.Metadata["attachments"])
+	assert.Len(t, user.Tools, 1)
+	assert.Equal(t, "get_weather", user.Tools[0].Name)
+
+	assistant := store.messages[1]
+	assert.Len(t, assistant.ToolCalls, 1)
+	assert.Equal(t, "function", assistant.ToolCalls[0].Type)
+	assert.Equal(t, "get_weather", assistant.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city": "Paris"}`, assistant.ToolCalls[0].Function.Arguments)
+
+	tool := store.messages[2]
+	assert.Equal(t, "tool", tool.Role)
+	assert.Equal(t, "get_weather", tool.Metadata["tool_name"])
+
+	// Response message
+	response := store.messages[len(store.messages)-1]
+	assert.Equal(t, "assistant", response.Role)
+	assert.Equal(t, "It shows the Eiffel Tower. It is 15 degrees.", response.Content)
+	assert.Equal(t, "The user wants the weather.", response.Metadata["thinking"])
+	assert.Len(t, response.ToolCalls, 1)
+	assert.JSONEq(t, `{"city":"Paris","days":2}`, response.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, 30, response.PromptTokens)
+	assert.Equal(t, "stop", response.Metadata["finish_reason"])
+	assert.Equal(t, 20, response.CompletionTokens)
+	assert.Equal(t, map[string]any{
+		"temperature": 0.2,
+		"num_ctx":     float64(8192),
+		"format":      "json",
+		"think":       true,
+		"keep_alive":  "5m",
+	}, response.Metadata["parameters"])
+}
+
+type mockStorage struct {
+	storage.Storage
+	messages []storage.SimpleMessage
+}
+
+func (m *mockStorage) FindMessageByHistory(ctx context.Context, history []storage.SimpleMessage, requestType string) (uuid.UUID, error) {
+	return uuid.Nil, nil
+}
+
+func (m *mockStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*storage.Conversation, *storage.Branch, error) {
+	m.messages = nil
+	return &storage.Conversation{ID: uuid.New()}, &storage.Branch{ID: uuid.New()}, nil
+}
+
+func (m *mockStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *storage.Message) (*storage.Message, error) {
+	m.messages = append(m.messages, message.SimpleMessage)
+	return &storage.Message{ID: uuid.New(), SimpleMessage: message.SimpleMessage}, nil
+}
@@ -0,0 +1,211 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EmbedInterceptor records embedding requests between a Client and an Ollama server. The input texts are
+// stored as a user message, while the vectors are only stored if StoreVectors is set.
+type EmbedInterceptor struct {
+	interceptor2.SavingInterceptor
+	StoreVectors bool
+}
+
+// embedInput represents the input of an embed request, which is either a string or a list of strings
+type embedInput []string
+
+// UnmarshalJSON accepts both a single string and a list of strings
+func (in *embedInput) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*in = embedInput{text}
+		return nil
+	}
+	var texts []string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return err
+	}
+	*in = texts
+	return nil
+}
+
+// content returns the content of the message storing the input, which is the text of a single input or the JSON list
+// of several inputs
+func (in embedInput) content() string {
+	if len(in) == 1 {
+		return in[0]
+	}
+	var sb strings.Builder
+	encoder := json.NewEncoder(&sb)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode([]string(in))
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// embedRequest represents the structure of a request to the /api/embed endpoint
+type embedRequest struct {
+	Model      string                 `json:"model"`
+	Input      embedInput             `json:"input"`
+	Truncate   *bool                  `json:"truncate,omitempty"`
+	Dimensions int                    `json:"dimensions,omitempty"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+	KeepAlive  json.RawMessage        `json:"keep_alive,omitempty"`
+}
+
+// embedResponse represents the structure of a response from the /api/embed endpoint
+type embedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	LoadDuration    int64       `json:"load_duration,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+// embedState holds the state for an Ollama embed request
+type embedState struct {
+	request      embedRequest
+	response     embedResponse
+	startTime    time.Time
+	endTime      time.Time
+	statusCode   int
+	clientHost   string
+	upstreamHost string
+	exchange     *interceptor2.Exchange
+}
+
+// CreateState creates a new embedState for tracking requests
+func (oi *EmbedInterceptor) CreateState() interceptor2.State {
+	return &embedState{
+		startTime: time.Now(),
+	}
+}
+
+// RequestInterceptor intercepts the request to /api/embed
+func (oi *EmbedInterceptor) RequestInterceptor(req *http.Request, state interceptor2.State) error {
+	logrus.Printf("[%s] Intercepting request to %s", oi.Name, req.URL.Path)
+
+	// Read the request body
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(req.Body)
+
+	// Store the request body in state
+	ollamaState, _ := state.(*embedState)
+	ollamaState.clientHost = req.Header.Get("X-Forwarded-For")
+	ollamaState.exchange = interceptor2.ExchangeFromContext(req.Context())
+
+	// Parse the request to extract model and inputs
+	var embedReq embedRequest
+	if err := json.Unmarshal(body, &embedReq); err != nil {
+		logrus.WithError(err).Warningf("[%s] Could not parse request body: %v", oi.Name, err)
+	} else {
+		ollamaState.request = embedReq
+	}
+
+	// Replace the request body with the original content
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return nil
+}
+
+// ResponseInterceptor intercepts the response from /api/embed
+func (oi *EmbedInterceptor) ResponseInterceptor(resp *http.Response, state interceptor2.State) error {
+	ollamaState, _ := state.(*embedState)
+	ollamaState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		ollamaState.upstreamHost = resp.Request.URL.Host
+	}
+	return nil
+}
+
+// ContentInterceptor intercepts the content to extract the embeddings
+func (oi *EmbedInterceptor) ContentInterceptor(content []byte, state interceptor2.State) ([]byte, error) {
+	ollamaState, _ := state.(*embedState)
+
+	// Parse the response to extract details
+	var embedResp embedResponse
+	if err := json.Unmarshal(content, &embedResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Could not parse response body: %v", oi.Name, err)
+	} else {
+		ollamaState.response = embedResp
+	}
+
+	return content, nil
+}
+
+// ChunkInterceptor passes chunks through, embeddings are never streamed
+func (oi *EmbedInterceptor) ChunkInterceptor(chunk []byte, _ interceptor2.State) ([]byte, error) {
+	return chunk, nil
+}
+
+// OnComplete is called when the request is completed
+func (oi *EmbedInterceptor) OnComplete(state interceptor2.State) {
+	ollamaState, _ := state.(*embedState)
+	ollamaState.endTime = time.Now()
+
+	logrus.Printf("[%s] Request completed for model: %s, %d embeddings", oi.Name, ollamaState.request.Model, len(ollamaState.response.Embeddings))
+
+	oi.saveLog(ollamaState)
+}
+
+// OnError is called when an error occurs
+func (oi *EmbedInterceptor) OnError(state interceptor2.State, err error) {
+	ollamaState, _ := state.(*embedState)
+	ollamaState.endTime = time.Now()
+	logrus.WithError(err).Warningf("[%s] Error occurred: %v", oi.Name, err)
+
+	oi.saveLog(ollamaState)
+}
+
+func (oi *EmbedInterceptor) saveLog(ollamaState *embedState) {
+	ctx, cancel := context.WithTimeout(interceptor2.WithExchange(context.Background(), ollamaState.exchange), oi.Timeout)
+	defer cancel()
+
+	// All inputs of a request are stored as one message, so batches sharing their first inputs aren't merged
+	var history []storage.SimpleMessage
+	if input := ollamaState.request.Input; len(input) > 0 {
+		history = []storage.SimpleMessage{{Role: "user", Content: input.content(), Model: ollamaState.request.Model, ClientHost: ollamaState.clientHost}}
+	}
+
+	metadata := ollamaState.exchange.Metadata()
+	metadata["count"] = len(ollamaState.response.Embeddings)
+	if len(ollamaState.response.Embeddings) > 0 {
+		metadata["dimensions"] = len(ollamaState.response.Embeddings[0])
+	}
+	if oi.StoreVectors {
+		metadata["embeddings"] = ollamaState.response.Embeddings
+	}
+
+	var evalDuration time.Duration
+	if ollamaState.response.TotalDuration > 0 {
+		evalDuration = time.Duration(ollamaState.response.TotalDuration - ollamaState.response.LoadDuration)
+	} else if !ollamaState.endTime.IsZero() {
+		evalDuration = ollamaState.endTime.Sub(ollamaState.startTime)
+	}
+
+	assistantMsg := storage.SimpleMessage{
+		Role:         "assistant",
+		Model:        ollamaState.response.Model,
+		PromptTokens: ollamaState.response.PromptEvalCount,
+		EvalDuration: evalDuration,
+		UpstreamHost: ollamaState.upstreamHost,
+		Metadata:     metadata,
+	}
+
+	oi.SaveToStorage(ctx, history, assistantMsg, ollamaState.statusCode, "embedding")
+}
@@ -0,0 +1,69 @@
+package ollama
+
+import (
+	"bytes"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbedInterceptor_SaveLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"String", `"first"`, "first"},
+		{"List of strings", `["first", "<second>"]`, `["first","<second>"]`},
+	}
+	responseBody := `{
+		"model": "nomic-embed-text",
+		"embeddings": [[0.1, 0.2, 0.3], [0.4, 0.5, 0.6]],
+		"total_duration": 14143917,
+		"load_duration": 1019500,
+		"prompt_eval_count": 8
+	}`
+
+	for _, tt := range tests {
+		for _, storeVectors := range []bool{false, true} {
+			store := &mockStorage{}
+			interceptor := &EmbedInterceptor{
+				SavingInterceptor: interceptor2.SavingInterceptor{
+					Storage: store,
+					Timeout: 1 * time.Second,
+				},
+				StoreVectors: storeVectors,
+			}
+			state := interceptor.CreateState()
+
+			req, _ := http.NewRequest("POST", "/api/embed", bytes.NewBufferString(`{"model": "nomic-embed-text", "input": `+tt.input+`}`))
+			assert.NoError(t, interceptor.RequestInterceptor(req, state))
+			assert.NoError(t, interceptor.ResponseInterceptor(&http.Response{StatusCode: http.StatusOK}, state))
+			_, err := interceptor.ContentInterceptor([]byte(responseBody), state)
+			assert.NoError(t, err)
+			interceptor.OnComplete(state)
+
+			// The inputs are stored as a single message
+			assert.Len(t, store.messages, 2, tt.name)
+			assert.Equal(t, "user", store.messages[0].Role)
+			assert.Equal(t, tt.expected, store.messages[0].Content)
+			assert.Equal(t, "nomic-embed-text", store.messages[0].Model)
+
+			result := store.messages[1]
+			assert.Equal(t, "assistant", result.Role)
+			assert.Equal(t, "nomic-embed-text", result.Model)
+			assert.Equal(t, 8, result.PromptTokens)
+			assert.Equal(t, time.Duration(14143917-1019500), result.EvalDuration)
+			assert.Equal(t, 2, result.Metadata["count"])
+			assert.Equal(t, 3, result.Metadata["dimensions"])
+			if storeVectors {
+				assert.Equal(t, [][]float64{{0.1, 0.2, 0.3}, {0.4, 0.5, 0.6}}, result.Metadata["embeddings"])
+			} else {
+				assert.NotContains(t, result.Metadata, "embeddings")
+			}
+		}
+	}
+}
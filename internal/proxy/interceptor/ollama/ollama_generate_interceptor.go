@@ -7,6 +7,7 @@ import (
 	"io"
 	interceptor2 "llm-monitor/internal/proxy/interceptor"
 	"llm-monitor/internal/storage"
+	"maps"
 	"net/http"
 	"time"
 
@@ -51,6 +52,9 @@ type generateState struct {
 	statusCode   int
 	clientHost   string
 	upstreamHost string
+	exchange     *interceptor2.Exchange
+	parameters   map[string]any
+	decoder      interceptor2.NDJSONDecoder
 }
 
 // CreateState creates a new generateState for tracking requests
@@ -75,8 +79,14 @@ func (oi *GenerateInterceptor) RequestInterceptor(req *http.Request, state inter
 
 	// Store the request body in state
 	ollamaState, _ := state.(*generateState)
-	ollamaState.upstreamHost = req.Host
 	ollamaState.clientHost = req.Header.Get("X-Forwarded-For")
+	ollamaState.exchange = interceptor2.ExchangeFromContext(req.Context())
+	ollamaState.parameters = interceptor2.RequestParameters(body, "model", "prompt", "suffix", "system", "template", "context", "images", "stream", "raw")
+	if options, ok := ollamaState.parameters["options"].(map[string]any); ok {
+		// Options are flattened like for chat requests
+		delete(ollamaState.parameters, "options")
+		maps.Copy(ollamaState.parameters, options)
+	}
 
 	// Parse the request to extract model and prompt
 	var generateReq generateRequest
@@ -86,9 +96,6 @@ func (oi *GenerateInterceptor) RequestInterceptor(req *http.Request, state inter
 		ollamaState.request = generateReq
 	}
 
-	// Store available request information
-	oi.saveLog(ollamaState)
-
 	// Replace the request body with the original content
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
 
@@ -99,6 +106,10 @@ func (oi *GenerateInterceptor) RequestInterceptor(req *http.Request, state inter
 func (oi *GenerateInterceptor) ResponseInterceptor(resp *http.Response, state interceptor2.State) error {
 	ollamaState, _ := state.(*generateState)
 	ollamaState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		ollamaState.upstreamHost = resp.Request.URL.Host
+	}
 	return nil
 }
 
@@ -117,29 +128,40 @@ func (oi *GenerateInterceptor) ContentInterceptor(content []byte, state intercep
 	return content, nil
 }
 
-// ChunkInterceptor intercepts chunks (not used for this specific interceptor)
+// ChunkInterceptor intercepts chunks for streaming responses
 func (oi *GenerateInterceptor) ChunkInterceptor(chunk []byte, state interceptor2.State) ([]byte, error) {
 	ollamaState, _ := state.(*generateState)
 
-	// Parse the response to extract details
+	// Ollama streams newline delimited JSON objects
+	for _, line := range ollamaState.decoder.Decode(chunk) {
+		oi.applyChunk(ollamaState, line)
+	}
+
+	return chunk, nil
+}
+
+// applyChunk merges a single streamed response object into the response
+func (oi *GenerateInterceptor) applyChunk(ollamaState *generateState, line []byte) {
 	var generateResp generateResponse
-	if err := json.Unmarshal(chunk, &generateResp); err != nil {
+	if err := json.Unmarshal(line, &generateResp); err != nil {
 		logrus.WithError(err).Warningf("[%s] Could not parse response chunk: %v", oi.Name, err)
-	} else {
-		currentResponse := ollamaState.response.Response + generateResp.Response
-		if generateResp.Done {
-			ollamaState.response = generateResp
-		}
-		ollamaState.response.Response = currentResponse
+		return
 	}
 
-	return chunk, nil
+	currentResponse := ollamaState.response.Response + generateResp.Response
+	if generateResp.Done {
+		ollamaState.response = generateResp
+	}
+	ollamaState.response.Response = currentResponse
 }
 
 // OnComplete is called when the request is completed
 func (oi *GenerateInterceptor) OnComplete(state interceptor2.State) {
 	ollamaState, _ := state.(*generateState)
 	ollamaState.endTime = time.Now()
+	for _, line := range ollamaState.decoder.Flush() {
+		oi.applyChunk(ollamaState, line)
+	}
 
 	logrus.Printf("[%s] Request completed for model: %s", oi.Name, ollamaState.response.Model)
 	logrus.Printf("[%s] Prompt: %s", oi.Name, ollamaState.request.Prompt)
@@ -152,6 +174,9 @@ func (oi *GenerateInterceptor) OnComplete(state interceptor2.State) {
 func (oi *GenerateInterceptor) OnError(state interceptor2.State, err error) {
 	ollamaState, _ := state.(*generateState)
 	ollamaState.endTime = time.Now()
+	for _, line := range ollamaState.decoder.Flush() {
+		oi.applyChunk(ollamaState, line)
+	}
 	logrus.WithError(err).Warningf("[%s] Error occurred: %v", oi.Name, err)
 	logrus.Printf("[%s] Prompt: %s", oi.Name, ollamaState.request.Prompt)
 	logrus.Printf("[%s] Response: %s", oi.Name, ollamaState.response.Response)
@@ -160,31 +185,38 @@ func (oi *GenerateInterceptor) OnError(state interceptor2.State, err error) {
 }
 
 func (oi *GenerateInterceptor) saveLog(ollamaState *generateState) {
-	if oi.Storage != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), oi.Timeout)
-		defer cancel()
-
-		history := []storage.SimpleMessage{
-			{Role: "user", Content: ollamaState.request.Prompt, Model: ollamaState.request.Model, ClientHost: ollamaState.clientHost},
-		}
-		var evalDuration time.Duration
-		if ollamaState.response.EvalDuration > 0 {
-			evalDuration = time.Duration(ollamaState.response.EvalDuration)
-		} else if !ollamaState.endTime.IsZero() {
-			evalDuration = ollamaState.endTime.Sub(ollamaState.startTime)
-		}
-
-		assistantMsg := storage.SimpleMessage{
-			Role:               "assistant",
-			Content:            ollamaState.response.Response,
-			Model:              ollamaState.response.Model,
-			PromptTokens:       ollamaState.response.PromptEvalCount,
-			CompletionTokens:   ollamaState.response.EvalCount,
-			PromptEvalDuration: time.Duration(ollamaState.response.PromptEvalDuration),
-			EvalDuration:       evalDuration,
-			UpstreamHost:       ollamaState.upstreamHost,
-		}
-
-		oi.SaveToStorage(ctx, history, assistantMsg, ollamaState.statusCode, "generate")
+	ctx, cancel := context.WithTimeout(interceptor2.WithExchange(context.Background(), ollamaState.exchange), oi.Timeout)
+	defer cancel()
+
+	history := []storage.SimpleMessage{
+		{Role: "user", Content: ollamaState.request.Prompt, Model: ollamaState.request.Model, ClientHost: ollamaState.clientHost},
+	}
+	var evalDuration time.Duration
+	if ollamaState.response.EvalDuration > 0 {
+		evalDuration = time.Duration(ollamaState.response.EvalDuration)
+	} else if !ollamaState.endTime.IsZero() {
+		evalDuration = ollamaState.endTime.Sub(ollamaState.startTime)
 	}
+
+	metadata := ollamaState.exchange.Metadata()
+	if ollamaState.parameters != nil {
+		metadata["parameters"] = ollamaState.parameters
+	}
+	if ollamaState.response.DoneReason != "" {
+		metadata["finish_reason"] = ollamaState.response.DoneReason
+	}
+
+	assistantMsg := storage.SimpleMessage{
+		Role:               "assistant",
+		Content:            ollamaState.response.Response,
+		Model:              ollamaState.response.Model,
+		PromptTokens:       ollamaState.response.PromptEvalCount,
+		CompletionTokens:   ollamaState.response.EvalCount,
+		PromptEvalDuration: time.Duration(ollamaState.response.PromptEvalDuration),
+		EvalDuration:       evalDuration,
+		UpstreamHost:       ollamaState.upstreamHost,
+		Metadata:           metadata,
+	}
+
+	oi.SaveToStorage(ctx, history, assistantMsg, ollamaState.statusCode, "generate")
 }
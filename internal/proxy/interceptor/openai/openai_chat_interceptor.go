@@ -9,7 +9,6 @@ import (
 	"llm-monitor/internal/proxy/interceptor"
 	"llm-monitor/internal/storage"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -111,6 +110,9 @@ type chatState struct {
 	statusCode   int
 	clientHost   string
 	upstreamHost string
+	exchange     *interceptor.Exchange
+	parameters   map[string]any
+	decoder      interceptor.SSEDecoder
 }
 
 // CreateState creates a new state for the interceptor
@@ -135,8 +137,9 @@ func (oi *ChatInterceptor) RequestInterceptor(req *http.Request, state intercept
 
 	// Extract host information
 	openAIState, _ := state.(*chatState)
-	openAIState.upstreamHost = req.Host
 	openAIState.clientHost = req.Header.Get("X-Forwarded-For")
+	openAIState.exchange = interceptor.ExchangeFromContext(req.Context())
+	openAIState.parameters = interceptor.RequestParameters(body, "model", "messages", "stream", "stream_options", "tools", "user")
 
 	// Parse the chat request into a generic map to avoid losing fields during modification
 	var chatReqMap map[string]any
@@ -173,9 +176,6 @@ func (oi *ChatInterceptor) RequestInterceptor(req *http.Request, state intercept
 		openAIState.request = chatReq
 	}
 
-	// Store available request information
-	oi.saveLog(openAIState)
-
 	// Create a new body reader
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
 
@@ -186,6 +186,10 @@ func (oi *ChatInterceptor) RequestInterceptor(req *http.Request, state intercept
 func (oi *ChatInterceptor) ResponseInterceptor(resp *http.Response, state interceptor.State) error {
 	openAIState, _ := state.(*chatState)
 	openAIState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		openAIState.upstreamHost = resp.Request.URL.Host
+	}
 	return nil
 }
 
@@ -209,74 +213,77 @@ func (oi *ChatInterceptor) ChunkInterceptor(chunk []byte, state interceptor.Stat
 	openAIState, _ := state.(*chatState)
 
 	// OpenAI Server-Sent Events (SSE) format: data: {...}
-	lines := strings.Split(string(chunk), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || line == "data: [DONE]" {
-			continue
-		}
-		if strings.HasPrefix(line, "data: ") {
-			data := line[6:]
-			var chatResp chatResponse
-			if err := json.Unmarshal([]byte(data), &chatResp); err != nil {
-				logrus.WithError(err).Warningf("[%s] Warning: Could not parse response chunk", oi.Name)
-				continue
-			}
+	for _, event := range openAIState.decoder.Decode(chunk) {
+		oi.applyEvent(openAIState, event)
+	}
 
-			if openAIState.response.ID == "" {
-				openAIState.response.ID = chatResp.ID
-				openAIState.response.Model = chatResp.Model
-				openAIState.response.Created = chatResp.Created
-				openAIState.response.Object = chatResp.Object
-			}
+	return chunk, nil
+}
 
-			for _, choice := range chatResp.Choices {
-				if len(openAIState.response.Choices) <= choice.Index {
-					// Expand choices if necessary
-					newChoices := make([]chatResponseChoice, choice.Index+1)
-					copy(newChoices, openAIState.response.Choices)
-					openAIState.response.Choices = newChoices
-				}
+// applyEvent merges a single streamed chat completion chunk into the response
+func (oi *ChatInterceptor) applyEvent(openAIState *chatState, event interceptor.SSEEvent) {
+	if event.Data == "[DONE]" {
+		return
+	}
+	var chatResp chatResponse
+	if err := json.Unmarshal([]byte(event.Data), &chatResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response chunk", oi.Name)
+		return
+	}
 
-				// OpenAI Delta contains incremental updates
-				openAIState.response.Choices[choice.Index].Message.Content += choice.Delta.Content
-				if choice.Delta.Role != "" {
-					openAIState.response.Choices[choice.Index].Message.Role = choice.Delta.Role
-				}
-				if len(choice.Delta.ToolCalls) > 0 {
-					if openAIState.response.Choices[choice.Index].Message.ToolCalls == nil {
-						openAIState.response.Choices[choice.Index].Message.ToolCalls = make([]chatToolCall, len(choice.Delta.ToolCalls))
+	if openAIState.response.ID == "" {
+		openAIState.response.ID = chatResp.ID
+		openAIState.response.Model = chatResp.Model
+		openAIState.response.Created = chatResp.Created
+		openAIState.response.Object = chatResp.Object
+	}
+
+	for _, choice := range chatResp.Choices {
+		if len(openAIState.response.Choices) <= choice.Index {
+			// Expand choices if necessary
+			newChoices := make([]chatResponseChoice, choice.Index+1)
+			copy(newChoices, openAIState.response.Choices)
+			for i := len(openAIState.response.Choices); i < len(newChoices); i++ {
+				newChoices[i].Index = i
+			}
+			openAIState.response.Choices = newChoices
+		}
+
+		// OpenAI Delta contains incremental updates
+		openAIState.response.Choices[choice.Index].Message.Content += choice.Delta.Content
+		if choice.Delta.Role != "" {
+			openAIState.response.Choices[choice.Index].Message.Role = choice.Delta.Role
+		}
+		if len(choice.Delta.ToolCalls) > 0 {
+			if openAIState.response.Choices[choice.Index].Message.ToolCalls == nil {
+				openAIState.response.Choices[choice.Index].Message.ToolCalls = make([]chatToolCall, len(choice.Delta.ToolCalls))
+			}
+			for i, tc := range choice.Delta.ToolCalls {
+				if i >= len(openAIState.response.Choices[choice.Index].Message.ToolCalls) {
+					openAIState.response.Choices[choice.Index].Message.ToolCalls = append(openAIState.response.Choices[choice.Index].Message.ToolCalls, tc)
+				} else {
+					if tc.ID != "" {
+						openAIState.response.Choices[choice.Index].Message.ToolCalls[i].ID = tc.ID
 					}
-					for i, tc := range choice.Delta.ToolCalls {
-						if i >= len(openAIState.response.Choices[choice.Index].Message.ToolCalls) {
-							openAIState.response.Choices[choice.Index].Message.ToolCalls = append(openAIState.response.Choices[choice.Index].Message.ToolCalls, tc)
-						} else {
-							if tc.ID != "" {
-								openAIState.response.Choices[choice.Index].Message.ToolCalls[i].ID = tc.ID
-							}
-							if tc.Type != "" {
-								openAIState.response.Choices[choice.Index].Message.ToolCalls[i].Type = tc.Type
-							}
-							if tc.Function.Name != "" {
-								openAIState.response.Choices[choice.Index].Message.ToolCalls[i].Function.Name = tc.Function.Name
-							}
-							openAIState.response.Choices[choice.Index].Message.ToolCalls[i].Function.Arguments += tc.Function.Arguments
-						}
+					if tc.Type != "" {
+						openAIState.response.Choices[choice.Index].Message.ToolCalls[i].Type = tc.Type
 					}
-				}
-				if choice.FinishReason != "" {
-					openAIState.response.Choices[choice.Index].FinishReason = choice.FinishReason
+					if tc.Function.Name != "" {
+						openAIState.response.Choices[choice.Index].Message.ToolCalls[i].Function.Name = tc.Function.Name
+					}
+					openAIState.response.Choices[choice.Index].Message.ToolCalls[i].Function.Arguments += tc.Function.Arguments
 				}
 			}
-
-			// Some OpenAI compatible servers might send usage in the last chunk
-			if chatResp.Usage.TotalTokens > 0 {
-				openAIState.response.Usage = chatResp.Usage
-			}
+		}
+		if choice.FinishReason != "" {
+			openAIState.response.Choices[choice.Index].FinishReason = choice.FinishReason
 		}
 	}
 
-	return chunk, nil
+	// Some OpenAI compatible servers might send usage in the last chunk
+	if chatResp.Usage.TotalTokens > 0 {
+		openAIState.response.Usage = chatResp.Usage
+	}
 }
 
 // OnComplete handles completion of the request
@@ -284,6 +291,9 @@ func (oi *ChatInterceptor) OnComplete(state interceptor.State) {
 	openAIState, _ := state.(*chatState)
 
 	openAIState.endTime = time.Now()
+	for _, event := range openAIState.decoder.Flush() {
+		oi.applyEvent(openAIState, event)
+	}
 
 	logrus.Printf("[%s] Request completed for model: %s", oi.Name, openAIState.request.Model)
 	oi.logRequestResponse(openAIState)
@@ -295,6 +305,9 @@ func (oi *ChatInterceptor) OnComplete(state interceptor.State) {
 func (oi *ChatInterceptor) OnError(state interceptor.State, err error) {
 	openAIState, _ := state.(*chatState)
 	openAIState.endTime = time.Now()
+	for _, event := range openAIState.decoder.Flush() {
+		oi.applyEvent(openAIState, event)
+	}
 	logrus.WithError(err).Warningf("[%s] Error occurred", oi.Name)
 	oi.logRequestResponse(openAIState)
 
@@ -311,86 +324,100 @@ func (oi *ChatInterceptor) logRequestResponse(openAIState *chatState) {
 }
 
 func (oi *ChatInterceptor) saveLog(openAIState *chatState) {
-	if oi.Storage != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), oi.Timeout)
-		defer cancel()
-
-		tools := make([]storage.Tool, len(openAIState.request.Tools))
-		for i, t := range openAIState.request.Tools {
-			tools[i] = storage.Tool{
-				Name:        t.Function.Name,
-				Description: t.Function.Description,
-				Parameters:  t.Function.Parameters,
-			}
+	ctx, cancel := context.WithTimeout(interceptor.WithExchange(context.Background(), openAIState.exchange), oi.Timeout)
+	defer cancel()
+
+	tools := make([]storage.Tool, len(openAIState.request.Tools))
+	for i, t := range openAIState.request.Tools {
+		tools[i] = storage.Tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			Parameters:  t.Function.Parameters,
 		}
+	}
 
-		history := make([]storage.SimpleMessage, len(openAIState.request.Messages))
-		for i, m := range openAIState.request.Messages {
-			metadata := make(map[string]any)
-			toolCalls := make([]storage.ToolCall, len(m.ToolCalls))
-			for j, tc := range m.ToolCalls {
-				toolCalls[j] = storage.ToolCall{
-					ID:   tc.ID,
-					Type: tc.Type,
-					Function: struct {
-						Name      string `json:"name"`
-						Arguments string `json:"arguments"`
-					}{
-						Name:      tc.Function.Name,
-						Arguments: tc.Function.Arguments,
-					},
-				}
-			}
-
-			history[i] = storage.SimpleMessage{
-				Role:       m.Role,
-				Content:    m.Content,
-				Model:      openAIState.request.Model,
-				ClientHost: openAIState.clientHost,
-				Metadata:   metadata,
-				Tools:      tools,
-				ToolCalls:  toolCalls,
-				ToolCallID: m.ToolCallID,
+	history := make([]storage.SimpleMessage, len(openAIState.request.Messages))
+	for i, m := range openAIState.request.Messages {
+		metadata := make(map[string]any)
+		toolCalls := make([]storage.ToolCall, len(m.ToolCalls))
+		for j, tc := range m.ToolCalls {
+			toolCalls[j] = storage.ToolCall{
+				ID:   tc.ID,
+				Type: tc.Type,
+				Function: struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				}{
+					Name:      tc.Function.Name,
+					Arguments: tc.Function.Arguments,
+				},
 			}
 		}
 
-		// Use the first choice as the assistant response (standard behavior)
-		var assistantMsg storage.SimpleMessage
-		if len(openAIState.response.Choices) > 0 {
-			choice := openAIState.response.Choices[0]
-			metadata := make(map[string]any)
-			toolCalls := make([]storage.ToolCall, len(choice.Message.ToolCalls))
-			for j, tc := range choice.Message.ToolCalls {
-				toolCalls[j] = storage.ToolCall{
-					ID:   tc.ID,
-					Type: tc.Type,
-					Function: struct {
-						Name      string `json:"name"`
-						Arguments string `json:"arguments"`
-					}{
-						Name:      tc.Function.Name,
-						Arguments: tc.Function.Arguments,
-					},
-				}
-			}
+		history[i] = storage.SimpleMessage{
+			Role:       m.Role,
+			Content:    m.Content,
+			Model:      openAIState.request.Model,
+			ClientHost: openAIState.clientHost,
+			Metadata:   metadata,
+			Tools:      tools,
+			ToolCalls:  toolCalls,
+			ToolCallID: m.ToolCallID,
+		}
+	}
 
-			assistantMsg = storage.SimpleMessage{
-				Role:             choice.Message.Role,
-				Content:          choice.Message.Content,
-				Model:            openAIState.response.Model,
-				PromptTokens:     openAIState.response.Usage.PromptTokens,
-				CompletionTokens: openAIState.response.Usage.CompletionTokens,
-				EvalDuration:     openAIState.endTime.Sub(openAIState.startTime),
-				UpstreamHost:     openAIState.upstreamHost,
-				Metadata:         metadata,
-				Tools:            tools,
-				ToolCalls:        toolCalls,
-			}
-			if assistantMsg.Role == "" {
-				assistantMsg.Role = "assistant"
+	// Every choice is stored as an alternative assistant response, the first one continues the branch of
+	// the history while the others are stored on sibling branches. Responses without choices, e.g. errors,
+	// are stored as a single empty choice.
+	responseChoices := openAIState.response.Choices
+	if len(responseChoices) == 0 {
+		responseChoices = []chatResponseChoice{{}}
+	}
+	choices := make([]storage.SimpleMessage, len(responseChoices))
+	for i, choice := range responseChoices {
+		metadata := openAIState.exchange.Metadata()
+		metadata["choice_index"] = choice.Index
+		if choice.FinishReason != "" {
+			metadata["finish_reason"] = choice.FinishReason
+		}
+		if openAIState.parameters != nil {
+			metadata["parameters"] = openAIState.parameters
+		}
+		toolCalls := make([]storage.ToolCall, len(choice.Message.ToolCalls))
+		for j, tc := range choice.Message.ToolCalls {
+			toolCalls[j] = storage.ToolCall{
+				ID:   tc.ID,
+				Type: tc.Type,
+				Function: struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				}{
+					Name:      tc.Function.Name,
+					Arguments: tc.Function.Arguments,
+				},
 			}
 		}
+		if len(toolCalls) > 0 {
+			metadata["tool_calls"] = toolCalls
+		}
 
-		oi.SaveToStorage(ctx, history, assistantMsg, openAIState.statusCode, "chat")
+		choices[i] = storage.SimpleMessage{
+			Role:         choice.Message.Role,
+			Content:      choice.Message.Content,
+			Model:        openAIState.response.Model,
+			EvalDuration: openAIState.endTime.Sub(openAIState.startTime),
+			UpstreamHost: openAIState.upstreamHost,
+			Metadata:     metadata,
+			Tools:        tools,
+			ToolCalls:    toolCalls,
+		}
+		if choices[i].Role == "" {
+			choices[i].Role = "assistant"
+		}
 	}
+	// Usage covers all choices, so it is only accounted once
+	choices[0].PromptTokens = openAIState.response.Usage.PromptTokens
+	choices[0].CompletionTokens = openAIState.response.Usage.CompletionTokens
+
+	oi.SaveChoicesToStorage(ctx, history, choices, openAIState.statusCode, "chat")
 }
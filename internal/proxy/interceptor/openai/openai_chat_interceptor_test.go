@@ -160,7 +160,7 @@ func TestChatInterceptor_ChunkInterceptor_AggregatesToolCalls(t *testing.T) {
 	}
 
 	for _, chunk := range chunks {
-		_, err := interceptor.ChunkInterceptor([]byte(chunk), state)
+		_, err := interceptor.ChunkInterceptor([]byte(chunk+"\n\n"), state)
 		assert.NoError(t, err)
 	}
 
@@ -235,3 +235,88 @@ func (m *mockStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID,
 	}
 	return &storage.Message{ID: uuid.New(), SimpleMessage: message.SimpleMessage}, nil
 }
+
+func TestChatInterceptor_SaveLog_StoresAllChoices(t *testing.T) {
+	store := &recordingStorage{}
+	interceptor := &ChatInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: store,
+			Timeout: 1 * time.Second,
+		},
+	}
+	state := interceptor.CreateState()
+	state.(*chatState).statusCode = 200
+
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4o","n":2,"temperature":0.9,"seed":7,"stream":true,"messages":[{"role":"user","content":"Tell me a joke"}]}`))
+	assert.NoError(t, interceptor.RequestInterceptor(req, state))
+
+	chunks := []string{
+		`data: {"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Knock"}},{"index":1,"delta":{"role":"assistant","content":"Why"}}]}`,
+		`data: {"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":1,"delta":{"content":" not?"},"finish_reason":"length"}]}`,
+		`data: {"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":" knock"},"finish_reason":"stop"}]}`,
+		`data: {"id":"chatcmpl-1","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":6,"total_tokens":16}}`,
+		`data: [DONE]`,
+	}
+	for _, chunk := range chunks {
+		_, err := interceptor.ChunkInterceptor([]byte(chunk+"\n\n"), state)
+		assert.NoError(t, err)
+	}
+	interceptor.OnComplete(state)
+
+	// The user message followed by both choices, which share the user message as parent
+	n := len(store.messages)
+	assert.GreaterOrEqual(t, n, 3)
+	user, first, second := store.messages[n-3], store.messages[n-2], store.messages[n-1]
+	assert.Equal(t, "user", user.Role)
+	assert.Equal(t, store.parents[n-2], store.parents[n-1])
+	assert.NotEqual(t, uuid.Nil, store.parents[n-1])
+
+	assert.Equal(t, "Knock knock", first.Content)
+	assert.Equal(t, 0, first.Metadata["choice_index"])
+	assert.Equal(t, "stop", first.Metadata["finish_reason"])
+	assert.Equal(t, 10, first.PromptTokens)
+	assert.Equal(t, 6, first.CompletionTokens)
+
+	assert.Equal(t, "Why not?", second.Content)
+	assert.Equal(t, 1, second.Metadata["choice_index"])
+	assert.Equal(t, "length", second.Metadata["finish_reason"])
+	assert.Zero(t, second.PromptTokens)
+
+	// Sampling parameters are kept on every choice, without the injected stream options
+	expected := map[string]any{"n": float64(2), "temperature": 0.9, "seed": float64(7)}
+	assert.Equal(t, expected, first.Metadata["parameters"])
+	assert.Equal(t, expected, second.Metadata["parameters"])
+}
+
+func TestChatInterceptor_RedactsOnlySavedMessages(t *testing.T) {
+	redactor, err := interceptor2.NewRedactor([]interceptor2.RedactionRule{{Type: "email"}, {Type: "api_key", Action: interceptor2.RedactDrop}})
+	assert.NoError(t, err)
+	store := &recordingStorage{}
+	interceptor := &ChatInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage:  store,
+			Timeout:  1 * time.Second,
+			Redactor: redactor,
+		},
+	}
+	state := interceptor.CreateState()
+
+	requestBody := `{"model":"gpt-4o","messages":[{"role":"user","content":"My key sk-abcdefghijklmnopqrstuvwxyz is for bob@example.com"}]}`
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(requestBody))
+	assert.NoError(t, interceptor.RequestInterceptor(req, state))
+	forwarded, _ := io.ReadAll(req.Body)
+	assert.Contains(t, string(forwarded), "sk-abcdefghijklmnopqrstuvwxyz")
+	assert.Contains(t, string(forwarded), "bob@example.com")
+
+	responseBody := []byte(`{"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Noted, bob@example.com"},"finish_reason":"stop"}]}`)
+	returned, err := interceptor.ContentInterceptor(responseBody, state)
+	assert.NoError(t, err)
+	assert.Equal(t, responseBody, returned)
+	state.(*chatState).statusCode = 200
+	interceptor.OnComplete(state)
+
+	n := len(store.messages)
+	assert.GreaterOrEqual(t, n, 2)
+	assert.Equal(t, "My key  is for [REDACTED:email]", store.messages[n-2].Content)
+	assert.Equal(t, "Noted, [REDACTED:email]", store.messages[n-1].Content)
+}
@@ -0,0 +1,295 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CompletionInterceptor intercepts legacy text completions between client and OpenAI compatible server
+type CompletionInterceptor struct {
+	interceptor.SavingInterceptor
+}
+
+// completionPrompt represents the prompt of a completion request, which is either a string or a list of strings
+type completionPrompt []string
+
+// UnmarshalJSON accepts a string, a list of strings or token arrays. Token arrays are kept as JSON text.
+func (p *completionPrompt) UnmarshalJSON(data []byte) error {
+	*p = embeddingInputs(data)
+	return nil
+}
+
+// completionRequest represents the structure of an OpenAI completion request
+type completionRequest struct {
+	Model       string           `json:"model"`
+	Prompt      completionPrompt `json:"prompt"`
+	Suffix      string           `json:"suffix,omitzero"`
+	Stream      bool             `json:"stream"`
+	MaxTokens   *int             `json:"max_tokens,omitzero"`
+	Temperature *float64         `json:"temperature,omitzero"`
+	TopP        *float64         `json:"top_p,omitzero"`
+	N           *int             `json:"n,omitzero"`
+	Stop        json.RawMessage  `json:"stop,omitzero"`
+}
+
+// completionChoice represents a choice in an OpenAI completion response
+type completionChoice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason"`
+}
+
+// completionResponse represents the structure of an OpenAI completion response
+type completionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	Usage   chatUsage          `json:"usage,omitzero"`
+}
+
+// completionState holds the state information for OpenAI completion requests
+type completionState struct {
+	request      completionRequest
+	response     completionResponse
+	startTime    time.Time
+	endTime      time.Time
+	statusCode   int
+	clientHost   string
+	upstreamHost string
+	exchange     *interceptor.Exchange
+	parameters   map[string]any
+	decoder      interceptor.SSEDecoder
+}
+
+// CreateState creates a new state for the interceptor
+func (ci *CompletionInterceptor) CreateState() interceptor.State {
+	return &completionState{
+		startTime: time.Now(),
+	}
+}
+
+// RequestInterceptor intercepts the request to extract model and prompt
+func (ci *CompletionInterceptor) RequestInterceptor(req *http.Request, state interceptor.State) error {
+	logrus.Printf("[%s] Intercepting request to %s", ci.Name, req.URL.Path)
+
+	// Read the request body
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(req.Body)
+
+	// Extract host information
+	openAIState, _ := state.(*completionState)
+	openAIState.clientHost = req.Header.Get("X-Forwarded-For")
+	openAIState.exchange = interceptor.ExchangeFromContext(req.Context())
+	openAIState.parameters = interceptor.RequestParameters(body, "model", "prompt", "suffix", "stream", "stream_options", "user")
+
+	// Parse the request into a generic map to avoid losing fields during modification
+	var completionReqMap map[string]any
+	if err := json.Unmarshal(body, &completionReqMap); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse request body into map", ci.Name)
+	} else if stream, _ := completionReqMap["stream"].(bool); stream {
+		// Always set include_usage to true if streaming is requested
+		streamOptions, ok := completionReqMap["stream_options"].(map[string]any)
+		if !ok {
+			streamOptions = make(map[string]any)
+			completionReqMap["stream_options"] = streamOptions
+		}
+		streamOptions["include_usage"] = true
+
+		// Marshal the modified request back to JSON
+		newBody, err := json.Marshal(completionReqMap)
+		if err != nil {
+			logrus.WithError(err).Errorf("[%s] Error: Could not marshal modified request body", ci.Name)
+		} else {
+			body = newBody
+			req.ContentLength = int64(len(body))
+			req.Header.Set("Content-Length", fmt.Sprint(len(body)))
+		}
+	}
+
+	// Also parse into the structured completionRequest for internal state and logging
+	var completionReq completionRequest
+	if err := json.Unmarshal(body, &completionReq); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse request body into struct", ci.Name)
+	} else {
+		openAIState.request = completionReq
+	}
+
+	// Create a new body reader
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return nil
+}
+
+// ResponseInterceptor intercepts the response to extract the status code
+func (ci *CompletionInterceptor) ResponseInterceptor(resp *http.Response, state interceptor.State) error {
+	openAIState, _ := state.(*completionState)
+	openAIState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		openAIState.upstreamHost = resp.Request.URL.Host
+	}
+	return nil
+}
+
+// ContentInterceptor intercepts content to extract the completion (non-streaming)
+func (ci *CompletionInterceptor) ContentInterceptor(content []byte, state interceptor.State) ([]byte, error) {
+	openAIState, _ := state.(*completionState)
+
+	// Parse the response
+	var completionResp completionResponse
+	if err := json.Unmarshal(content, &completionResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response body", ci.Name)
+	} else {
+		openAIState.response = completionResp
+	}
+
+	return content, nil
+}
+
+// ChunkInterceptor intercepts chunks for streaming responses
+func (ci *CompletionInterceptor) ChunkInterceptor(chunk []byte, state interceptor.State) ([]byte, error) {
+	openAIState, _ := state.(*completionState)
+
+	// OpenAI Server-Sent Events (SSE) format: data: {...}
+	for _, event := range openAIState.decoder.Decode(chunk) {
+		ci.applyEvent(openAIState, event)
+	}
+
+	return chunk, nil
+}
+
+// applyEvent merges a single streamed completion chunk into the response
+func (ci *CompletionInterceptor) applyEvent(openAIState *completionState, event interceptor.SSEEvent) {
+	if event.Data == "[DONE]" {
+		return
+	}
+	var completionResp completionResponse
+	if err := json.Unmarshal([]byte(event.Data), &completionResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response chunk", ci.Name)
+		return
+	}
+
+	if openAIState.response.ID == "" {
+		openAIState.response.ID = completionResp.ID
+		openAIState.response.Model = completionResp.Model
+		openAIState.response.Created = completionResp.Created
+		openAIState.response.Object = completionResp.Object
+	}
+
+	for _, choice := range completionResp.Choices {
+		for len(openAIState.response.Choices) <= choice.Index {
+			openAIState.response.Choices = append(openAIState.response.Choices, completionChoice{Index: len(openAIState.response.Choices)})
+		}
+		openAIState.response.Choices[choice.Index].Text += choice.Text
+		if choice.FinishReason != "" {
+			openAIState.response.Choices[choice.Index].FinishReason = choice.FinishReason
+		}
+	}
+
+	// Usage is sent in the last chunk if requested via stream_options
+	if completionResp.Usage.TotalTokens > 0 {
+		openAIState.response.Usage = completionResp.Usage
+	}
+}
+
+// OnComplete handles completion of the request
+func (ci *CompletionInterceptor) OnComplete(state interceptor.State) {
+	openAIState, _ := state.(*completionState)
+	openAIState.endTime = time.Now()
+	for _, event := range openAIState.decoder.Flush() {
+		ci.applyEvent(openAIState, event)
+	}
+
+	logrus.Printf("[%s] Request completed for model: %s", ci.Name, openAIState.request.Model)
+	ci.logRequestResponse(openAIState)
+
+	ci.saveLog(openAIState)
+}
+
+// OnError handles errors during request processing
+func (ci *CompletionInterceptor) OnError(state interceptor.State, err error) {
+	openAIState, _ := state.(*completionState)
+	openAIState.endTime = time.Now()
+	for _, event := range openAIState.decoder.Flush() {
+		ci.applyEvent(openAIState, event)
+	}
+	logrus.WithError(err).Warningf("[%s] Error occurred", ci.Name)
+	ci.logRequestResponse(openAIState)
+
+	ci.saveLog(openAIState)
+}
+
+func (ci *CompletionInterceptor) logRequestResponse(openAIState *completionState) {
+	for _, prompt := range openAIState.request.Prompt {
+		logrus.Printf("[%s] Prompt: %s", ci.Name, prompt)
+	}
+	for _, choice := range openAIState.response.Choices {
+		logrus.Printf("[%s] Response: %s", ci.Name, choice.Text)
+	}
+}
+
+func (ci *CompletionInterceptor) saveLog(openAIState *completionState) {
+	ctx, cancel := context.WithTimeout(interceptor.WithExchange(context.Background(), openAIState.exchange), ci.Timeout)
+	defer cancel()
+
+	history := []storage.SimpleMessage{
+		{
+			Role:       "user",
+			Content:    strings.Join(openAIState.request.Prompt, "\n\n"),
+			Model:      openAIState.request.Model,
+			ClientHost: openAIState.clientHost,
+		},
+	}
+
+	// Every choice is stored as an alternative assistant response on its own branch. Responses without choices,
+	// e.g. errors, are stored as a single empty choice.
+	responseChoices := openAIState.response.Choices
+	if len(responseChoices) == 0 {
+		responseChoices = []completionChoice{{}}
+	}
+	choices := make([]storage.SimpleMessage, len(responseChoices))
+	for i, choice := range responseChoices {
+		metadata := openAIState.exchange.Metadata()
+		metadata["choice_index"] = choice.Index
+		if choice.FinishReason != "" {
+			metadata["finish_reason"] = choice.FinishReason
+		}
+		if openAIState.parameters != nil {
+			metadata["parameters"] = openAIState.parameters
+		}
+		choices[i] = storage.SimpleMessage{
+			Role:         "assistant",
+			Content:      choice.Text,
+			Model:        openAIState.response.Model,
+			UpstreamHost: openAIState.upstreamHost,
+			Metadata:     metadata,
+		}
+	}
+	// Usage covers all choices, so it is only accounted once
+	choices[0].PromptTokens = openAIState.response.Usage.PromptTokens
+	choices[0].CompletionTokens = openAIState.response.Usage.CompletionTokens
+	if !openAIState.endTime.IsZero() {
+		for i := range choices {
+			choices[i].EvalDuration = openAIState.endTime.Sub(openAIState.startTime)
+		}
+	}
+
+	ci.SaveChoicesToStorage(ctx, history, choices, openAIState.statusCode, "completion")
+}
@@ -0,0 +1,84 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionInterceptor_RequestInterceptor_EnablesUsage(t *testing.T) {
+	interceptor := &CompletionInterceptor{}
+	state := interceptor.CreateState()
+
+	req, _ := http.NewRequest("POST", "/v1/completions", bytes.NewBufferString(`{"model":"gpt-3.5-turbo-instruct","prompt":["Say this","is a test"],"stream":true}`))
+	err := interceptor.RequestInterceptor(req, state)
+	assert.NoError(t, err)
+
+	modifiedBody, _ := io.ReadAll(req.Body)
+	var result map[string]any
+	assert.NoError(t, json.Unmarshal(modifiedBody, &result))
+	assert.Equal(t, true, result["stream_options"].(map[string]any)["include_usage"])
+
+	openAIState := state.(*completionState)
+	assert.Equal(t, completionPrompt{"Say this", "is a test"}, openAIState.request.Prompt)
+}
+
+func TestCompletionInterceptor_ChunkInterceptor_AggregatesText(t *testing.T) {
+	mockStorage := &mockStorage{}
+	interceptor := &CompletionInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: mockStorage,
+			Timeout: 1 * time.Second,
+		},
+	}
+	state := interceptor.CreateState()
+	state.(*completionState).statusCode = 200
+
+	chunks := []string{
+		`data: {"id":"cmpl-1","object":"text_completion","model":"gpt-3.5-turbo-instruct","choices":[{"index":0,"text":"This is","finish_reason":null}]}`,
+		`data: {"id":"cmpl-1","object":"text_completion","model":"gpt-3.5-turbo-instruct","choices":[{"index":0,"text":" a test.","finish_reason":"stop"}]}`,
+		`data: {"id":"cmpl-1","object":"text_completion","model":"gpt-3.5-turbo-instruct","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":4,"total_tokens":9}}`,
+		`data: [DONE]`,
+	}
+	for _, chunk := range chunks {
+		_, err := interceptor.ChunkInterceptor([]byte(chunk+"\n\n"), state)
+		assert.NoError(t, err)
+	}
+	interceptor.OnComplete(state)
+
+	response := state.(*completionState).response
+	assert.Equal(t, "This is a test.", response.Choices[0].Text)
+	assert.Equal(t, "stop", response.Choices[0].FinishReason)
+
+	assert.Equal(t, "This is a test.", mockStorage.lastAssistantMsg.Content)
+	assert.Equal(t, 5, mockStorage.lastAssistantMsg.PromptTokens)
+	assert.Equal(t, 4, mockStorage.lastAssistantMsg.CompletionTokens)
+}
+
+func TestCompletionInterceptor_ChunkInterceptor_SplitEvents(t *testing.T) {
+	interceptor := &CompletionInterceptor{}
+	state := interceptor.CreateState()
+
+	// Events straddle chunk boundaries and the last event is not terminated
+	chunks := []string{
+		"data: {\"id\":\"cmpl-1\",\"choices\":[{\"index\":0,",
+		"\"text\":\"This is\"}]}\r\n\r\ndata: {\"id\":\"cmpl-1\",\"choices\"",
+		":[{\"index\":0,\"text\":\" a test.\",\"finish_reason\":\"stop\"}]}",
+	}
+	for _, chunk := range chunks {
+		out, err := interceptor.ChunkInterceptor([]byte(chunk), state)
+		assert.NoError(t, err)
+		assert.Equal(t, chunk, string(out))
+	}
+	interceptor.OnComplete(state)
+
+	response := state.(*completionState).response
+	assert.Equal(t, "This is a test.", response.Choices[0].Text)
+	assert.Equal(t, "stop", response.Choices[0].FinishReason)
+}
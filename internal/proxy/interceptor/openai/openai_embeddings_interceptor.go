@@ -0,0 +1,246 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EmbeddingsInterceptor intercepts embedding requests between client and OpenAI compatible server. The input
+// texts are stored as a user message, while the vectors are only stored if StoreVectors is set.
+type EmbeddingsInterceptor struct {
+	interceptor.SavingInterceptor
+	StoreVectors bool
+}
+
+// embeddingsRequest represents the structure of an OpenAI embeddings request
+type embeddingsRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	Dimensions     *int            `json:"dimensions,omitzero"`
+	EncodingFormat string          `json:"encoding_format,omitzero"`
+	User           string          `json:"user,omitzero"`
+}
+
+// embeddingData represents a single embedding, which is either a list of floats or a base64 encoded string
+type embeddingData struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"`
+}
+
+// dimensions returns the number of dimensions of the embedding
+func (d embeddingData) dimensions() int {
+	var vector []float64
+	if err := json.Unmarshal(d.Embedding, &vector); err == nil {
+		return len(vector)
+	}
+	var encoded string
+	if err := json.Unmarshal(d.Embedding, &encoded); err == nil {
+		// base64 encoded little-endian float32 values
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			return len(decoded) / 4
+		}
+	}
+	return 0
+}
+
+// embeddingsUsage represents token usage in an OpenAI embeddings response
+type embeddingsUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// embeddingsResponse represents the structure of an OpenAI embeddings response
+type embeddingsResponse struct {
+	Object string          `json:"object"`
+	Data   []embeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  embeddingsUsage `json:"usage,omitzero"`
+}
+
+// embeddingsState holds the state information for OpenAI embedding requests
+type embeddingsState struct {
+	request      embeddingsRequest
+	response     embeddingsResponse
+	startTime    time.Time
+	endTime      time.Time
+	statusCode   int
+	clientHost   string
+	upstreamHost string
+	exchange     *interceptor.Exchange
+}
+
+// embeddingInputs converts the input of an embeddings request into texts. The input is either a string,
+// a list of strings, a token array or a list of token arrays. Token arrays are kept as JSON text.
+func embeddingInputs(input json.RawMessage) []string {
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return []string{text}
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(input, &items); err != nil {
+		return nil
+	}
+	inputs := make([]string, 0, len(items))
+	for _, item := range items {
+		if err := json.Unmarshal(item, &text); err == nil {
+			inputs = append(inputs, text)
+		} else if len(item) > 0 && item[0] != '[' {
+			// A single token array
+			return []string{string(bytes.TrimSpace(input))}
+		} else {
+			inputs = append(inputs, string(item))
+		}
+	}
+	return inputs
+}
+
+// embeddingContent returns the content of the message storing the input of an embeddings request. A single text or
+// token array is stored as text, several inputs as the compact JSON list of the request.
+func embeddingContent(input json.RawMessage) (string, bool) {
+	inputs := embeddingInputs(input)
+	switch len(inputs) {
+	case 0:
+		return "", false
+	case 1:
+		return inputs[0], true
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, input); err != nil {
+		return string(input), true
+	}
+	return compact.String(), true
+}
+
+// CreateState creates a new state for the interceptor
+func (ei *EmbeddingsInterceptor) CreateState() interceptor.State {
+	return &embeddingsState{
+		startTime: time.Now(),
+	}
+}
+
+// RequestInterceptor intercepts the request to extract model and inputs
+func (ei *EmbeddingsInterceptor) RequestInterceptor(req *http.Request, state interceptor.State) error {
+	logrus.Printf("[%s] Intercepting request to %s", ei.Name, req.URL.Path)
+
+	// Read the request body
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(req.Body)
+
+	// Extract host information
+	openAIState, _ := state.(*embeddingsState)
+	openAIState.clientHost = req.Header.Get("X-Forwarded-For")
+	openAIState.exchange = interceptor.ExchangeFromContext(req.Context())
+
+	// Parse the embeddings request
+	var embeddingsReq embeddingsRequest
+	if err := json.Unmarshal(body, &embeddingsReq); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse request body", ei.Name)
+	} else {
+		openAIState.request = embeddingsReq
+	}
+
+	// Create a new body reader
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return nil
+}
+
+// ResponseInterceptor intercepts the response to extract the status code
+func (ei *EmbeddingsInterceptor) ResponseInterceptor(resp *http.Response, state interceptor.State) error {
+	openAIState, _ := state.(*embeddingsState)
+	openAIState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		openAIState.upstreamHost = resp.Request.URL.Host
+	}
+	return nil
+}
+
+// ContentInterceptor intercepts content to extract the embeddings
+func (ei *EmbeddingsInterceptor) ContentInterceptor(content []byte, state interceptor.State) ([]byte, error) {
+	openAIState, _ := state.(*embeddingsState)
+
+	// Parse the response
+	var embeddingsResp embeddingsResponse
+	if err := json.Unmarshal(content, &embeddingsResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response body", ei.Name)
+	} else {
+		openAIState.response = embeddingsResp
+	}
+
+	return content, nil
+}
+
+// ChunkInterceptor passes chunks through, embeddings are never streamed
+func (ei *EmbeddingsInterceptor) ChunkInterceptor(chunk []byte, _ interceptor.State) ([]byte, error) {
+	return chunk, nil
+}
+
+// OnComplete handles completion of the request
+func (ei *EmbeddingsInterceptor) OnComplete(state interceptor.State) {
+	openAIState, _ := state.(*embeddingsState)
+	openAIState.endTime = time.Now()
+
+	logrus.Printf("[%s] Request completed for model: %s, %d embeddings", ei.Name, openAIState.request.Model, len(openAIState.response.Data))
+
+	ei.saveLog(openAIState)
+}
+
+// OnError handles errors during request processing
+func (ei *EmbeddingsInterceptor) OnError(state interceptor.State, err error) {
+	openAIState, _ := state.(*embeddingsState)
+	openAIState.endTime = time.Now()
+	logrus.WithError(err).Warningf("[%s] Error occurred", ei.Name)
+
+	ei.saveLog(openAIState)
+}
+
+func (ei *EmbeddingsInterceptor) saveLog(openAIState *embeddingsState) {
+	ctx, cancel := context.WithTimeout(interceptor.WithExchange(context.Background(), openAIState.exchange), ei.Timeout)
+	defer cancel()
+
+	// All inputs of a request are stored as one message, so batches sharing their first inputs aren't merged
+	var history []storage.SimpleMessage
+	if content, ok := embeddingContent(openAIState.request.Input); ok {
+		history = []storage.SimpleMessage{{Role: "user", Content: content, Model: openAIState.request.Model, ClientHost: openAIState.clientHost}}
+	}
+
+	metadata := openAIState.exchange.Metadata()
+	metadata["count"] = len(openAIState.response.Data)
+	if len(openAIState.response.Data) > 0 {
+		metadata["dimensions"] = openAIState.response.Data[0].dimensions()
+	}
+	if ei.StoreVectors {
+		vectors := make([]json.RawMessage, len(openAIState.response.Data))
+		for i, d := range openAIState.response.Data {
+			vectors[i] = d.Embedding
+		}
+		metadata["embeddings"] = vectors
+	}
+
+	assistantMsg := storage.SimpleMessage{
+		Role:         "assistant",
+		Model:        openAIState.response.Model,
+		PromptTokens: openAIState.response.Usage.PromptTokens,
+		EvalDuration: openAIState.endTime.Sub(openAIState.startTime),
+		UpstreamHost: openAIState.upstreamHost,
+		Metadata:     metadata,
+	}
+
+	ei.SaveToStorage(ctx, history, assistantMsg, openAIState.statusCode, "embedding")
+}
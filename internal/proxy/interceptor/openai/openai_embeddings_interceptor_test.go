@@ -0,0 +1,132 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddingInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"String", `"hello"`, []string{"hello"}},
+		{"List of strings", `["hello", "world"]`, []string{"hello", "world"}},
+		{"Token array", `[1, 2, 3]`, []string{"[1, 2, 3]"}},
+		{"List of token arrays", `[[1, 2], [3]]`, []string{"[1, 2]", "[3]"}},
+		{"Invalid", `{}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, embeddingInputs(json.RawMessage(tt.input)))
+		})
+	}
+}
+
+func TestEmbeddingContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"String", `"hello"`, "hello"},
+		{"Single string", `["hello"]`, "hello"},
+		{"List of strings", `["hello", "world"]`, `["hello","world"]`},
+		{"Token array", `[1, 2, 3]`, "[1, 2, 3]"},
+		{"List of token arrays", `[[1, 2], [3]]`, "[[1,2],[3]]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, ok := embeddingContent(json.RawMessage(tt.input))
+			assert.True(t, ok)
+			assert.Equal(t, tt.expected, content)
+		})
+	}
+
+	_, ok := embeddingContent(json.RawMessage(`{}`))
+	assert.False(t, ok)
+}
+
+func TestEmbeddingsInterceptor_SaveLog(t *testing.T) {
+	responseBody := `{
+		"object": "list",
+		"data": [
+			{"object": "embedding", "index": 0, "embedding": [0.1, 0.2, 0.3]},
+			{"object": "embedding", "index": 1, "embedding": [0.4, 0.5, 0.6]}
+		],
+		"model": "text-embedding-3-small",
+		"usage": {"prompt_tokens": 8, "total_tokens": 8}
+	}`
+
+	for _, storeVectors := range []bool{false, true} {
+		store := &embeddingStorage{}
+		interceptor := &EmbeddingsInterceptor{
+			SavingInterceptor: interceptor2.SavingInterceptor{
+				Storage: store,
+				Timeout: 1 * time.Second,
+			},
+			StoreVectors: storeVectors,
+		}
+		state := interceptor.CreateState().(*embeddingsState)
+		state.statusCode = 200
+		state.request = embeddingsRequest{Model: "text-embedding-3-small", Input: json.RawMessage(`["first", "second"]`)}
+
+		_, err := interceptor.ContentInterceptor([]byte(responseBody), state)
+		assert.NoError(t, err)
+		interceptor.OnComplete(state)
+
+		// The inputs are stored as a single message
+		assert.Len(t, store.messages, 2)
+		assert.Equal(t, `["first","second"]`, store.messages[0].Content)
+		assert.Equal(t, "embedding", store.requestType)
+
+		result := store.messages[1]
+		assert.Equal(t, "text-embedding-3-small", result.Model)
+		assert.Equal(t, 8, result.PromptTokens)
+		assert.Equal(t, 3, result.Metadata["dimensions"])
+		assert.Equal(t, 2, result.Metadata["count"])
+		if storeVectors {
+			assert.Len(t, result.Metadata["embeddings"], 2)
+		} else {
+			assert.NotContains(t, result.Metadata, "embeddings")
+		}
+	}
+}
+
+func TestEmbeddingData_Base64Dimensions(t *testing.T) {
+	// Four float32 values
+	data := embeddingData{Embedding: json.RawMessage(`"AAAAAAAAAAAAAAAAAAAAAA=="`)}
+	assert.Equal(t, 4, data.dimensions())
+}
+
+// embeddingStorage records all added messages and the request type
+type embeddingStorage struct {
+	storage.Storage
+	messages    []storage.SimpleMessage
+	requestType string
+}
+
+func (m *embeddingStorage) FindMessageByHistory(ctx context.Context, history []storage.SimpleMessage, requestType string) (uuid.UUID, error) {
+	return uuid.Nil, nil
+}
+
+func (m *embeddingStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*storage.Conversation, *storage.Branch, error) {
+	m.messages = nil
+	m.requestType = requestType
+	return &storage.Conversation{ID: uuid.New()}, &storage.Branch{ID: uuid.New()}, nil
+}
+
+func (m *embeddingStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *storage.Message) (*storage.Message, error) {
+	m.messages = append(m.messages, message.SimpleMessage)
+	return &storage.Message{ID: uuid.New(), SimpleMessage: message.SimpleMessage}, nil
+}
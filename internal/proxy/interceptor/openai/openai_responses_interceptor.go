@@ -0,0 +1,576 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxCachedResponses limits the number of responses kept for resolving previous_response_id
+const maxCachedResponses = 1000
+
+// ResponsesInterceptor intercepts requests to the OpenAI Responses API. Since requests referring to a previous
+// response via previous_response_id only contain the new input items, the interceptor keeps the histories of
+// recent responses in memory to store the complete conversation.
+type ResponsesInterceptor struct {
+	interceptor.SavingInterceptor
+	// Histories caches the histories of recent responses, so it can be shared with the interceptors created when the
+	// configuration is reloaded. If it is nil, the interceptor uses a cache of its own.
+	Histories *ResponseHistories
+
+	histories ResponseHistories
+}
+
+// ResponseHistories keeps the complete histories of recent responses for resolving previous_response_id. The zero
+// value is an empty cache.
+type ResponseHistories struct {
+	mu        sync.Mutex
+	histories map[string][]storage.SimpleMessage
+	order     []string
+}
+
+// responsesContentPart represents a content part of a message item
+type responsesContentPart struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitzero"`
+	Refusal string `json:"refusal,omitzero"`
+}
+
+// responsesContent represents the content of a message item, which is either a plain string or a list of parts
+type responsesContent []responsesContentPart
+
+// UnmarshalJSON accepts both a plain string and a list of content parts
+func (c *responsesContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = responsesContent{{Type: "input_text", Text: text}}
+		return nil
+	}
+	var parts []responsesContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	*c = parts
+	return nil
+}
+
+// text returns the concatenated text of all parts
+func (c responsesContent) text() string {
+	var sb strings.Builder
+	for _, part := range c {
+		sb.WriteString(part.Text)
+		sb.WriteString(part.Refusal)
+	}
+	return sb.String()
+}
+
+// responsesSummary represents a summary part of a reasoning item
+type responsesSummary struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// responsesItem represents an input or output item. Depending on the type, only some fields are set.
+type responsesItem struct {
+	Type      string             `json:"type,omitzero"`
+	ID        string             `json:"id,omitzero"`
+	Role      string             `json:"role,omitzero"`
+	Content   responsesContent   `json:"content,omitzero"`
+	CallID    string             `json:"call_id,omitzero"`
+	Name      string             `json:"name,omitzero"`
+	Arguments string             `json:"arguments,omitzero"`
+	Output    json.RawMessage    `json:"output,omitzero"`
+	Summary   []responsesSummary `json:"summary,omitzero"`
+	Status    string             `json:"status,omitzero"`
+}
+
+// outputText returns the output of a function_call_output item as text
+func (item responsesItem) outputText() string {
+	var text string
+	if err := json.Unmarshal(item.Output, &text); err == nil {
+		return text
+	}
+	var content responsesContent
+	if err := json.Unmarshal(item.Output, &content); err == nil {
+		return content.text()
+	}
+	return string(item.Output)
+}
+
+// summaryText returns the concatenated summary of a reasoning item
+func (item responsesItem) summaryText() string {
+	parts := make([]string, 0, len(item.Summary))
+	for _, s := range item.Summary {
+		if s.Text != "" {
+			parts = append(parts, s.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// responsesInput represents the input of a request, which is either a plain string or a list of items
+type responsesInput []responsesItem
+
+// UnmarshalJSON accepts both a plain string and a list of input items
+func (in *responsesInput) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*in = responsesInput{{Type: "message", Role: "user", Content: responsesContent{{Type: "input_text", Text: text}}}}
+		return nil
+	}
+	var items []responsesItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*in = items
+	return nil
+}
+
+// responsesTool represents a tool offered to the model. Built-in tools only have a type.
+type responsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name,omitzero"`
+	Description string          `json:"description,omitzero"`
+	Parameters  json.RawMessage `json:"parameters,omitzero"`
+}
+
+// responsesRequest represents the structure of an OpenAI Responses API request
+type responsesRequest struct {
+	Model              string          `json:"model"`
+	Input              responsesInput  `json:"input"`
+	Instructions       string          `json:"instructions,omitzero"`
+	PreviousResponseID string          `json:"previous_response_id,omitzero"`
+	Tools              []responsesTool `json:"tools,omitzero"`
+	ToolChoice         json.RawMessage `json:"tool_choice,omitzero"`
+	Stream             bool            `json:"stream,omitzero"`
+	Store              *bool           `json:"store,omitzero"`
+	Reasoning          json.RawMessage `json:"reasoning,omitzero"`
+	Temperature        *float64        `json:"temperature,omitzero"`
+	TopP               *float64        `json:"top_p,omitzero"`
+	MaxOutputTokens    *int            `json:"max_output_tokens,omitzero"`
+}
+
+// responsesUsage represents token usage of a response
+type responsesUsage struct {
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens,omitzero"`
+	} `json:"output_tokens_details,omitzero"`
+}
+
+// responsesResponse represents the structure of an OpenAI Responses API response
+type responsesResponse struct {
+	ID                 string          `json:"id"`
+	Object             string          `json:"object"`
+	CreatedAt          int64           `json:"created_at"`
+	Status             string          `json:"status,omitzero"`
+	Model              string          `json:"model"`
+	Output             []responsesItem `json:"output"`
+	PreviousResponseID string          `json:"previous_response_id,omitzero"`
+	Usage              responsesUsage  `json:"usage,omitzero"`
+	IncompleteDetails  *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details,omitzero"`
+}
+
+// finishReason returns why an incomplete response has been stopped, or the status of the response otherwise
+func (r *responsesResponse) finishReason() string {
+	if r.IncompleteDetails != nil && r.IncompleteDetails.Reason != "" {
+		return r.IncompleteDetails.Reason
+	}
+	return r.Status
+}
+
+// responsesEvent represents a typed Server-Sent Event of a streaming response
+type responsesEvent struct {
+	Type         string             `json:"type"`
+	Response     *responsesResponse `json:"response,omitzero"`
+	OutputIndex  int                `json:"output_index"`
+	ContentIndex int                `json:"content_index"`
+	SummaryIndex int                `json:"summary_index"`
+	Item         *responsesItem     `json:"item,omitzero"`
+	Delta        string             `json:"delta,omitzero"`
+	Code         string             `json:"code,omitzero"`
+	Message      string             `json:"message,omitzero"`
+}
+
+// responsesState holds the state information for OpenAI Responses API requests
+type responsesState struct {
+	request      responsesRequest
+	response     responsesResponse
+	startTime    time.Time
+	endTime      time.Time
+	statusCode   int
+	clientHost   string
+	upstreamHost string
+	exchange     *interceptor.Exchange
+	parameters   map[string]any
+	decoder      interceptor.SSEDecoder
+}
+
+// CreateState creates a new state for the interceptor
+func (ri *ResponsesInterceptor) CreateState() interceptor.State {
+	return &responsesState{
+		startTime: time.Now(),
+	}
+}
+
+// RequestInterceptor intercepts the request to extract model and context information
+func (ri *ResponsesInterceptor) RequestInterceptor(req *http.Request, state interceptor.State) error {
+	logrus.Printf("[%s] Intercepting request to %s", ri.Name, req.URL.Path)
+
+	// Read the request body
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(req.Body)
+
+	// Extract host information
+	openAIState, _ := state.(*responsesState)
+	openAIState.clientHost = req.Header.Get("X-Forwarded-For")
+	openAIState.exchange = interceptor.ExchangeFromContext(req.Context())
+	openAIState.parameters = interceptor.RequestParameters(body, "model", "input", "instructions", "previous_response_id", "tools", "stream", "user", "metadata")
+
+	// Parse the responses request
+	var responsesReq responsesRequest
+	if err := json.Unmarshal(body, &responsesReq); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse request body", ri.Name)
+	} else {
+		openAIState.request = responsesReq
+	}
+
+	// Create a new body reader
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return nil
+}
+
+// ResponseInterceptor intercepts the response to extract response messages
+func (ri *ResponsesInterceptor) ResponseInterceptor(resp *http.Response, state interceptor.State) error {
+	openAIState, _ := state.(*responsesState)
+	openAIState.statusCode = resp.StatusCode
+	if resp.Request != nil {
+		// The upstream is selected after the request interceptor has been applied
+		openAIState.upstreamHost = resp.Request.URL.Host
+	}
+	return nil
+}
+
+// ContentInterceptor intercepts content to extract response messages (non-streaming)
+func (ri *ResponsesInterceptor) ContentInterceptor(content []byte, state interceptor.State) ([]byte, error) {
+	openAIState, _ := state.(*responsesState)
+
+	// Parse the response
+	var responsesResp responsesResponse
+	if err := json.Unmarshal(content, &responsesResp); err != nil {
+		logrus.WithError(err).Warningf("[%s] Warning: Could not parse response body", ri.Name)
+	} else {
+		openAIState.response = responsesResp
+	}
+
+	return content, nil
+}
+
+// ChunkInterceptor intercepts chunks for streaming responses
+func (ri *ResponsesInterceptor) ChunkInterceptor(chunk []byte, state interceptor.State) ([]byte, error) {
+	openAIState, _ := state.(*responsesState)
+
+	// Server-Sent Events (SSE) format: event: <type>\ndata: {...}
+	ri.applyEvents(openAIState, openAIState.decoder.Decode(chunk))
+
+	return chunk, nil
+}
+
+// applyEvents parses decoded stream events and aggregates them into the response
+func (ri *ResponsesInterceptor) applyEvents(openAIState *responsesState, events []interceptor.SSEEvent) {
+	for _, sseEvent := range events {
+		if sseEvent.Data == "[DONE]" {
+			continue
+		}
+		var event responsesEvent
+		if err := json.Unmarshal([]byte(sseEvent.Data), &event); err != nil {
+			logrus.WithError(err).Warningf("[%s] Warning: Could not parse response chunk", ri.Name)
+			continue
+		}
+		ri.applyEvent(openAIState, &event)
+	}
+}
+
+// applyEvent aggregates a single stream event into the response
+func (ri *ResponsesInterceptor) applyEvent(openAIState *responsesState, event *responsesEvent) {
+	response := &openAIState.response
+	switch event.Type {
+	case "response.created", "response.in_progress":
+		if event.Response != nil {
+			*response = *event.Response
+		}
+	case "response.output_item.added", "response.output_item.done":
+		if event.Item != nil {
+			*outputItem(response, event.OutputIndex) = *event.Item
+		}
+	case "response.output_text.delta", "response.refusal.delta":
+		item := outputItem(response, event.OutputIndex)
+		for len(item.Content) <= event.ContentIndex {
+			item.Content = append(item.Content, responsesContentPart{Type: "output_text"})
+		}
+		if event.Type == "response.refusal.delta" {
+			item.Content[event.ContentIndex].Refusal += event.Delta
+		} else {
+			item.Content[event.ContentIndex].Text += event.Delta
+		}
+	case "response.function_call_arguments.delta":
+		item := outputItem(response, event.OutputIndex)
+		item.Arguments += event.Delta
+	case "response.reasoning_summary_text.delta":
+		item := outputItem(response, event.OutputIndex)
+		for len(item.Summary) <= event.SummaryIndex {
+			item.Summary = append(item.Summary, responsesSummary{Type: "summary_text"})
+		}
+		item.Summary[event.SummaryIndex].Text += event.Delta
+	case "response.completed", "response.incomplete", "response.failed":
+		// The final response is authoritative, but some servers omit the output which has already been streamed
+		if event.Response != nil {
+			output := response.Output
+			*response = *event.Response
+			if len(response.Output) == 0 {
+				response.Output = output
+			}
+		}
+	case "error":
+		logrus.Warningf("[%s] Upstream reported error in stream: %s: %s", ri.Name, event.Code, event.Message)
+	}
+}
+
+// outputItem returns the output item at the given index, growing the output if necessary
+func outputItem(response *responsesResponse, index int) *responsesItem {
+	for len(response.Output) <= index {
+		response.Output = append(response.Output, responsesItem{})
+	}
+	return &response.Output[index]
+}
+
+// OnComplete handles completion of the request
+func (ri *ResponsesInterceptor) OnComplete(state interceptor.State) {
+	openAIState, _ := state.(*responsesState)
+
+	openAIState.endTime = time.Now()
+	ri.applyEvents(openAIState, openAIState.decoder.Flush())
+
+	logrus.Printf("[%s] Request completed for model: %s", ri.Name, openAIState.request.Model)
+	ri.logRequestResponse(openAIState)
+
+	ri.saveLog(openAIState)
+}
+
+// OnError handles errors during request processing
+func (ri *ResponsesInterceptor) OnError(state interceptor.State, err error) {
+	openAIState, _ := state.(*responsesState)
+	openAIState.endTime = time.Now()
+	ri.applyEvents(openAIState, openAIState.decoder.Flush())
+	logrus.WithError(err).Warningf("[%s] Error occurred", ri.Name)
+	ri.logRequestResponse(openAIState)
+
+	ri.saveLog(openAIState)
+}
+
+func (ri *ResponsesInterceptor) logRequestResponse(openAIState *responsesState) {
+	for _, item := range openAIState.request.Input {
+		if item.Role != "" {
+			logrus.Printf("[%s] Request [%s]: %s", ri.Name, item.Role, item.Content.text())
+		}
+	}
+	for _, item := range openAIState.response.Output {
+		if item.Type == "message" {
+			logrus.Printf("[%s] Response [%s]: %s", ri.Name, item.Role, item.Content.text())
+		}
+	}
+}
+
+// convertItems converts input or output items into simple messages. Function calls and reasoning items are
+// attached to the preceding assistant message, or to a new assistant message without content, so that a
+// stored response matches the items sent back by the client in subsequent requests.
+func convertItems(items []responsesItem) []storage.SimpleMessage {
+	var messages []storage.SimpleMessage
+	var thinking []string
+
+	// assistant returns the assistant message to attach function calls and reasoning to
+	assistant := func() *storage.SimpleMessage {
+		if len(messages) == 0 || messages[len(messages)-1].Role != "assistant" {
+			messages = append(messages, storage.SimpleMessage{Role: "assistant"})
+		}
+		return &messages[len(messages)-1]
+	}
+
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			messages = append(messages, storage.SimpleMessage{
+				Role:    item.Role,
+				Content: item.Content.text(),
+			})
+			if item.Role == "assistant" && len(thinking) > 0 {
+				messages[len(messages)-1].Metadata = map[string]any{"thinking": strings.Join(thinking, "\n")}
+				thinking = nil
+			}
+		case "function_call":
+			msg := assistant()
+			toolCall := storage.ToolCall{
+				ID:   item.CallID,
+				Type: "function",
+			}
+			toolCall.Function.Name = item.Name
+			toolCall.Function.Arguments = item.Arguments
+			msg.ToolCalls = append(msg.ToolCalls, toolCall)
+		case "function_call_output":
+			messages = append(messages, storage.SimpleMessage{
+				Role:       "tool",
+				Content:    item.outputText(),
+				ToolCallID: item.CallID,
+			})
+		case "reasoning":
+			if summary := item.summaryText(); summary != "" {
+				thinking = append(thinking, summary)
+			}
+		}
+	}
+
+	// Reasoning without a following message belongs to the last assistant message
+	if len(thinking) > 0 {
+		msg := assistant()
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]any)
+		}
+		msg.Metadata["thinking"] = strings.Join(thinking, "\n")
+	}
+	return messages
+}
+
+// cache returns the shared cache of response histories, or the own one if none is set
+func (ri *ResponsesInterceptor) cache() *ResponseHistories {
+	if ri.Histories != nil {
+		return ri.Histories
+	}
+	return &ri.histories
+}
+
+// get returns the stored history of a previous response
+func (h *ResponseHistories) get(responseID string) ([]storage.SimpleMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	history, ok := h.histories[responseID]
+	return history, ok
+}
+
+// remember keeps the complete history of a response, evicting the oldest response if necessary
+func (h *ResponseHistories) remember(responseID string, history []storage.SimpleMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.histories == nil {
+		h.histories = make(map[string][]storage.SimpleMessage)
+	}
+	if _, ok := h.histories[responseID]; !ok {
+		h.order = append(h.order, responseID)
+	}
+	h.histories[responseID] = history
+	if len(h.order) > maxCachedResponses {
+		delete(h.histories, h.order[0])
+		h.order = h.order[1:]
+	}
+}
+
+func (ri *ResponsesInterceptor) saveLog(openAIState *responsesState) {
+	ctx, cancel := context.WithTimeout(interceptor.WithExchange(context.Background(), openAIState.exchange), ri.Timeout)
+	defer cancel()
+
+	request := openAIState.request
+	tools := make([]storage.Tool, len(request.Tools))
+	for i, t := range request.Tools {
+		name := t.Name
+		if name == "" {
+			name = t.Type
+		}
+		tools[i] = storage.Tool{
+			Name:        name,
+			Description: t.Description,
+			Parameters:  t.Parameters,
+		}
+	}
+
+	// Prepend the history of the previous response, instructions are not carried over by the API
+	var history []storage.SimpleMessage
+	if request.Instructions != "" {
+		history = append(history, storage.SimpleMessage{Role: "system", Content: request.Instructions})
+	}
+	if request.PreviousResponseID != "" {
+		previous, ok := ri.cache().get(request.PreviousResponseID)
+		if !ok {
+			logrus.Warningf("[%s] Unknown previous response %s, storing as new conversation", ri.Name, request.PreviousResponseID)
+		}
+		if len(history) > 0 && len(previous) > 0 && previous[0].Role == "system" {
+			previous = previous[1:]
+		}
+		history = append(history, previous...)
+	}
+	input := convertItems(request.Input)
+	for i := range input {
+		input[i].Model = request.Model
+		input[i].ClientHost = openAIState.clientHost
+		input[i].Tools = tools
+	}
+	history = append(history, input...)
+
+	// Responses without output, e.g. errors, are stored as an empty assistant message keeping the metadata of the
+	// exchange, like the attempts of failed retries
+	var assistantMsg storage.SimpleMessage
+	response := openAIState.response
+	output := convertItems(response.Output)
+	metadata := openAIState.exchange.Metadata()
+	if len(output) > 0 {
+		// All output items form a single assistant message
+		assistantMsg = output[0]
+		for _, msg := range output[1:] {
+			assistantMsg.Content += msg.Content
+			assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, msg.ToolCalls...)
+		}
+		if thinking, ok := assistantMsg.Metadata["thinking"]; ok {
+			metadata["thinking"] = thinking
+		}
+		metadata["response_id"] = response.ID
+		if finishReason := response.finishReason(); finishReason != "" {
+			metadata["finish_reason"] = finishReason
+		}
+		assistantMsg.PromptTokens = response.Usage.InputTokens
+		assistantMsg.CompletionTokens = response.Usage.OutputTokens
+	}
+	if openAIState.parameters != nil {
+		metadata["parameters"] = openAIState.parameters
+	}
+
+	assistantMsg.Role = "assistant"
+	assistantMsg.Model = response.Model
+	assistantMsg.EvalDuration = openAIState.endTime.Sub(openAIState.startTime)
+	assistantMsg.UpstreamHost = openAIState.upstreamHost
+	assistantMsg.Metadata = metadata
+	assistantMsg.Tools = tools
+
+	ri.SaveToStorage(ctx, history, assistantMsg, openAIState.statusCode, "chat")
+
+	if response.ID != "" && !openAIState.endTime.IsZero() && len(output) > 0 {
+		ri.cache().remember(response.ID, append(slices.Clone(history), assistantMsg))
+	}
+}
@@ -0,0 +1,202 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponsesInterceptor_ChunkInterceptor_AggregatesEvents(t *testing.T) {
+	interceptor := &ResponsesInterceptor{}
+	state := interceptor.CreateState()
+
+	chunks := []string{
+		"event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"object\":\"response\",\"status\":\"in_progress\",\"model\":\"gpt-4.1\",\"output\":[]}}\n\n",
+		"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"output_index\":0,\"item\":{\"type\":\"reasoning\",\"id\":\"rs_1\",\"summary\":[]}}\n\n",
+		"event: response.reasoning_summary_text.delta\ndata: {\"type\":\"response.reasoning_summary_text.delta\",\"output_index\":0,\"summary_index\":0,\"delta\":\"Thinking about \"}\n\n",
+		"event: response.reasoning_summary_text.delta\ndata: {\"type\":\"response.reasoning_summary_text.delta\",\"output_index\":0,\"summary_index\":0,\"delta\":\"the weather.\"}\n\n",
+		"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"output_index\":1,\"item\":{\"type\":\"message\",\"id\":\"msg_1\",\"role\":\"assistant\",\"content\":[]}}\n\n",
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"msg_1\",\"output_index\":1,\"content_index\":0,\"delta\":\"Let me \"}\n\n",
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"msg_1\",\"output_index\":1,\"content_index\":0,\"delta\":\"check.\"}\n\n",
+		"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"output_index\":2,\"item\":{\"type\":\"function_call\",\"id\":\"fc_1\",\"call_id\":\"call_1\",\"name\":\"get_weather\",\"arguments\":\"\"}}\n\n",
+		"event: response.function_call_arguments.delta\ndata: {\"type\":\"response.function_call_arguments.delta\",\"item_id\":\"fc_1\",\"output_index\":2,\"delta\":\"{\\\"location\\\":\"}\n\n",
+		"event: response.function_call_arguments.delta\ndata: {\"type\":\"response.function_call_arguments.delta\",\"item_id\":\"fc_1\",\"output_index\":2,\"delta\":\"\\\"Paris\\\"}\"}\n\n",
+		"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"object\":\"response\",\"status\":\"completed\",\"model\":\"gpt-4.1\",\"output\":[],\"usage\":{\"input_tokens\":30,\"output_tokens\":12,\"total_tokens\":42}}}\n\n",
+	}
+
+	for _, chunk := range chunks {
+		out, err := interceptor.ChunkInterceptor([]byte(chunk), state)
+		assert.NoError(t, err)
+		assert.Equal(t, chunk, string(out))
+	}
+
+	response := state.(*responsesState).response
+	assert.Equal(t, "resp_1", response.ID)
+	assert.Equal(t, "completed", response.Status)
+	assert.Equal(t, 30, response.Usage.InputTokens)
+	assert.Len(t, response.Output, 3)
+	assert.Equal(t, "Thinking about the weather.", response.Output[0].summaryText())
+	assert.Equal(t, "Let me check.", response.Output[1].Content.text())
+	assert.Equal(t, `{"location":"Paris"}`, response.Output[2].Arguments)
+
+	messages := convertItems(response.Output)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "assistant", messages[0].Role)
+	assert.Equal(t, "Let me check.", messages[0].Content)
+	assert.Equal(t, "Thinking about the weather.", messages[0].Metadata["thinking"])
+	assert.Len(t, messages[0].ToolCalls, 1)
+	assert.Equal(t, "call_1", messages[0].ToolCalls[0].ID)
+	assert.Equal(t, "get_weather", messages[0].ToolCalls[0].Function.Name)
+}
+
+func TestResponsesInterceptor_ContentInterceptor(t *testing.T) {
+	interceptor := &ResponsesInterceptor{}
+	state := interceptor.CreateState()
+
+	responseBody := `{
+		"id": "resp_1",
+		"object": "response",
+		"status": "completed",
+		"model": "gpt-4.1",
+		"output": [
+			{"type": "message", "id": "msg_1", "role": "assistant", "content": [{"type": "output_text", "text": "Hello!"}]}
+		],
+		"usage": {"input_tokens": 5, "output_tokens": 2, "total_tokens": 7}
+	}`
+
+	_, err := interceptor.ContentInterceptor([]byte(responseBody), state)
+	assert.NoError(t, err)
+
+	response := state.(*responsesState).response
+	assert.Equal(t, "resp_1", response.ID)
+	assert.Equal(t, "Hello!", response.Output[0].Content.text())
+	assert.Equal(t, 2, response.Usage.OutputTokens)
+}
+
+func TestResponsesInterceptor_PreviousResponseChaining(t *testing.T) {
+	store := &recordingStorage{}
+	interceptor := &ResponsesInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: store,
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	// First turn with a plain string input
+	first := interceptor.CreateState()
+	req, _ := http.NewRequest("POST", "/v1/responses", bytes.NewBufferString(`{"model":"gpt-4.1","instructions":"Be brief.","input":"What's the weather in Paris?"}`))
+	assert.NoError(t, interceptor.RequestInterceptor(req, first))
+	_, err := interceptor.ContentInterceptor([]byte(`{"id":"resp_1","model":"gpt-4.1","output":[{"type":"function_call","call_id":"call_1","name":"get_weather","arguments":"{}"}]}`), first)
+	assert.NoError(t, err)
+	interceptor.OnComplete(first)
+
+	// Second turn only contains the tool output and refers to the first response
+	second := interceptor.CreateState()
+	req, _ = http.NewRequest("POST", "/v1/responses", bytes.NewBufferString(`{
+		"model": "gpt-4.1",
+		"instructions": "Be brief.",
+		"previous_response_id": "resp_1",
+		"input": [{"type": "function_call_output", "call_id": "call_1", "output": "15 degrees"}]
+	}`))
+	assert.NoError(t, interceptor.RequestInterceptor(req, second))
+	_, err = interceptor.ContentInterceptor([]byte(`{"id":"resp_2","model":"gpt-4.1","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"15 degrees."}]}]}`), second)
+	assert.NoError(t, err)
+	interceptor.OnComplete(second)
+
+	history := store.lastHistory
+	assert.Len(t, history, 4)
+	assert.Equal(t, "system", history[0].Role)
+	assert.Equal(t, "Be brief.", history[0].Content)
+	assert.Equal(t, "user", history[1].Role)
+	assert.Equal(t, "assistant", history[2].Role)
+	assert.Equal(t, "call_1", history[2].ToolCalls[0].ID)
+	assert.Equal(t, "tool", history[3].Role)
+	assert.Equal(t, "call_1", history[3].ToolCallID)
+	assert.Equal(t, "15 degrees", history[3].Content)
+
+	assistant := store.messages[len(store.messages)-1]
+	assert.Equal(t, "15 degrees.", assistant.Content)
+	assert.Equal(t, "resp_2", assistant.Metadata["response_id"])
+}
+
+func TestResponsesInterceptor_ErrorKeepsExchangeMetadata(t *testing.T) {
+	store := &recordingStorage{}
+	interceptor := &ResponsesInterceptor{
+		SavingInterceptor: interceptor2.SavingInterceptor{
+			Storage: store,
+			Timeout: 1 * time.Second,
+		},
+	}
+
+	exchange := interceptor2.NewExchange()
+	exchange.SetMetadata("attempts", 2)
+	state := interceptor.CreateState()
+	req, _ := http.NewRequest("POST", "/v1/responses", bytes.NewBufferString(`{"model":"gpt-4.1","input":"Hello"}`))
+	req = req.WithContext(interceptor2.WithExchange(req.Context(), exchange))
+	assert.NoError(t, interceptor.RequestInterceptor(req, state))
+	resp := &http.Response{StatusCode: http.StatusBadGateway, Request: httptest.NewRequest("POST", "http://upstream:8080/v1/responses", nil)}
+	assert.NoError(t, interceptor.ResponseInterceptor(resp, state))
+	interceptor.OnError(state, errors.New("bad gateway"))
+
+	// The failed request is stored without output but with the metadata of the exchange
+	assistant := store.messages[len(store.messages)-1]
+	assert.Equal(t, "assistant", assistant.Role)
+	assert.Empty(t, assistant.Content)
+	assert.Equal(t, "upstream:8080", assistant.UpstreamHost)
+	assert.Equal(t, 2, assistant.Metadata["attempts"])
+	assert.NotContains(t, assistant.Metadata, "response_id")
+}
+
+func TestConvertItems_ReplayedOutputMatchesStoredResponse(t *testing.T) {
+	input := []responsesItem{
+		{Type: "message", Role: "user", Content: responsesContent{{Type: "input_text", Text: "Hi"}}},
+		{Type: "reasoning", Summary: []responsesSummary{{Type: "summary_text", Text: "Greeting"}}},
+		{Type: "message", Role: "assistant", Content: responsesContent{{Type: "output_text", Text: "Hello"}}},
+		{Type: "function_call", CallID: "call_1", Name: "lookup", Arguments: "{}"},
+		{Type: "function_call", CallID: "call_2", Name: "lookup", Arguments: "{}"},
+		{Type: "function_call_output", CallID: "call_1", Output: []byte(`"one"`)},
+		{Type: "function_call_output", CallID: "call_2", Output: []byte(`[{"type":"input_text","text":"two"}]`)},
+	}
+
+	messages := convertItems(input)
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "Hello", messages[1].Content)
+	assert.Equal(t, "Greeting", messages[1].Metadata["thinking"])
+	assert.Len(t, messages[1].ToolCalls, 2)
+	assert.Equal(t, "one", messages[2].Content)
+	assert.Equal(t, "two", messages[3].Content)
+}
+
+// recordingStorage records all added messages and the longest history looked up
+type recordingStorage struct {
+	storage.Storage
+	messages    []storage.SimpleMessage
+	parents     []uuid.UUID
+	lastHistory []storage.SimpleMessage
+}
+
+func (m *recordingStorage) FindMessageByHistory(ctx context.Context, history []storage.SimpleMessage, requestType string) (uuid.UUID, error) {
+	if len(history) > len(m.lastHistory) {
+		m.lastHistory = history
+	}
+	return uuid.Nil, nil
+}
+
+func (m *recordingStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*storage.Conversation, *storage.Branch, error) {
+	return &storage.Conversation{ID: uuid.New()}, &storage.Branch{ID: uuid.New()}, nil
+}
+
+func (m *recordingStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *storage.Message) (*storage.Message, error) {
+	m.messages = append(m.messages, message.SimpleMessage)
+	m.parents = append(m.parents, parentMessageID)
+	return &storage.Message{ID: uuid.New(), SimpleMessage: message.SimpleMessage}, nil
+}
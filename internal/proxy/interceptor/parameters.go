@@ -0,0 +1,20 @@
+package interceptor
+
+import "encoding/json"
+
+// RequestParameters returns the top level fields of a JSON request body except the excluded ones. Interceptors use it
+// to keep the parameters a response has been generated with, like temperature, seed or max_tokens, in the metadata of
+// the assistant message. It returns nil if the body is not a JSON object or no other fields are set.
+func RequestParameters(body []byte, exclude ...string) map[string]any {
+	var parameters map[string]any
+	if err := json.Unmarshal(body, &parameters); err != nil {
+		return nil
+	}
+	for _, key := range exclude {
+		delete(parameters, key)
+	}
+	if len(parameters) == 0 {
+		return nil
+	}
+	return parameters
+}
@@ -0,0 +1,245 @@
+package interceptor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"llm-monitor/internal/storage"
+	"regexp"
+	"strings"
+)
+
+// RedactionAction defines how a value matched by a redaction rule is replaced
+type RedactionAction string
+
+const (
+	// RedactMask replaces the value with a placeholder naming the rule, e.g. "[REDACTED:email]"
+	RedactMask RedactionAction = "mask"
+	// RedactHash replaces the value with a truncated SHA-256 hash, so equal values can still be correlated
+	RedactHash RedactionAction = "hash"
+	// RedactDrop removes the value without leaving a trace
+	RedactDrop RedactionAction = "drop"
+)
+
+// RedactionRule configures a single redaction. Type is either one of the built-in detectors "api_key", "email",
+// "credit_card" and "iban", or "regex" for a custom Pattern. Name labels the masks and hashes and defaults to the type.
+type RedactionRule struct {
+	Name    string
+	Type    string
+	Pattern string
+	Action  RedactionAction
+}
+
+// detector finds sensitive values, matches of the pattern are only redacted if they are valid
+type detector struct {
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}
+
+var detectors = map[string]detector{
+	"api_key": {pattern: regexp.MustCompile(`\b(?:sk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|xox[abprs]-[A-Za-z0-9-]{10,}|AIza[0-9A-Za-z_-]{35})`)},
+	"email":   {pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	"credit_card": {
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   luhn,
+	},
+	"iban": {
+		pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`),
+		valid:   validIBAN,
+	},
+}
+
+// redaction is a compiled redaction rule
+type redaction struct {
+	name   string
+	action RedactionAction
+	detector
+}
+
+// Redactor removes sensitive values like API keys, email addresses or credit card numbers from messages before
+// they are saved to storage. Rules are applied in order.
+type Redactor struct {
+	redactions []redaction
+}
+
+// NewRedactor compiles the redaction rules. The action of a rule defaults to RedactMask.
+func NewRedactor(rules []RedactionRule) (*Redactor, error) {
+	r := &Redactor{}
+	for i, rule := range rules {
+		red := redaction{name: rule.Name, action: rule.Action}
+		if red.name == "" {
+			red.name = rule.Type
+		}
+		switch red.action {
+		case "":
+			red.action = RedactMask
+		case RedactMask, RedactHash, RedactDrop:
+		default:
+			return nil, fmt.Errorf("redaction rule %d: invalid action %q, expected \"mask\", \"hash\" or \"drop\"", i+1, rule.Action)
+		}
+		if rule.Type == "regex" {
+			if rule.Pattern == "" {
+				return nil, fmt.Errorf("redaction rule %d: missing pattern", i+1)
+			}
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule %d: %w", i+1, err)
+			}
+			red.detector = detector{pattern: pattern}
+		} else {
+			d, ok := detectors[rule.Type]
+			if !ok {
+				return nil, fmt.Errorf("redaction rule %d: unknown type %q", i+1, rule.Type)
+			}
+			if rule.Pattern != "" {
+				return nil, fmt.Errorf("redaction rule %d: a pattern requires the type \"regex\"", i+1)
+			}
+			red.detector = d
+		}
+		r.redactions = append(r.redactions, red)
+	}
+	return r, nil
+}
+
+// Redact returns the text with all values matched by the rules replaced
+func (r *Redactor) Redact(text string) string {
+	for _, red := range r.redactions {
+		text = red.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if red.valid != nil && !red.valid(match) {
+				return match
+			}
+			switch red.action {
+			case RedactHash:
+				sum := sha256.Sum256([]byte(match))
+				return "[" + red.name + ":" + hex.EncodeToString(sum[:6]) + "]"
+			case RedactDrop:
+				return ""
+			default:
+				return "[REDACTED:" + red.name + "]"
+			}
+		})
+	}
+	return text
+}
+
+// RedactMessages returns copies of the messages with the content, the arguments of tool calls and all strings of the
+// metadata redacted, e.g. the thinking, the request parameters or the original values of rewritten fields. The
+// content of tool messages contains the tool results. The messages passed in are never modified.
+func (r *Redactor) RedactMessages(messages []storage.SimpleMessage) []storage.SimpleMessage {
+	if r == nil || len(r.redactions) == 0 || messages == nil {
+		return messages
+	}
+	redacted := make([]storage.SimpleMessage, len(messages))
+	for i, msg := range messages {
+		msg.Content = r.Redact(msg.Content)
+		msg.ToolCalls = r.redactToolCalls(msg.ToolCalls)
+		if msg.Metadata != nil {
+			metadata := make(map[string]any, len(msg.Metadata))
+			for key, value := range msg.Metadata {
+				// Attachments contain binary data like base64 encoded images, which would only be corrupted
+				if key != "attachments" {
+					value = r.redactValue(value)
+				}
+				metadata[key] = value
+			}
+			msg.Metadata = metadata
+		}
+		redacted[i] = msg
+	}
+	return redacted
+}
+
+// redactValue returns a copy of a metadata value with all strings it contains redacted. Values of other types than
+// strings, maps, slices and tool calls are redacted in their JSON representation, which is how they are stored.
+func (r *Redactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case nil, bool, int, int64, float64, json.Number:
+		return v
+	case string:
+		return r.Redact(v)
+	case []storage.ToolCall:
+		return r.redactToolCalls(v)
+	case map[string]any:
+		redacted := make(map[string]any, len(v))
+		for key, element := range v {
+			redacted[key] = r.redactValue(element)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(v))
+		for i, element := range v {
+			redacted[i] = r.redactValue(element)
+		}
+		return redacted
+	case []string:
+		redacted := make([]string, len(v))
+		for i, element := range v {
+			redacted[i] = r.Redact(element)
+		}
+		return redacted
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return value
+	}
+	return r.redactValue(decoded)
+}
+
+// redactToolCalls returns a copy of the tool calls with their arguments redacted
+func (r *Redactor) redactToolCalls(calls []storage.ToolCall) []storage.ToolCall {
+	if calls == nil {
+		return nil
+	}
+	redacted := make([]storage.ToolCall, len(calls))
+	for i, call := range calls {
+		call.Function.Arguments = r.Redact(call.Function.Arguments)
+		redacted[i] = call
+	}
+	return redacted
+}
+
+// luhn validates the check digit of a credit card number, ignoring spaces and dashes
+func luhn(number string) bool {
+	sum, n := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return sum%10 == 0
+}
+
+// validIBAN validates the check digits of an IBAN using the ISO 7064 MOD 97-10 algorithm, ignoring spaces
+func validIBAN(iban string) bool {
+	iban = strings.ReplaceAll(iban, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	remainder := 0
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A'+10)) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
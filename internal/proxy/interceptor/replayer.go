@@ -0,0 +1,122 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"llm-monitor/internal/storage"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Replayer saves records to storage and falls back to a spool on disk if the storage fails. Spooled records are
+// replayed periodically in the order they have been spooled, once the storage is available again. As records are
+// saved by matching their history against the stored messages, replayed messages are added to the same branches
+// as if they had been saved right away.
+type Replayer struct {
+	store    storage.Storage
+	spool    *Spool
+	interval time.Duration
+	timeout  time.Duration
+
+	// replaying prevents the spool from being drained concurrently
+	replaying sync.Mutex
+	started   bool
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewReplayer creates a Replayer saving records to the given storage, using the spool as fallback.
+// Records are replayed every interval, limiting the time to save each record to the timeout.
+func NewReplayer(store storage.Storage, spool *Spool, interval, timeout time.Duration) *Replayer {
+	return &Replayer{
+		store:    store,
+		spool:    spool,
+		interval: interval,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Save saves a record to storage. If this fails, the record is spooled to be replayed later. An error is only
+// returned if the record could neither be saved nor spooled.
+func (r *Replayer) Save(ctx context.Context, record Record) error {
+	// A record must not overtake spooled records, which might belong to the same conversation. Otherwise, a replayed
+	// response would be stored on a new branch forked from the history of the later record.
+	if r.spool.Len() == 0 {
+		err := SaveRecord(ctx, r.store, record)
+		if err == nil {
+			return nil
+		}
+		logrus.WithError(err).Warnf("[%s] Could not save messages to storage, spooling them for replay", record.Interceptor)
+	}
+	if err := r.spool.Append(record); err != nil {
+		return fmt.Errorf("could not spool record: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the number of records waiting to be replayed
+func (r *Replayer) Pending() int {
+	return r.spool.Len()
+}
+
+// Replay saves the spooled records to storage in the order they have been spooled. It stops at the first record
+// which can't be saved, which is kept together with all following records for the next replay.
+// Returns the number of replayed records.
+func (r *Replayer) Replay(ctx context.Context) (int, error) {
+	r.replaying.Lock()
+	defer r.replaying.Unlock()
+
+	replayed := 0
+	err := r.spool.Drain(func(record Record) error {
+		saveCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+		if err := SaveRecord(saveCtx, r.store, record); err != nil {
+			return err
+		}
+		replayed++
+		return nil
+	})
+	return replayed, err
+}
+
+// Start replays the spooled records in the background until the Replayer is closed
+func (r *Replayer) Start() {
+	r.started = true
+	go func() {
+		defer close(r.stopped)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			// Replay records left over from a previous run right away
+			if r.spool.Len() > 0 {
+				replayed, err := r.Replay(context.Background())
+				if replayed > 0 {
+					logrus.WithField("pending", r.spool.Len()).Infof("Replayed %d spooled records to storage", replayed)
+				}
+				if err != nil {
+					logrus.WithError(err).WithField("pending", r.spool.Len()).Debug("Could not replay spooled records")
+				}
+			}
+			select {
+			case <-r.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops replaying records and closes the spool. Records which have not been replayed yet are kept on disk
+// and replayed after the next start.
+func (r *Replayer) Close() error {
+	close(r.done)
+	if r.started {
+		<-r.stopped
+	}
+	return r.spool.Close()
+}
@@ -0,0 +1,180 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"llm-monitor/internal/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// treeStorage stores messages by the keys of their histories to resolve the parents of saved records.
+// While unavailable is set, every call fails.
+type treeStorage struct {
+	storage.Storage
+	unavailable bool
+
+	ids     map[string]uuid.UUID
+	keys    map[uuid.UUID]string
+	parents map[string]string
+}
+
+func newTreeStorage() *treeStorage {
+	return &treeStorage{ids: map[string]uuid.UUID{}, keys: map[uuid.UUID]string{}, parents: map[string]string{}}
+}
+
+func historyKey(history []storage.SimpleMessage) string {
+	var parts []string
+	for _, m := range history {
+		parts = append(parts, m.Role+":"+m.Content)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *treeStorage) FindMessageByHistory(ctx context.Context, history []storage.SimpleMessage, requestType string) (uuid.UUID, error) {
+	if s.unavailable {
+		return uuid.Nil, errors.New("connection refused")
+	}
+	return s.ids[historyKey(history)], nil
+}
+
+func (s *treeStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*storage.Conversation, *storage.Branch, error) {
+	if s.unavailable {
+		return nil, nil, errors.New("connection refused")
+	}
+	return &storage.Conversation{ID: uuid.New()}, &storage.Branch{ID: uuid.New()}, nil
+}
+
+func (s *treeStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *storage.Message) (*storage.Message, error) {
+	if s.unavailable {
+		return nil, errors.New("connection refused")
+	}
+	key := message.Role + ":" + message.Content
+	if parent, ok := s.keys[parentMessageID]; ok {
+		key = parent + "/" + key
+	}
+	message.ID = uuid.New()
+	s.ids[key] = message.ID
+	s.keys[message.ID] = key
+	s.parents[key] = s.keys[parentMessageID]
+	return message, nil
+}
+
+func TestReplayer_SpoolsAndReplaysInOrder(t *testing.T) {
+	store := newTreeStorage()
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	r := NewReplayer(store, spool, time.Hour, time.Second)
+	ctx := context.Background()
+
+	first := Record{
+		RequestType: "chat",
+		StatusCode:  200,
+		History:     []storage.SimpleMessage{{Role: "user", Content: "u1"}},
+		Choices:     []storage.SimpleMessage{{Role: "assistant", Content: "a1"}},
+	}
+	second := Record{
+		RequestType: "chat",
+		StatusCode:  200,
+		History: []storage.SimpleMessage{
+			{Role: "user", Content: "u1"},
+			{Role: "assistant", Content: "a1"},
+			{Role: "user", Content: "u2"},
+		},
+		Choices: []storage.SimpleMessage{{Role: "assistant", Content: "a2"}},
+	}
+
+	store.unavailable = true
+	if err := r.Save(ctx, first); err != nil {
+		t.Fatalf("Failed to save first record: %v", err)
+	}
+	store.unavailable = false
+
+	// The storage is available again, but the second record must not overtake the spooled one
+	if err := r.Save(ctx, second); err != nil {
+		t.Fatalf("Failed to save second record: %v", err)
+	}
+	if r.Pending() != 2 || len(store.ids) != 0 {
+		t.Fatalf("Expected both records to be spooled, got %d pending and %d stored", r.Pending(), len(store.ids))
+	}
+
+	replayed, err := r.Replay(ctx)
+	if err != nil || replayed != 2 {
+		t.Fatalf("Expected 2 replayed records, got %d (%v)", replayed, err)
+	}
+	if r.Pending() != 0 {
+		t.Errorf("Expected empty spool, got %d pending", r.Pending())
+	}
+
+	// Every message has been stored once, on a single branch
+	expected := map[string]string{
+		"user:u1":                                   "",
+		"user:u1/assistant:a1":                      "user:u1",
+		"user:u1/assistant:a1/user:u2":              "user:u1/assistant:a1",
+		"user:u1/assistant:a1/user:u2/assistant:a2": "user:u1/assistant:a1/user:u2",
+	}
+	if len(store.parents) != len(expected) {
+		t.Errorf("Unexpected messages: %v", store.parents)
+	}
+	for key, parent := range expected {
+		if p, ok := store.parents[key]; !ok || p != parent {
+			t.Errorf("Expected parent %q of %q, got %q", parent, key, p)
+		}
+	}
+
+	// Records are saved directly once the spool is empty
+	if err := r.Save(ctx, Record{RequestType: "chat", History: []storage.SimpleMessage{{Role: "user", Content: "u3"}}}); err != nil {
+		t.Fatalf("Failed to save record: %v", err)
+	}
+	if r.Pending() != 0 || store.ids["user:u3"] == uuid.Nil {
+		t.Errorf("Expected record to be saved directly")
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Failed to close replayer: %v", err)
+	}
+}
+
+func TestReplayer_KeepsRecordsWhileUnavailable(t *testing.T) {
+	store := newTreeStorage()
+	store.unavailable = true
+	dir := t.TempDir()
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	r := NewReplayer(store, spool, time.Hour, time.Second)
+
+	for _, content := range []string{"a", "b"} {
+		record := Record{RequestType: "chat", History: []storage.SimpleMessage{{Role: "user", Content: content}}}
+		if err := r.Save(context.Background(), record); err != nil {
+			t.Fatalf("Failed to save record: %v", err)
+		}
+	}
+	if replayed, err := r.Replay(context.Background()); err == nil || replayed != 0 {
+		t.Errorf("Expected replay to fail, got %d replayed (%v)", replayed, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Failed to close replayer: %v", err)
+	}
+
+	// The records are replayed in the background after a restart
+	store.unavailable = false
+	spool, err = NewSpool(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen spool: %v", err)
+	}
+	r = NewReplayer(store, spool, time.Hour, time.Second)
+	r.Start()
+	waitFor(t, func() bool { return r.Pending() == 0 })
+	if err := r.Close(); err != nil {
+		t.Fatalf("Failed to close replayer: %v", err)
+	}
+	if store.ids["user:a"] == uuid.Nil || store.ids["user:b"] == uuid.Nil {
+		t.Errorf("Expected replayed records, got %v", store.ids)
+	}
+}
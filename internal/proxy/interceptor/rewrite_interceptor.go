@@ -0,0 +1,386 @@
+package interceptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"llm-monitor/internal/glob"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequestFormat is the shape of a request body which is rewritten
+type RequestFormat string
+
+const (
+	// FormatOpenAI is the format of the OpenAI API, where parameters are top level fields
+	FormatOpenAI RequestFormat = "openai"
+	// FormatOllama is the format of the Ollama API, where parameters are fields of "options"
+	FormatOllama RequestFormat = "ollama"
+)
+
+// System prompt modes of a RewritePolicy
+const (
+	// SystemPromptReplace replaces all system messages with the system prompt
+	SystemPromptReplace = "replace"
+	// SystemPromptPrepend inserts the system prompt before the existing system messages
+	SystemPromptPrepend = "prepend"
+	// SystemPromptDefault only adds the system prompt to requests without a system message
+	SystemPromptDefault = "default"
+)
+
+// RewritePolicy defines how requests are rewritten before they are forwarded. Zero values leave a request unchanged.
+type RewritePolicy struct {
+	// Format is the shape of the requests, which is derived from the path if it is empty: paths starting with
+	// "/api/" are Ollama requests, all others OpenAI requests.
+	Format RequestFormat
+	// Model forces the model of every request which isn't matched by Models
+	Model string
+	// Models overrides the requested models matching the glob patterns of the keys with the values
+	Models map[string]string
+	// SystemPrompt is injected into every request according to the SystemPromptMode, which defaults to
+	// SystemPromptReplace
+	SystemPrompt     string
+	SystemPromptMode string
+	// MaxTokens limits the number of generated tokens, also for requests which don't limit them
+	MaxTokens int
+	// MaxTemperature limits the temperature of requests which set a temperature
+	MaxTemperature *float64
+	// Options are the default parameters for requests which don't set them
+	Options map[string]any
+	// Strip lists the fields which are removed from requests, nested fields are separated by dots
+	Strip []string
+
+	// patterns are the compiled keys of Models sorted by the keys
+	patterns []modelPattern
+}
+
+// modelPattern is a compiled glob pattern of the Models of a policy with the model it is overridden with
+type modelPattern struct {
+	pattern *regexp.Regexp
+	target  string
+}
+
+// Validate checks the format and the system prompt mode of the policy
+func (p RewritePolicy) Validate() error {
+	switch p.Format {
+	case "", FormatOpenAI, FormatOllama:
+	default:
+		return fmt.Errorf("invalid format %q, expected \"openai\" or \"ollama\"", p.Format)
+	}
+	switch p.SystemPromptMode {
+	case "", SystemPromptReplace, SystemPromptPrepend, SystemPromptDefault:
+	default:
+		return fmt.Errorf("invalid system prompt mode %q, expected \"replace\", \"prepend\" or \"default\"", p.SystemPromptMode)
+	}
+	return nil
+}
+
+// compile compiles the glob patterns of the Models
+func (p *RewritePolicy) compile() {
+	keys := slices.Sorted(maps.Keys(p.Models))
+	p.patterns = make([]modelPattern, len(keys))
+	for i, key := range keys {
+		p.patterns[i] = modelPattern{pattern: glob.Compile(key), target: p.Models[key]}
+	}
+}
+
+// RewriteChange describes a change of a rewritten request. From is empty for added fields and To for removed fields.
+type RewriteChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from,omitzero"`
+	To    any    `json:"to,omitzero"`
+}
+
+// RewriteInterceptor rewrites the JSON body of requests according to a policy, e.g. to force a model or to limit the
+// number of generated tokens. The changes are recorded in the "rewrite" metadata of the exchange, so interceptors
+// saving the messages store them with the response. All other calls are passed to the Next interceptor if it is set.
+type RewriteInterceptor struct {
+	Name   string
+	Policy RewritePolicy
+	Next   Interceptor
+
+	// compiled makes sure the model patterns of the policy are only compiled once
+	compiled sync.Once
+}
+
+func (ri *RewriteInterceptor) CreateState() State {
+	if ri.Next != nil {
+		return ri.Next.CreateState()
+	}
+	return &EmptyState{}
+}
+
+// RequestInterceptor rewrites the request body and passes the request to the next interceptor
+func (ri *RewriteInterceptor) RequestInterceptor(req *http.Request, state State) error {
+	ri.compiled.Do(ri.Policy.compile)
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return err
+		}
+		format := ri.Policy.Format
+		if format == "" {
+			format = FormatOpenAI
+			if strings.HasPrefix(req.URL.Path, "/api/") {
+				format = FormatOllama
+			}
+		}
+		if rewritten, changes := ri.Policy.Rewrite(body, format); len(changes) > 0 {
+			body = rewritten
+			req.ContentLength = int64(len(body))
+			req.Header.Set("Content-Length", fmt.Sprint(len(body)))
+			ExchangeFromContext(req.Context()).SetMetadata("rewrite", changes)
+			logrus.WithField("changes", changes).Debugf("[%s] Rewrote request to %s", ri.Name, req.URL.Path)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if ri.Next != nil {
+		return ri.Next.RequestInterceptor(req, state)
+	}
+	return nil
+}
+
+func (ri *RewriteInterceptor) ResponseInterceptor(resp *http.Response, state State) error {
+	if ri.Next != nil {
+		return ri.Next.ResponseInterceptor(resp, state)
+	}
+	return nil
+}
+
+func (ri *RewriteInterceptor) ContentInterceptor(content []byte, state State) ([]byte, error) {
+	if ri.Next != nil {
+		return ri.Next.ContentInterceptor(content, state)
+	}
+	return content, nil
+}
+
+func (ri *RewriteInterceptor) ChunkInterceptor(chunk []byte, state State) ([]byte, error) {
+	if ri.Next != nil {
+		return ri.Next.ChunkInterceptor(chunk, state)
+	}
+	return chunk, nil
+}
+
+func (ri *RewriteInterceptor) OnComplete(state State) {
+	if ri.Next != nil {
+		ri.Next.OnComplete(state)
+	}
+}
+
+func (ri *RewriteInterceptor) OnError(state State, err error) {
+	if ri.Next != nil {
+		ri.Next.OnError(state, err)
+	}
+}
+
+// Rewrite applies the policy to a JSON request body of the given format and returns the rewritten body together with
+// the changes. Bodies which aren't JSON objects are returned unchanged.
+func (p RewritePolicy) Rewrite(body []byte, format RequestFormat) ([]byte, []RewriteChange) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var request map[string]any
+	if err := decoder.Decode(&request); err != nil || request == nil {
+		return body, nil
+	}
+
+	var changes []RewriteChange
+	set := func(fields map[string]any, prefix string, key string, value any) {
+		change := RewriteChange{Field: prefix + key, From: fields[key], To: value}
+		fields[key] = value
+		changes = append(changes, change)
+	}
+
+	for _, field := range p.Strip {
+		fields, key := lookup(request, field)
+		if value, ok := fields[key]; ok {
+			delete(fields, key)
+			changes = append(changes, RewriteChange{Field: field, From: value})
+		}
+	}
+
+	if model, ok := p.model(request["model"]); ok {
+		set(request, "", "model", model)
+	}
+
+	if p.SystemPrompt != "" {
+		changes = append(changes, p.rewriteSystemPrompt(request, format)...)
+	}
+
+	// Ollama requests set the parameters in the options, OpenAI requests as top level fields
+	parameters, prefix := request, ""
+	if format == FormatOllama {
+		options, ok := request["options"].(map[string]any)
+		if !ok && (p.MaxTokens > 0 || len(p.Options) > 0) {
+			options = make(map[string]any)
+			request["options"] = options
+		}
+		parameters, prefix = options, "options."
+	}
+
+	if p.MaxTokens > 0 {
+		keys := []string{"max_tokens", "max_completion_tokens"}
+		if format == FormatOllama {
+			keys = []string{"num_predict"}
+		}
+		limited := false
+		for _, key := range keys {
+			value, ok := parameters[key]
+			if !ok {
+				continue
+			}
+			limited = true
+			// Ollama generates tokens infinitely for negative values
+			if n, ok := number(value); !ok || n > float64(p.MaxTokens) || n < 0 {
+				set(parameters, prefix, key, p.MaxTokens)
+			}
+		}
+		if !limited {
+			set(parameters, prefix, keys[0], p.MaxTokens)
+		}
+	}
+
+	if p.MaxTemperature != nil {
+		if t, ok := number(parameters["temperature"]); ok && t > *p.MaxTemperature {
+			set(parameters, prefix, "temperature", *p.MaxTemperature)
+		}
+	}
+
+	keys := make([]string, 0, len(p.Options))
+	for key := range p.Options {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if _, ok := parameters[key]; !ok {
+			set(parameters, prefix, key, p.Options[key])
+		}
+	}
+
+	if len(changes) == 0 {
+		return body, nil
+	}
+	rewritten, err := json.Marshal(request)
+	if err != nil {
+		return body, nil
+	}
+	return rewritten, changes
+}
+
+// model returns the model a request should be sent to, if it differs from the requested model
+func (p RewritePolicy) model(requested any) (string, bool) {
+	name, _ := requested.(string)
+	target, ok := p.Models[name]
+	if !ok {
+		if p.patterns == nil {
+			// Policies which aren't used by an interceptor are compiled for every request
+			p.compile()
+		}
+		for _, pattern := range p.patterns {
+			if pattern.pattern.MatchString(name) {
+				target, ok = pattern.target, true
+				break
+			}
+		}
+	}
+	if !ok {
+		target = p.Model
+	}
+	return target, target != "" && target != name
+}
+
+// rewriteSystemPrompt injects the system prompt into the messages of a chat request, or into the "system" field of
+// an Ollama generate request
+func (p RewritePolicy) rewriteSystemPrompt(request map[string]any, format RequestFormat) []RewriteChange {
+	messages, ok := request["messages"].([]any)
+	if !ok {
+		if format != FormatOllama || request["prompt"] == nil {
+			return nil
+		}
+		system, _ := request["system"].(string)
+		prompt := p.SystemPrompt
+		switch p.SystemPromptMode {
+		case SystemPromptDefault:
+			if system != "" {
+				return nil
+			}
+		case SystemPromptPrepend:
+			if system != "" {
+				prompt += "\n\n" + system
+			}
+		}
+		if prompt == system {
+			return nil
+		}
+		request["system"] = prompt
+		return []RewriteChange{{Field: "system", From: system, To: prompt}}
+	}
+
+	var existing []string
+	var others []any
+	for _, m := range messages {
+		if msg, ok := m.(map[string]any); ok && msg["role"] == "system" {
+			content, _ := msg["content"].(string)
+			existing = append(existing, content)
+			if p.SystemPromptMode == SystemPromptReplace || p.SystemPromptMode == "" {
+				continue
+			}
+		}
+		others = append(others, m)
+	}
+	switch p.SystemPromptMode {
+	case SystemPromptDefault:
+		if len(existing) > 0 {
+			return nil
+		}
+	case SystemPromptPrepend:
+		if len(existing) > 0 && existing[0] == p.SystemPrompt {
+			return nil
+		}
+	default:
+		if len(existing) == 1 && existing[0] == p.SystemPrompt {
+			return nil
+		}
+	}
+	system := map[string]any{"role": "system", "content": p.SystemPrompt}
+	request["messages"] = append([]any{system}, others...)
+
+	change := RewriteChange{Field: "messages.system", To: p.SystemPrompt}
+	if len(existing) > 0 && p.SystemPromptMode != SystemPromptPrepend {
+		change.From = strings.Join(existing, "\n\n")
+	}
+	return []RewriteChange{change}
+}
+
+// lookup returns the object containing a field given by a dot separated path and the key of the field within it.
+// The object is nil if a parent of the field doesn't exist.
+func lookup(request map[string]any, field string) (map[string]any, string) {
+	fields := request
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		fields, _ = fields[part].(map[string]any)
+	}
+	return fields, parts[len(parts)-1]
+}
+
+// number returns the value of a JSON number
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
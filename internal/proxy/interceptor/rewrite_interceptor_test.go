@@ -0,0 +1,209 @@
+package interceptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// bodyInterceptor captures the request body and the exchange passed to it
+type bodyInterceptor struct {
+	SimpleInterceptor
+	body     map[string]any
+	exchange *Exchange
+}
+
+func (bi *bodyInterceptor) RequestInterceptor(req *http.Request, _ State) error {
+	body, _ := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	bi.exchange = ExchangeFromContext(req.Context())
+	return json.Unmarshal(body, &bi.body)
+}
+
+func rewriteRequest(t *testing.T, ri *RewriteInterceptor, path string, body string) *http.Request {
+	t.Helper()
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+	req = req.WithContext(WithExchange(req.Context(), NewExchange()))
+	if err := ri.RequestInterceptor(req, ri.CreateState()); err != nil {
+		t.Fatalf("RequestInterceptor failed: %v", err)
+	}
+	return req
+}
+
+func TestRewriteInterceptor_OpenAI(t *testing.T) {
+	maxTemperature := 1.0
+	next := &bodyInterceptor{}
+	ri := &RewriteInterceptor{
+		Name: "rewrite",
+		Policy: RewritePolicy{
+			Models:         map[string]string{"gpt-4*": "gpt-4o-mini"},
+			Model:          "llama3",
+			SystemPrompt:   "Be concise.",
+			MaxTokens:      256,
+			MaxTemperature: &maxTemperature,
+			Options:        map[string]any{"seed": 42, "top_p": 0.9},
+			Strip:          []string{"user", "metadata.team"},
+		},
+		Next: next,
+	}
+
+	req := rewriteRequest(t, ri, "/v1/chat/completions", `{
+		"model": "gpt-4-turbo",
+		"messages": [{"role": "system", "content": "Be verbose."}, {"role": "user", "content": "Hi"}],
+		"max_tokens": 4096,
+		"temperature": 1.8,
+		"top_p": 1,
+		"user": "alice",
+		"metadata": {"team": "research", "project": "x"}
+	}`)
+
+	expected := map[string]any{
+		"model": "gpt-4o-mini",
+		"messages": []any{
+			map[string]any{"role": "system", "content": "Be concise."},
+			map[string]any{"role": "user", "content": "Hi"},
+		},
+		"max_tokens":  float64(256),
+		"temperature": 1.0,
+		"top_p":       float64(1),
+		"seed":        float64(42),
+		"metadata":    map[string]any{"project": "x"},
+	}
+	if !reflect.DeepEqual(next.body, expected) {
+		t.Errorf("Unexpected rewritten body:\n%v\nexpected\n%v", next.body, expected)
+	}
+	if req.ContentLength <= 0 || req.Header.Get("Content-Length") == "" {
+		t.Errorf("Expected the content length of the rewritten body, got %d", req.ContentLength)
+	}
+
+	changes, ok := next.exchange.Metadata()["rewrite"].([]RewriteChange)
+	if !ok {
+		t.Fatalf("Expected the changes in the exchange metadata, got %v", next.exchange.Metadata())
+	}
+	fields := make([]string, len(changes))
+	for i, change := range changes {
+		fields[i] = change.Field
+	}
+	expectedFields := []string{"user", "metadata.team", "model", "messages.system", "max_tokens", "temperature", "seed"}
+	if !reflect.DeepEqual(fields, expectedFields) {
+		t.Errorf("Expected changes of %v, got %v", expectedFields, fields)
+	}
+	if changes[2].From != "gpt-4-turbo" || changes[2].To != "gpt-4o-mini" || changes[3].From != "Be verbose." {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+
+	// Models without a matching pattern are forced to the default model
+	rewriteRequest(t, ri, "/v1/chat/completions", `{"model": "mistral", "messages": []}`)
+	if next.body["model"] != "llama3" {
+		t.Errorf("Expected forced model, got %v", next.body["model"])
+	}
+}
+
+func TestRewriteInterceptor_Ollama(t *testing.T) {
+	maxTemperature := 0.5
+	next := &bodyInterceptor{}
+	ri := &RewriteInterceptor{
+		Policy: RewritePolicy{
+			SystemPrompt:     "You are a helpful assistant.",
+			SystemPromptMode: SystemPromptDefault,
+			MaxTokens:        128,
+			MaxTemperature:   &maxTemperature,
+			Options:          map[string]any{"num_ctx": 8192},
+		},
+		Next: next,
+	}
+
+	rewriteRequest(t, ri, "/api/chat", `{
+		"model": "llama3",
+		"messages": [{"role": "system", "content": "You are a pirate."}, {"role": "user", "content": "Hi"}],
+		"options": {"temperature": 0.9, "num_predict": -1}
+	}`)
+	expected := map[string]any{
+		"model": "llama3",
+		"messages": []any{
+			map[string]any{"role": "system", "content": "You are a pirate."},
+			map[string]any{"role": "user", "content": "Hi"},
+		},
+		"options": map[string]any{"temperature": 0.5, "num_predict": float64(128), "num_ctx": float64(8192)},
+	}
+	if !reflect.DeepEqual(next.body, expected) {
+		t.Errorf("Unexpected rewritten chat request:\n%v\nexpected\n%v", next.body, expected)
+	}
+
+	// Generate requests have the system prompt in a separate field
+	ri.Policy.SystemPromptMode = SystemPromptPrepend
+	rewriteRequest(t, ri, "/api/generate", `{"model": "llama3", "prompt": "Hi", "system": "Answer in French."}`)
+	if system := next.body["system"]; system != "You are a helpful assistant.\n\nAnswer in French." {
+		t.Errorf("Expected the system prompt to be prepended, got %q", system)
+	}
+	if options, _ := next.body["options"].(map[string]any); options["num_predict"] != float64(128) {
+		t.Errorf("Expected the number of tokens to be limited, got %v", next.body["options"])
+	}
+}
+
+func TestRewriteInterceptor_Unchanged(t *testing.T) {
+	next := &bodyInterceptor{}
+	ri := &RewriteInterceptor{Policy: RewritePolicy{Model: "llama3", SystemPrompt: "Be concise."}, Next: next}
+
+	body := `{"model":"llama3","messages":[{"role":"system","content":"Be concise."}],"seed":12345678901234567890}`
+	req, _ := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+	req = req.WithContext(WithExchange(req.Context(), NewExchange()))
+	if err := ri.RequestInterceptor(req, ri.CreateState()); err != nil {
+		t.Fatalf("RequestInterceptor failed: %v", err)
+	}
+	if _, ok := next.exchange.Metadata()["rewrite"]; ok {
+		t.Errorf("Expected no changes for a request matching the policy")
+	}
+
+	// Bodies which aren't JSON are passed through
+	req, _ = http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString("not json"))
+	if err := (&RewriteInterceptor{Policy: RewritePolicy{Model: "llama3"}}).RequestInterceptor(req, &EmptyState{}); err != nil {
+		t.Fatalf("RequestInterceptor failed: %v", err)
+	}
+	if forwarded, _ := io.ReadAll(req.Body); string(forwarded) != "not json" {
+		t.Errorf("Expected body to be unchanged, got %q", forwarded)
+	}
+
+	// Large numbers keep their precision when other fields are rewritten
+	rewritten, _ := RewritePolicy{Model: "mistral"}.Rewrite([]byte(body), FormatOpenAI)
+	if !bytes.Contains(rewritten, []byte(`"seed":12345678901234567890`)) {
+		t.Errorf("Expected seed to be preserved, got %s", rewritten)
+	}
+}
+
+func TestRewritePolicy_Validate(t *testing.T) {
+	for _, policy := range []RewritePolicy{
+		{Format: "anthropic"},
+		{SystemPromptMode: "append"},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", policy)
+		}
+	}
+	if err := (RewritePolicy{Format: FormatOllama, SystemPromptMode: SystemPromptDefault}).Validate(); err != nil {
+		t.Errorf("Expected a valid policy, got %v", err)
+	}
+}
+
+func TestRewritePolicy_ModelPatterns(t *testing.T) {
+	// Model patterns have the same syntax as the models of upstreams, so every pattern is valid
+	policy := RewritePolicy{Models: map[string]string{"meta-llama/*": "llama3", "gpt-[4]*": "gpt-4o", "gpt-4": "gpt-4o-mini"}}
+	if err := policy.Validate(); err != nil {
+		t.Errorf("Expected a valid policy, got %v", err)
+	}
+	ri := &RewriteInterceptor{Policy: policy, Next: &bodyInterceptor{}}
+	for requested, expected := range map[string]string{
+		"meta-llama/Llama-3-8B": "llama3",
+		"gpt-[4]-turbo":         "gpt-4o",
+		"gpt-4":                 "gpt-4o-mini",
+		"gpt-3":                 "gpt-3",
+	} {
+		rewriteRequest(t, ri, "/v1/chat/completions", `{"model": "`+requested+`", "messages": []}`)
+		if model := ri.Next.(*bodyInterceptor).body["model"]; model != expected {
+			t.Errorf("Expected %s to be rewritten to %s, got %v", requested, expected, model)
+		}
+	}
+}
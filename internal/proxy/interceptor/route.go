@@ -0,0 +1,169 @@
+package interceptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Route defines the requests handled by an interceptor. The pattern is a path whose segments are either literal,
+// a parameter "{name}" or "*" matching any single segment, or a final "{name...}" matching the remaining path,
+// which may be empty. The method "*" matches all methods.
+//
+// If StripPrefix is set, only paths starting with the prefix match, the pattern is matched against the path without
+// the prefix, and the request is forwarded upstream without it.
+type Route struct {
+	Pattern     string
+	Method      string
+	StripPrefix string
+}
+
+// RouteMatch is the result of matching a request against the registered routes
+type RouteMatch struct {
+	Interceptor Interceptor
+	Route       Route
+	// Params are the values of the parameters of the pattern
+	Params map[string]string
+	// Path is the path of the request without the stripped prefix
+	Path string
+}
+
+type segmentKind int
+
+// The kinds of segments, in increasing order of precedence
+const (
+	restSegment segmentKind = iota
+	paramSegment
+	literalSegment
+)
+
+// segment is a segment of a pattern, the value is the literal text or the name of the parameter
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+// route is a registered route with its parsed pattern
+type route struct {
+	Route
+	prefix   string
+	segments []segment
+	// prefixSegments is the number of leading segments of the prefix
+	prefixSegments int
+	interceptor    Interceptor
+}
+
+// newRoute parses the pattern and the prefix of a route
+func newRoute(r Route, interceptor Interceptor) (*route, error) {
+	if !strings.HasPrefix(r.Pattern, "/") {
+		return nil, fmt.Errorf("invalid pattern %q: must start with /", r.Pattern)
+	}
+	prefix := strings.TrimSuffix(r.StripPrefix, "/")
+	if prefix != "" && (!strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "{}*")) {
+		return nil, fmt.Errorf("invalid prefix %q: must be a literal path starting with /", r.StripPrefix)
+	}
+
+	var segments []segment
+	if prefix != "" {
+		// The literal segments of the prefix count for the precedence of the route
+		for _, part := range strings.Split(prefix[1:], "/") {
+			segments = append(segments, segment{kind: literalSegment, value: part})
+		}
+	}
+	names := make(map[string]bool)
+	parts := strings.Split(r.Pattern[1:], "/")
+	for i, part := range parts {
+		s := segment{kind: literalSegment, value: part}
+		switch {
+		case part == "*":
+			s = segment{kind: paramSegment}
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "...}"):
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("invalid pattern %q: %s must be the last segment", r.Pattern, part)
+			}
+			s = segment{kind: restSegment, value: part[1 : len(part)-4]}
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			s = segment{kind: paramSegment, value: part[1 : len(part)-1]}
+		case strings.ContainsAny(part, "{}"):
+			return nil, fmt.Errorf("invalid pattern %q: segment %q must be a literal or a parameter", r.Pattern, part)
+		}
+		if s.kind != literalSegment && s.value != "" {
+			if names[s.value] || strings.ContainsAny(s.value, "{}") {
+				return nil, fmt.Errorf("invalid pattern %q: invalid or duplicate parameter %q", r.Pattern, s.value)
+			}
+			names[s.value] = true
+		}
+		segments = append(segments, s)
+	}
+	return &route{Route: r, prefix: prefix, segments: segments, prefixSegments: len(segments) - len(parts), interceptor: interceptor}, nil
+}
+
+// match matches the path of a request against the route and returns the parameters and the path without the prefix
+func (r *route) match(path string) (map[string]string, string, bool) {
+	if r.prefix != "" {
+		rest, ok := strings.CutPrefix(path, r.prefix)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			return nil, "", false
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		path = rest
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, "", false
+	}
+
+	segments := r.segments[r.prefixSegments:]
+	parts := strings.Split(path[1:], "/")
+	params := make(map[string]string)
+	for i, s := range segments {
+		if s.kind == restSegment {
+			params[s.value] = strings.Join(parts[i:], "/")
+			return params, path, true
+		}
+		if i >= len(parts) {
+			return nil, "", false
+		}
+		switch s.kind {
+		case literalSegment:
+			if parts[i] != s.value {
+				return nil, "", false
+			}
+		case paramSegment:
+			if parts[i] == "" {
+				return nil, "", false
+			}
+			if s.value != "" {
+				params[s.value] = parts[i]
+			}
+		}
+	}
+	if len(parts) != len(segments) {
+		return nil, "", false
+	}
+	return params, path, true
+}
+
+// key identifies the routes which are combined in a chain
+func (r *route) key() string {
+	return r.prefix + " " + r.Pattern
+}
+
+// compareRoutes returns a positive number if a takes precedence over b, a negative number if b takes precedence over
+// a, and zero if both are equally specific. Segments are compared from left to right: a literal segment takes
+// precedence over a parameter or "*", which take precedence over a final "{name...}".
+func compareRoutes(a, b *route) int {
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(a.segments) && i >= len(b.segments):
+			return 0
+		case i >= len(a.segments):
+			// b can only match the same path with an empty remaining path
+			return 1
+		case i >= len(b.segments):
+			return -1
+		case a.segments[i].kind != b.segments[i].kind:
+			return int(a.segments[i].kind) - int(b.segments[i].kind)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package interceptor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManager_Match(t *testing.T) {
+	m := NewInterceptorManager()
+
+	exact := &MockInterceptor{}
+	azure := &MockInterceptor{}
+	wildcard := &MockInterceptor{}
+	catchAll := &MockInterceptor{}
+	prefixed := &MockInterceptor{}
+	routes := []struct {
+		route       Route
+		interceptor Interceptor
+	}{
+		{Route{Pattern: "/{path...}", Method: "*"}, catchAll},
+		{Route{Pattern: "/openai/deployments/*/embeddings", Method: "POST"}, wildcard},
+		{Route{Pattern: "/openai/deployments/{deployment}/chat/completions", Method: "POST"}, azure},
+		{Route{Pattern: "/openai/deployments/gpt-4o/chat/completions", Method: "POST"}, exact},
+		{Route{Pattern: "/v1/chat/completions", Method: "POST", StripPrefix: "/proxy/openai/"}, prefixed},
+	}
+	for _, r := range routes {
+		if err := m.RegisterRoute(r.route, r.interceptor); err != nil {
+			t.Fatalf("Failed to register %+v: %v", r.route, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		method   string
+		expected Interceptor
+		params   map[string]string
+		stripped string
+	}{
+		{"Literal before parameter", "/openai/deployments/gpt-4o/chat/completions", "POST", exact, map[string]string{}, "/openai/deployments/gpt-4o/chat/completions"},
+		{"Parameter", "/openai/deployments/my-gpt/chat/completions", "POST", azure, map[string]string{"deployment": "my-gpt"}, "/openai/deployments/my-gpt/chat/completions"},
+		{"Wildcard", "/openai/deployments/ada/embeddings", "POST", wildcard, map[string]string{}, "/openai/deployments/ada/embeddings"},
+		{"Method falls back to catch-all", "/openai/deployments/my-gpt/chat/completions", "GET", catchAll, map[string]string{"path": "openai/deployments/my-gpt/chat/completions"}, "/openai/deployments/my-gpt/chat/completions"},
+		{"Empty parameter", "/openai/deployments//chat/completions", "POST", catchAll, map[string]string{"path": "openai/deployments//chat/completions"}, "/openai/deployments//chat/completions"},
+		{"Stripped prefix", "/proxy/openai/v1/chat/completions", "POST", prefixed, map[string]string{}, "/v1/chat/completions"},
+		{"Prefix without segment boundary", "/proxy/openaiv1/chat/completions", "POST", catchAll, map[string]string{"path": "proxy/openaiv1/chat/completions"}, "/proxy/openaiv1/chat/completions"},
+		{"Missing prefix", "/v1/chat/completions", "POST", catchAll, map[string]string{"path": "v1/chat/completions"}, "/v1/chat/completions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := m.Match(tt.path, tt.method)
+			if !ok || match.Interceptor != tt.expected {
+				t.Fatalf("Match() = %+v, expected interceptor %p", match, tt.expected)
+			}
+			if !reflect.DeepEqual(match.Params, tt.params) || match.Path != tt.stripped {
+				t.Errorf("Expected params %v and path %s, got %v and %s", tt.params, tt.stripped, match.Params, match.Path)
+			}
+		})
+	}
+}
+
+func TestManager_Match_RestMayBeEmpty(t *testing.T) {
+	m := NewInterceptorManager()
+	rest := &MockInterceptor{}
+	exact := &MockInterceptor{}
+	m.RegisterInterceptor("/api/{rest...}", "*", rest)
+	m.RegisterInterceptor("/api", "*", exact)
+
+	if got := m.GetInterceptor("/api", "GET"); got != exact {
+		t.Errorf("Expected the pattern without remaining path to take precedence, got %v", got)
+	}
+	match, _ := m.Match("/api/", "GET")
+	if match.Interceptor != rest || match.Params["rest"] != "" {
+		t.Errorf("Expected an empty remaining path, got %+v", match)
+	}
+	if got := m.GetInterceptor("/other", "GET"); got != nil {
+		t.Errorf("Expected no interceptor, got %v", got)
+	}
+}
+
+func TestManager_RegisterRoute_Invalid(t *testing.T) {
+	m := NewInterceptorManager()
+	for _, r := range []Route{
+		{Pattern: "v1/chat/completions"},
+		{Pattern: "/v1/{path...}/completions"},
+		{Pattern: "/v1/{model}/{model}"},
+		{Pattern: "/v1/chat-{model}"},
+		{Pattern: "/v1/chat", StripPrefix: "openai"},
+		{Pattern: "/v1/chat", StripPrefix: "/{tenant}"},
+	} {
+		if err := m.RegisterRoute(r, &MockInterceptor{}); err == nil {
+			t.Errorf("Expected an error for %+v", r)
+		}
+	}
+}
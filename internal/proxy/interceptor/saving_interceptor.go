@@ -2,6 +2,8 @@ package interceptor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"llm-monitor/internal/storage"
 	"time"
 
@@ -9,18 +11,76 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// SavingInterceptor is a base struct for interceptors that save messages to storage
+// SavingInterceptor is a base struct for interceptors that save messages to storage.
+// If a Writer is set, messages are saved asynchronously in the background, otherwise they are saved
+// synchronously within the request. If a Replayer is set, messages which can't be saved are spooled to disk
+// and replayed once the storage is available again. If a Redactor is set, sensitive values are redacted from the
+// saved messages, while the requests and responses passed through the proxy are left untouched.
 type SavingInterceptor struct {
-	Name    string
-	Storage storage.Storage
-	Timeout time.Duration
+	Name     string
+	Storage  storage.Storage
+	Timeout  time.Duration
+	Writer   *StorageWriter
+	Replayer *Replayer
+	Redactor *Redactor
+}
+
+// Record contains the history and the assistant responses of a single exchange which are saved together
+type Record struct {
+	Interceptor string                  `json:"interceptor"`
+	RequestType string                  `json:"request_type"`
+	StatusCode  int                     `json:"status_code,omitzero"`
+	History     []storage.SimpleMessage `json:"history"`
+	Choices     []storage.SimpleMessage `json:"choices,omitzero"`
 }
 
 // SaveToStorage saves the conversation history and assistant message to storage
 func (si *SavingInterceptor) SaveToStorage(ctx context.Context, history []storage.SimpleMessage, assistantMsg storage.SimpleMessage, statusCode int, requestType string) {
+	si.SaveChoicesToStorage(ctx, history, []storage.SimpleMessage{assistantMsg}, statusCode, requestType)
+}
+
+// SaveChoicesToStorage saves the conversation history and one or more alternative assistant messages to storage.
+// All choices are added as children of the last history message, so every choice after the first one is stored
+// on its own branch forked from the history. Without a storage, the record is only reported to the exchange of the
+// request.
+func (si *SavingInterceptor) SaveChoicesToStorage(ctx context.Context, history []storage.SimpleMessage, choices []storage.SimpleMessage, statusCode int, requestType string) {
+	record := Record{
+		Interceptor: si.Name,
+		RequestType: requestType,
+		StatusCode:  statusCode,
+		History:     si.Redactor.RedactMessages(history),
+		Choices:     si.Redactor.RedactMessages(choices),
+	}
+	// Report the record to the proxy if the context carries the exchange of the request, even if it isn't saved
+	exchange := ExchangeFromContext(ctx)
 	if si.Storage == nil {
+		exchange.SetRecord(record, nil)
 		return
 	}
+	if si.Writer != nil {
+		si.Writer.Enqueue(record)
+		exchange.SetRecord(record, nil)
+		return
+	}
+	if si.Replayer != nil {
+		err := si.Replayer.Save(ctx, record)
+		if err != nil {
+			logrus.WithError(err).Errorf("[%s] Could not save messages to storage", si.Name)
+		}
+		exchange.SetRecord(record, err)
+		return
+	}
+	err := SaveRecord(ctx, si.Storage, record)
+	if err != nil {
+		logrus.WithError(err).Warnf("[%s] Could not save messages to storage", si.Name)
+	}
+	exchange.SetRecord(record, err)
+}
+
+// SaveRecord saves the history and the assistant responses of a record to storage. Messages of the history which
+// have already been stored are matched by their hashes and only missing messages are added.
+func SaveRecord(ctx context.Context, store storage.Storage, record Record) error {
+	history := record.History
 
 	// 2. Try to find the deepest matching message ID
 	var currentParentID uuid.UUID
@@ -28,10 +88,9 @@ func (si *SavingInterceptor) SaveToStorage(ctx context.Context, history []storag
 
 	var curHistory = history
 	for len(curHistory) > 0 {
-		pid, err := si.Storage.FindMessageByHistory(ctx, curHistory, requestType)
+		pid, err := store.FindMessageByHistory(ctx, curHistory, record.RequestType)
 		if err != nil {
-			logrus.WithError(err).Warnf("[%s] Could not find message by history", si.Name)
-			return
+			return fmt.Errorf("could not find message by history: %w", err)
 		}
 		if pid != uuid.Nil {
 			// Do NOT create a new branch if the common messages actually is ONLY the first message AND its role is "system".
@@ -58,39 +117,48 @@ func (si *SavingInterceptor) SaveToStorage(ctx context.Context, history []storag
 		model := ""
 		if len(history) > 0 {
 			model = history[0].Model
-		} else if assistantMsg.Model != "" {
-			model = assistantMsg.Model
+		} else if len(record.Choices) > 0 {
+			model = record.Choices[0].Model
 		}
-		_, branch, err := si.Storage.CreateConversation(ctx, map[string]any{"model": model}, requestType)
+		_, branch, err := store.CreateConversation(ctx, map[string]any{"model": model}, record.RequestType)
 		if err != nil {
-			logrus.WithError(err).Warnf("[%s] Could not create conversation in storage", si.Name)
-			return
+			return fmt.Errorf("could not create conversation: %w", err)
 		}
 		currentBranchID = branch.ID
 	}
 
 	// 3. Add missing messages from history
 	for i, m := range history[len(curHistory):] {
-		msg, err := si.Storage.AddMessage(ctx, currentParentID, &storage.Message{
+		msg, err := store.AddMessage(ctx, currentParentID, &storage.Message{
 			SimpleMessage: m,
 			BranchID:      currentBranchID,
 		})
 		if err != nil {
-			logrus.WithError(err).Warnf("[%s] Could not add history message %d to storage", si.Name, i)
-			return
+			return fmt.Errorf("could not add history message %d: %w", i, err)
 		}
 		currentParentID = msg.ID
 		currentBranchID = uuid.Nil // Only need it for the first message if no parent
 	}
 
-	// 4. Add the assistant response
-	if assistantMsg.Content != "" || len(assistantMsg.ToolCalls) > 0 || statusCode != 0 {
-		_, err := si.Storage.AddMessage(ctx, currentParentID, &storage.Message{
+	// 4. Add the assistant responses
+	var errs []error
+	for i, assistantMsg := range record.Choices {
+		if !record.hasResponse(assistantMsg) {
+			continue
+		}
+		_, err := store.AddMessage(ctx, currentParentID, &storage.Message{
 			SimpleMessage:      assistantMsg,
-			UpstreamStatusCode: statusCode,
+			UpstreamStatusCode: record.StatusCode,
 		})
 		if err != nil {
-			logrus.WithError(err).Warnf("[%s] Could not add assistant message to storage", si.Name)
+			errs = append(errs, fmt.Errorf("could not add assistant message %d: %w", i, err))
 		}
 	}
+	return errors.Join(errs...)
+}
+
+// hasResponse reports whether an assistant message of the record needs to be saved. Messages without content
+// are only saved once a status code has been received from the upstream.
+func (r *Record) hasResponse(assistantMsg storage.SimpleMessage) bool {
+	return assistantMsg.Content != "" || len(assistantMsg.ToolCalls) > 0 || r.StatusCode != 0
 }
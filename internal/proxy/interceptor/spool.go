@@ -0,0 +1,242 @@
+package interceptor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// spoolSegmentSize is the size in bytes after which a new segment file is started
+const spoolSegmentSize = 4 << 20
+
+// Spool stores records in a directory of segmented JSONL files. Records are appended to the newest segment and
+// drained in the order they have been appended, oldest segment first. Every record is synced to disk before
+// Append returns, so spooled records survive a crash of the process.
+type Spool struct {
+	dir string
+
+	mu      sync.Mutex
+	current *os.File
+	size    int64
+	next    int
+	pending int
+}
+
+// NewSpool opens the spool in the given directory, which is created if it does not exist. Records which have been
+// spooled before are kept and drained first.
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create spool directory: %w", err)
+	}
+	s := &Spool{dir: dir}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(segment), "%d.jsonl", &n); err == nil && n >= s.next {
+			s.next = n + 1
+		}
+		count, err := countLines(segment)
+		if err != nil {
+			return nil, err
+		}
+		s.pending += count
+	}
+	return s, nil
+}
+
+// Append writes a record to the newest segment of the spool
+func (s *Spool) Append(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil || s.size >= spoolSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.current.Write(line)
+	s.size += int64(n)
+	if err == nil {
+		err = s.current.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("could not write to spool: %w", err)
+	}
+	s.pending++
+	return nil
+}
+
+// Len returns the number of records in the spool
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Drain passes all spooled records to fn in the order they have been appended. Records are removed from the
+// spool once fn returns successfully. If fn fails, draining stops and the failed record and all records after
+// it are kept for the next call.
+func (s *Spool) Drain(fn func(Record) error) error {
+	// Start a new segment, so the drained segments are not modified while they are read
+	s.mu.Lock()
+	if s.current != nil {
+		if err := s.closeCurrent(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if err := s.drainSegment(segment, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the current segment
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	return s.closeCurrent()
+}
+
+// drainSegment passes the records of a segment to fn and removes the segment afterward. If fn fails, the remaining
+// records are written back to the segment.
+func (s *Spool) drainSegment(segment string, fn func(Record) error) error {
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return fmt.Errorf("could not read spool segment: %w", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// A partially written record, e.g. after a crash, can't be recovered
+			s.consume(1)
+			continue
+		}
+		if err := fn(record); err != nil {
+			if writeErr := writeFileAtomic(segment, []byte(strings.Join(lines[i:], ""))); writeErr != nil {
+				return errors.Join(err, writeErr)
+			}
+			return err
+		}
+		s.consume(1)
+	}
+	if err := os.Remove(segment); err != nil {
+		return fmt.Errorf("could not remove spool segment: %w", err)
+	}
+	return nil
+}
+
+func (s *Spool) consume(n int) {
+	s.mu.Lock()
+	s.pending -= n
+	s.mu.Unlock()
+}
+
+// segments returns the paths of all segments ordered from oldest to newest, excluding the current segment
+func (s *Spool) segments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	if s.current != nil {
+		segments = slices.DeleteFunc(segments, func(segment string) bool { return segment == s.current.Name() })
+	}
+	// Segment names are zero padded, so they sort by their sequence number
+	slices.Sort(segments)
+	return segments, nil
+}
+
+func (s *Spool) rotate() error {
+	if s.current != nil {
+		if err := s.closeCurrent(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("%020d.jsonl", s.next)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not create spool segment: %w", err)
+	}
+	s.next++
+	s.current = f
+	s.size = 0
+	return nil
+}
+
+func (s *Spool) closeCurrent() error {
+	err := s.current.Close()
+	s.current = nil
+	s.size = 0
+	return err
+}
+
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// writeFileAtomic replaces the content of a file by writing a temporary file and renaming it
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
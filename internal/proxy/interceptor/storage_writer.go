@@ -0,0 +1,421 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"llm-monitor/internal/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OverflowPolicy defines what happens to records which are enqueued while the queue of a StorageWriter is full
+type OverflowPolicy string
+
+const (
+	// OverflowDrop discards the record
+	OverflowDrop OverflowPolicy = "drop"
+	// OverflowBlock waits until the queue has space again, at most for the BlockTimeout
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowSpill writes the record to a spool on disk, which is drained into the queue once it has space again
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// WriterOptions configures a StorageWriter
+type WriterOptions struct {
+	// QueueSize is the total number of records which can be queued, split evenly between the workers
+	QueueSize int
+	// Workers is the number of goroutines writing to storage
+	Workers int
+	// BatchSize is the maximum number of records a worker takes from its queue at once
+	BatchSize int
+	// FlushInterval is the time a worker waits for a batch to fill up before writing it
+	FlushInterval time.Duration
+	// Timeout limits the time to save a single record
+	Timeout time.Duration
+	// Overflow is the policy for records which are enqueued while the queue is full
+	Overflow OverflowPolicy
+	// BlockTimeout limits the time to wait for space in the queue with OverflowBlock. Zero waits indefinitely.
+	BlockTimeout time.Duration
+	// SpillDir is the directory of the spool used by OverflowSpill
+	SpillDir string
+	// Replayer saves the records if it is set, spooling records which can't be saved to storage
+	Replayer *Replayer
+}
+
+// WriterStats contains counters describing the throughput and the backpressure of a StorageWriter
+type WriterStats struct {
+	Enqueued      uint64
+	Written       uint64
+	Failed        uint64
+	Dropped       uint64
+	Spilled       uint64
+	Coalesced     uint64
+	Blocked       uint64
+	BlockedTime   time.Duration
+	Batches       uint64
+	QueueLength   int
+	QueueCapacity int
+	SpoolLength   int
+}
+
+// StorageWriter saves records asynchronously using a bounded queue and a pool of workers, which decouples
+// the proxied requests from the latency of the storage backend. Records are assigned to workers by the first
+// message of their history, so records of the same conversation are saved in the order they were enqueued.
+type StorageWriter struct {
+	store   storage.Storage
+	options WriterOptions
+	queues  []chan Record
+	spool   *Spool
+
+	// mu guards the queues against being closed while records are enqueued
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+	// drained is closed once spooled records are no longer moved into the queues
+	drained chan struct{}
+
+	enqueued, written, failed, dropped, spilled, coalesced, blocked, batches atomic.Uint64
+	blockedTime                                                              atomic.Int64
+}
+
+// NewStorageWriter creates a StorageWriter and starts its workers
+func NewStorageWriter(store storage.Storage, options WriterOptions) (*StorageWriter, error) {
+	options.Workers = max(options.Workers, 1)
+	options.QueueSize = max(options.QueueSize, options.Workers)
+	options.BatchSize = max(options.BatchSize, 1)
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	if options.Overflow == "" {
+		options.Overflow = OverflowDrop
+	}
+
+	w := &StorageWriter{
+		store:   store,
+		options: options,
+		queues:  make([]chan Record, options.Workers),
+		done:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+	switch options.Overflow {
+	case OverflowDrop, OverflowBlock:
+	case OverflowSpill:
+		if options.SpillDir == "" {
+			return nil, errors.New("a spill directory is required for the spill overflow policy")
+		}
+		spool, err := NewSpool(options.SpillDir)
+		if err != nil {
+			return nil, err
+		}
+		w.spool = spool
+	default:
+		return nil, errors.New("invalid overflow policy: " + string(options.Overflow))
+	}
+
+	for i := range w.queues {
+		w.queues[i] = make(chan Record, options.QueueSize/options.Workers)
+		w.wg.Add(1)
+		go w.work(w.queues[i])
+	}
+	if w.spool != nil {
+		go w.drainSpool()
+	} else {
+		close(w.drained)
+	}
+	return w, nil
+}
+
+// Enqueue queues a record to be saved. If the queue is full, the record is handled according to the
+// overflow policy.
+func (w *StorageWriter) Enqueue(record Record) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop(record, "storage writer is closed")
+		return
+	}
+	w.enqueued.Add(1)
+
+	// Keep the order of records while spooled records are waiting to be queued
+	if w.spool != nil && w.spool.Len() > 0 {
+		w.spill(record)
+		return
+	}
+
+	queue := w.queue(record)
+	select {
+	case queue <- record:
+		return
+	default:
+	}
+
+	switch w.options.Overflow {
+	case OverflowBlock:
+		w.block(queue, record)
+	case OverflowSpill:
+		w.spill(record)
+	default:
+		w.drop(record, "storage queue is full")
+	}
+}
+
+// Stats returns the current counters of the writer
+func (w *StorageWriter) Stats() WriterStats {
+	stats := WriterStats{
+		Enqueued:      w.enqueued.Load(),
+		Written:       w.written.Load(),
+		Failed:        w.failed.Load(),
+		Dropped:       w.dropped.Load(),
+		Spilled:       w.spilled.Load(),
+		Coalesced:     w.coalesced.Load(),
+		Blocked:       w.blocked.Load(),
+		BlockedTime:   time.Duration(w.blockedTime.Load()),
+		Batches:       w.batches.Load(),
+		QueueCapacity: w.options.QueueSize / w.options.Workers * w.options.Workers,
+	}
+	for _, queue := range w.queues {
+		stats.QueueLength += len(queue)
+	}
+	if w.spool != nil {
+		stats.SpoolLength = w.spool.Len()
+	}
+	return stats
+}
+
+// Close stops accepting records and waits until all queued records have been saved or the context is done
+func (w *StorageWriter) Close(ctx context.Context) error {
+	w.once.Do(func() {
+		// Release blocked producers before waiting for them to leave Enqueue
+		close(w.done)
+		<-w.drained
+		w.mu.Lock()
+		w.closed = true
+		for _, queue := range w.queues {
+			close(queue)
+		}
+		w.mu.Unlock()
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		if w.spool != nil {
+			if err := w.spool.Close(); err != nil {
+				logrus.WithError(err).Warn("Could not close storage writer spool")
+			}
+		}
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		stats := w.Stats()
+		logrus.WithFields(logrus.Fields{
+			"written": stats.Written,
+			"failed":  stats.Failed,
+			"dropped": stats.Dropped,
+			"spooled": stats.SpoolLength,
+		}).Info("Closed storage writer")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queue returns the queue of the worker responsible for the conversation of the record
+func (w *StorageWriter) queue(record Record) chan Record {
+	if len(w.queues) == 1 || len(record.History) == 0 {
+		return w.queues[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(record.RequestType))
+	_, _ = h.Write([]byte(record.History[0].Role))
+	_, _ = h.Write([]byte(record.History[0].Content))
+	return w.queues[h.Sum32()%uint32(len(w.queues))]
+}
+
+func (w *StorageWriter) block(queue chan Record, record Record) {
+	w.blocked.Add(1)
+	start := time.Now()
+	defer func() {
+		w.blockedTime.Add(int64(time.Since(start)))
+	}()
+
+	var timeout <-chan time.Time
+	if w.options.BlockTimeout > 0 {
+		timer := time.NewTimer(w.options.BlockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case queue <- record:
+	case <-timeout:
+		w.drop(record, "storage queue is still full after the block timeout")
+	case <-w.done:
+		w.drop(record, "storage writer is closed")
+	}
+}
+
+func (w *StorageWriter) spill(record Record) {
+	if err := w.spool.Append(record); err != nil {
+		logrus.WithError(err).Warnf("[%s] Could not spill record to disk", record.Interceptor)
+		w.drop(record, "spilling to disk failed")
+		return
+	}
+	w.spilled.Add(1)
+}
+
+// drop discards a record which can't be queued for the given reason
+func (w *StorageWriter) drop(record Record, reason string) {
+	// Log the first drop and every hundredth after it to avoid flooding the log
+	if dropped := w.dropped.Add(1); dropped%100 == 1 {
+		logrus.Warnf("[%s] Dropped record, %s (%d dropped in total)", record.Interceptor, reason, dropped)
+	}
+}
+
+// work saves the records of a queue in batches until the queue is closed
+func (w *StorageWriter) work(queue chan Record) {
+	defer w.wg.Done()
+
+	for {
+		record, ok := <-queue
+		if !ok {
+			return
+		}
+		batch := append(make([]Record, 0, w.options.BatchSize), record)
+		batch, ok = w.fill(queue, batch)
+		w.save(batch)
+		if !ok {
+			return
+		}
+	}
+}
+
+// fill takes further records from the queue until the batch is full or the flush interval has passed. It returns
+// false if the queue has been closed.
+func (w *StorageWriter) fill(queue chan Record, batch []Record) ([]Record, bool) {
+	var timeout <-chan time.Time
+	if w.options.FlushInterval > 0 {
+		timer := time.NewTimer(w.options.FlushInterval)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < w.options.BatchSize {
+		if timeout == nil {
+			// Only take records which are already queued
+			select {
+			case record, ok := <-queue:
+				if !ok {
+					return batch, false
+				}
+				batch = append(batch, record)
+				continue
+			default:
+				return batch, true
+			}
+		}
+		select {
+		case record, ok := <-queue:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, record)
+		case <-timeout:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// save writes a batch of records to storage, skipping records which are superseded by a later record
+func (w *StorageWriter) save(batch []Record) {
+	w.batches.Add(1)
+	for i, record := range batch {
+		if superseded(record, batch[i+1:]) {
+			w.coalesced.Add(1)
+			continue
+		}
+
+		if err := w.saveRecord(record); err != nil {
+			w.failed.Add(1)
+			logrus.WithError(err).Warnf("[%s] Could not save messages to storage", record.Interceptor)
+			continue
+		}
+		w.written.Add(1)
+	}
+}
+
+func (w *StorageWriter) saveRecord(record Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.options.Timeout)
+	defer cancel()
+	if w.options.Replayer != nil {
+		return w.options.Replayer.Save(ctx, record)
+	}
+	return SaveRecord(ctx, w.store, record)
+}
+
+// drainSpool moves spooled records into the queues whenever they have space again
+func (w *StorageWriter) drainSpool() {
+	defer close(w.drained)
+
+	ticker := time.NewTicker(max(w.options.FlushInterval, 100*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			// Remaining records stay in the spool and are drained after the next start
+			return
+		case <-ticker.C:
+		}
+		if w.spool.Len() == 0 {
+			continue
+		}
+		err := w.spool.Drain(func(record Record) error {
+			select {
+			case w.queue(record) <- record:
+				return nil
+			case <-w.done:
+				return errors.New("storage writer is closed")
+			}
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// superseded reports whether a record only contains messages which are saved by one of the later records as well.
+// This is the case for a request which failed without a response, e.g. because the client disconnected, and which
+// is followed by a retry with the same history and its response.
+func superseded(record Record, later []Record) bool {
+	for _, choice := range record.Choices {
+		if record.hasResponse(choice) {
+			return false
+		}
+	}
+	for _, other := range later {
+		if other.RequestType == record.RequestType && isPrefix(record.History, other.History) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrefix reports whether the messages of prefix are the first messages of history
+func isPrefix(prefix, history []storage.SimpleMessage) bool {
+	if len(prefix) > len(history) {
+		return false
+	}
+	for i, m := range prefix {
+		if m.Role != history[i].Role || m.Content != history[i].Content {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package interceptor
+
+import (
+	"context"
+	"llm-monitor/internal/storage"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// writerStorage records the content of added messages. If gate is set, every write waits for a value from it.
+type writerStorage struct {
+	storage.Storage
+	gate chan struct{}
+
+	mu       sync.Mutex
+	contents []string
+}
+
+func (s *writerStorage) FindMessageByHistory(ctx context.Context, history []storage.SimpleMessage, requestType string) (uuid.UUID, error) {
+	return uuid.Nil, nil
+}
+
+func (s *writerStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*storage.Conversation, *storage.Branch, error) {
+	return &storage.Conversation{ID: uuid.New()}, &storage.Branch{ID: uuid.New()}, nil
+}
+
+func (s *writerStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *storage.Message) (*storage.Message, error) {
+	if s.gate != nil {
+		select {
+		case <-s.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contents = append(s.contents, message.Content)
+	message.ID = uuid.New()
+	return message, nil
+}
+
+func (s *writerStorage) Contents() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.contents...)
+}
+
+func writerRecord(content string, response string, statusCode int) Record {
+	return Record{
+		Interceptor: "test",
+		RequestType: "chat",
+		StatusCode:  statusCode,
+		History:     []storage.SimpleMessage{{Role: "user", Content: content}},
+		Choices:     []storage.SimpleMessage{{Role: "assistant", Content: response}},
+	}
+}
+
+func closeWriter(t *testing.T, w *StorageWriter) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+}
+
+func TestStorageWriter_WritesAsynchronously(t *testing.T) {
+	store := &writerStorage{}
+	w, err := NewStorageWriter(store, WriterOptions{QueueSize: 10, Workers: 2, BatchSize: 4})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	w.Enqueue(writerRecord("a", "1", 200))
+	w.Enqueue(writerRecord("b", "2", 200))
+	closeWriter(t, w)
+
+	stats := w.Stats()
+	if stats.Enqueued != 2 || stats.Written != 2 || stats.Failed != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if contents := store.Contents(); len(contents) != 4 {
+		t.Errorf("Expected 4 messages, got %v", contents)
+	}
+}
+
+func TestStorageWriter_CoalescesHistoryRecords(t *testing.T) {
+	store := &writerStorage{}
+	w, err := NewStorageWriter(store, WriterOptions{QueueSize: 10, BatchSize: 10, FlushInterval: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	// The history of a failed request is superseded by the record of the retry containing the response
+	w.Enqueue(writerRecord("question", "", 0))
+	w.Enqueue(writerRecord("question", "answer", 200))
+	closeWriter(t, w)
+
+	if stats := w.Stats(); stats.Coalesced != 1 || stats.Written != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if contents := store.Contents(); !reflect.DeepEqual(contents, []string{"question", "answer"}) {
+		t.Errorf("Unexpected messages: %v", contents)
+	}
+}
+
+func TestStorageWriter_OverflowDrop(t *testing.T) {
+	store := &writerStorage{gate: make(chan struct{})}
+	w, err := NewStorageWriter(store, WriterOptions{QueueSize: 1, Overflow: OverflowDrop})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	// The first record is taken by the blocked worker, the second one fills the queue
+	w.Enqueue(writerRecord("a", "1", 200))
+	waitFor(t, func() bool { return w.Stats().QueueLength == 0 })
+	w.Enqueue(writerRecord("b", "2", 200))
+	w.Enqueue(writerRecord("c", "3", 200))
+
+	if stats := w.Stats(); stats.Dropped != 1 || stats.QueueLength != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	close(store.gate)
+	closeWriter(t, w)
+
+	if contents := store.Contents(); !reflect.DeepEqual(contents, []string{"a", "1", "b", "2"}) {
+		t.Errorf("Unexpected messages: %v", contents)
+	}
+}
+
+func TestStorageWriter_OverflowBlockTimeout(t *testing.T) {
+	store := &writerStorage{gate: make(chan struct{})}
+	w, err := NewStorageWriter(store, WriterOptions{QueueSize: 1, Overflow: OverflowBlock, BlockTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	w.Enqueue(writerRecord("a", "1", 200))
+	waitFor(t, func() bool { return w.Stats().QueueLength == 0 })
+	w.Enqueue(writerRecord("b", "2", 200))
+
+	start := time.Now()
+	w.Enqueue(writerRecord("c", "3", 200))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected enqueue to block for the block timeout, returned after %s", elapsed)
+	}
+
+	stats := w.Stats()
+	if stats.Blocked != 1 || stats.Dropped != 1 || stats.BlockedTime < 50*time.Millisecond {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	close(store.gate)
+	closeWriter(t, w)
+}
+
+func TestStorageWriter_OverflowSpillKeepsOrder(t *testing.T) {
+	store := &writerStorage{gate: make(chan struct{})}
+	w, err := NewStorageWriter(store, WriterOptions{QueueSize: 1, Overflow: OverflowSpill, SpillDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	w.Enqueue(writerRecord("a", "1", 200))
+	waitFor(t, func() bool { return w.Stats().QueueLength == 0 })
+	for _, content := range []string{"b", "c", "d"} {
+		w.Enqueue(writerRecord(content, content, 200))
+	}
+	if stats := w.Stats(); stats.Spilled != 2 || stats.SpoolLength != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	close(store.gate)
+	waitFor(t, func() bool { return w.Stats().Written == 4 })
+	closeWriter(t, w)
+
+	if contents := store.Contents(); !reflect.DeepEqual(contents, []string{"a", "1", "b", "b", "c", "c", "d", "d"}) {
+		t.Errorf("Unexpected messages: %v", contents)
+	}
+}
+
+func TestSpool_KeepsRecordsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	for _, content := range []string{"a", "b", "c"} {
+		if err := spool.Append(writerRecord(content, "", 0)); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("Failed to close spool: %v", err)
+	}
+
+	spool, err = NewSpool(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen spool: %v", err)
+	}
+	if spool.Len() != 3 {
+		t.Fatalf("Expected 3 records, got %d", spool.Len())
+	}
+
+	// Stop after the second record, the remaining records stay in the spool
+	var drained []string
+	err = spool.Drain(func(record Record) error {
+		if len(drained) == 2 {
+			return context.Canceled
+		}
+		drained = append(drained, record.History[0].Content)
+		return nil
+	})
+	if err != context.Canceled || spool.Len() != 1 {
+		t.Errorf("Expected one remaining record, got %d (%v)", spool.Len(), err)
+	}
+	if err := spool.Drain(func(record Record) error {
+		drained = append(drained, record.History[0].Content)
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to drain: %v", err)
+	}
+	if !reflect.DeepEqual(drained, []string{"a", "b", "c"}) || spool.Len() != 0 {
+		t.Errorf("Unexpected drained records: %v", drained)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
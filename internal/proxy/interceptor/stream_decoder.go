@@ -0,0 +1,156 @@
+package interceptor
+
+import (
+	"bytes"
+	"strings"
+)
+
+// lineBuffer collects chunks of a stream and splits them into complete lines. Lines may be terminated by
+// "\n", "\r\n" or "\r". Incomplete lines are kept until the next chunk arrives or the buffer is flushed.
+type lineBuffer struct {
+	buf []byte
+}
+
+// lines appends the chunk to the buffer and returns all complete lines without line terminators
+func (b *lineBuffer) lines(chunk []byte) []string {
+	b.buf = append(b.buf, chunk...)
+
+	var lines []string
+	for {
+		i := bytes.IndexAny(b.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		// A trailing "\r" may be the first half of "\r\n", so wait for the next chunk
+		if b.buf[i] == '\r' && i == len(b.buf)-1 {
+			break
+		}
+		lines = append(lines, string(b.buf[:i]))
+		if b.buf[i] == '\r' && b.buf[i+1] == '\n' {
+			i++
+		}
+		b.buf = b.buf[i+1:]
+	}
+
+	// Release the memory of consumed lines
+	if len(b.buf) == 0 {
+		b.buf = nil
+	}
+	return lines
+}
+
+// flush returns the remaining incomplete line, if any, and resets the buffer
+func (b *lineBuffer) flush() []string {
+	rest := strings.TrimRight(string(b.buf), "\r")
+	b.buf = nil
+	if rest == "" {
+		return nil
+	}
+	return []string{rest}
+}
+
+// NDJSONDecoder decodes a stream of newline delimited JSON objects as sent by Ollama. Chunks of the stream
+// may end anywhere, objects straddling chunk boundaries are buffered until they are complete.
+// The zero value is ready to use.
+type NDJSONDecoder struct {
+	buffer lineBuffer
+}
+
+// Decode appends a chunk of the stream and returns all complete, non-empty lines
+func (d *NDJSONDecoder) Decode(chunk []byte) [][]byte {
+	return nonEmpty(d.buffer.lines(chunk))
+}
+
+// Flush returns the last line of a stream which is not terminated by a newline. It needs to be called
+// once the stream is complete.
+func (d *NDJSONDecoder) Flush() [][]byte {
+	return nonEmpty(d.buffer.flush())
+}
+
+func nonEmpty(lines []string) [][]byte {
+	var result [][]byte
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			result = append(result, []byte(line))
+		}
+	}
+	return result
+}
+
+// SSEEvent represents a single Server-Sent Event
+type SSEEvent struct {
+	// Event is the event type of the "event:" field, which is empty for unnamed events
+	Event string
+	// Data contains the joined "data:" fields of the event, separated by newlines
+	Data string
+	// ID is the value of the "id:" field
+	ID string
+}
+
+// SSEDecoder decodes a stream of Server-Sent Events as sent by OpenAI and Anthropic compatible servers.
+// Events are dispatched on empty lines, multiple "data:" fields of an event are joined with newlines.
+// Chunks of the stream may end anywhere, incomplete events are buffered until they are complete.
+// The zero value is ready to use.
+type SSEDecoder struct {
+	buffer  lineBuffer
+	event   SSEEvent
+	data    []string
+	hasData bool
+}
+
+// Decode appends a chunk of the stream and returns all complete events
+func (d *SSEDecoder) Decode(chunk []byte) []SSEEvent {
+	return d.process(d.buffer.lines(chunk))
+}
+
+// Flush returns the last event of a stream which is not terminated by an empty line. It needs to be called
+// once the stream is complete.
+func (d *SSEDecoder) Flush() []SSEEvent {
+	events := d.process(d.buffer.flush())
+	if event, ok := d.dispatch(); ok {
+		events = append(events, event)
+	}
+	return events
+}
+
+func (d *SSEDecoder) process(lines []string) []SSEEvent {
+	var events []SSEEvent
+	for _, line := range lines {
+		if line == "" {
+			if event, ok := d.dispatch(); ok {
+				events = append(events, event)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// Comment, e.g. used as keep-alive
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			d.event.Event = value
+		case "data":
+			d.data = append(d.data, value)
+			d.hasData = true
+		case "id":
+			d.event.ID = value
+		}
+	}
+	return events
+}
+
+// dispatch returns the current event and starts a new one. Events without data are discarded.
+func (d *SSEDecoder) dispatch() (SSEEvent, bool) {
+	event := d.event
+	event.Data = strings.Join(d.data, "\n")
+	ok := d.hasData
+
+	d.event = SSEEvent{}
+	d.data = nil
+	d.hasData = false
+	return event, ok
+}
@@ -0,0 +1,65 @@
+package interceptor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNDJSONDecoder_SplitChunks(t *testing.T) {
+	var d NDJSONDecoder
+
+	var lines []string
+	for _, chunk := range []string{`{"a":`, `1}` + "\n" + `{"b":2}` + "\n\n" + `{"c"`, `:3}` + "\r\n", `{"d":4}`} {
+		for _, line := range d.Decode([]byte(chunk)) {
+			lines = append(lines, string(line))
+		}
+	}
+	if expected := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+
+	flushed := d.Flush()
+	if len(flushed) != 1 || string(flushed[0]) != `{"d":4}` {
+		t.Errorf("Expected unterminated last line on flush, got %q", flushed)
+	}
+	if flushed := d.Flush(); len(flushed) != 0 {
+		t.Errorf("Expected empty buffer after flush, got %q", flushed)
+	}
+}
+
+func TestSSEDecoder_SplitChunks(t *testing.T) {
+	var d SSEDecoder
+
+	stream := "event: message_start\ndata: {\"type\":\"message_start\"}\n\n" +
+		": keep-alive\n\n" +
+		"data: {\"choices\":[]}\r\n\r\n" +
+		"event: multi\ndata: first\ndata:second\nid: 42\n\n" +
+		"data: [DONE]"
+
+	// Feed the stream byte by byte to make events straddle chunk boundaries
+	var events []SSEEvent
+	for i := 0; i < len(stream); i++ {
+		events = append(events, d.Decode([]byte{stream[i]})...)
+	}
+	events = append(events, d.Flush()...)
+
+	expected := []SSEEvent{
+		{Event: "message_start", Data: `{"type":"message_start"}`},
+		{Data: `{"choices":[]}`},
+		{Event: "multi", Data: "first\nsecond", ID: "42"},
+		{Data: "[DONE]"},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, events)
+	}
+}
+
+func TestSSEDecoder_CarriageReturnAtChunkEnd(t *testing.T) {
+	var d SSEDecoder
+
+	events := d.Decode([]byte("data: a\r"))
+	events = append(events, d.Decode([]byte("\n\r\n"))...)
+	if len(events) != 1 || events[0].Data != "a" {
+		t.Errorf("Expected a single event, got %+v", events)
+	}
+}
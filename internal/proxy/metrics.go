@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "llm_monitor"
+
+// Label values of requests whose model, endpoint or upstream is not known
+const (
+	unknownModel      = "unknown"
+	unmatchedEndpoint = "unmatched"
+	noUpstream        = "none"
+)
+
+// Metrics collects Prometheus metrics of the proxied requests. Requests are labeled by the model reported in the
+// response of the upstream, the pattern of the matched endpoint, the name of the upstream and the status code. The
+// model and the path requested by the client are never used as labels, so clients can't create arbitrary series. All
+// methods can safely be called on nil metrics, which disables them.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	timeToFirstToken *prometheus.HistogramVec
+	tokensPerSecond  *prometheus.HistogramVec
+	promptTokens     *prometheus.CounterVec
+	completionTokens *prometheus.CounterVec
+	inFlightStreams  *prometheus.GaugeVec
+	// saveErrors counts the records which could not be saved within the request, failures of the asynchronous
+	// writer are counted by the writer itself
+	saveErrors atomic.Uint64
+	writer     atomic.Pointer[interceptor.StorageWriter]
+}
+
+// NewMetrics creates the metrics of the proxy together with the metrics of the Go runtime and the process
+func NewMetrics() *Metrics {
+	labels := []string{"model", "endpoint", "upstream"}
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Number of proxied requests.",
+		}, append(labels, "status")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of proxied requests until the response is complete.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, append(labels, "status")),
+		timeToFirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "time_to_first_token_seconds",
+			Help:      "Time until the first chunk of a streaming response has been sent to the client.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+		}, labels),
+		tokensPerSecond: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "tokens_per_second",
+			Help:      "Completion tokens generated per second.",
+			Buckets:   []float64{1, 5, 10, 20, 30, 50, 75, 100, 150, 250, 500},
+		}, labels),
+		promptTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prompt_tokens_total",
+			Help:      "Number of prompt tokens reported by the upstreams.",
+		}, labels),
+		completionTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "completion_tokens_total",
+			Help:      "Number of completion tokens reported by the upstreams.",
+		}, labels),
+		inFlightStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "in_flight_streams",
+			Help:      "Number of streaming responses currently being sent to clients.",
+		}, []string{"upstream"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.timeToFirstToken, m.tokensPerSecond,
+		m.promptTokens, m.completionTokens, m.inFlightStreams,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "storage_write_errors_total",
+			Help:      "Number of records which could not be saved to storage, neither directly nor by the storage writer.",
+		}, func() float64 {
+			failed := m.saveErrors.Load()
+			if writer := m.writer.Load(); writer != nil {
+				failed += writer.Stats().Failed
+			}
+			return float64(failed)
+		}),
+	)
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Wrap serves the metrics at the path and passes all other requests to the handler
+func (m *Metrics) Wrap(path string, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	metrics := m.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RegisterWriter exposes the counters of the asynchronous storage writer
+func (m *Metrics) RegisterWriter(writer *interceptor.StorageWriter) {
+	if m == nil || writer == nil {
+		return
+	}
+	m.writer.Store(writer)
+	counter := func(name, help string, value func(stats interceptor.WriterStats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "storage_writer",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(writer.Stats()) })
+	}
+	gauge := func(name, help string, value func(stats interceptor.WriterStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "storage_writer",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(writer.Stats()) })
+	}
+	m.registry.MustRegister(
+		counter("enqueued_total", "Number of records enqueued.", func(s interceptor.WriterStats) float64 { return float64(s.Enqueued) }),
+		counter("written_total", "Number of records written to storage.", func(s interceptor.WriterStats) float64 { return float64(s.Written) }),
+		counter("dropped_total", "Number of records dropped because the queue was full.", func(s interceptor.WriterStats) float64 { return float64(s.Dropped) }),
+		counter("spilled_total", "Number of records spilled to disk because the queue was full.", func(s interceptor.WriterStats) float64 { return float64(s.Spilled) }),
+		counter("coalesced_total", "Number of records superseded by a later record of the same conversation.", func(s interceptor.WriterStats) float64 { return float64(s.Coalesced) }),
+		counter("blocked_total", "Number of requests which waited for space in the queue.", func(s interceptor.WriterStats) float64 { return float64(s.Blocked) }),
+		counter("blocked_seconds_total", "Time requests waited for space in the queue.", func(s interceptor.WriterStats) float64 { return s.BlockedTime.Seconds() }),
+		counter("batches_total", "Number of batches written to storage.", func(s interceptor.WriterStats) float64 { return float64(s.Batches) }),
+		gauge("queue_length", "Number of records waiting in the queue.", func(s interceptor.WriterStats) float64 { return float64(s.QueueLength) }),
+		gauge("queue_capacity", "Capacity of the queue.", func(s interceptor.WriterStats) float64 { return float64(s.QueueCapacity) }),
+		gauge("spool_length", "Number of records spilled to disk waiting to be queued again.", func(s interceptor.WriterStats) float64 { return float64(s.SpoolLength) }),
+	)
+}
+
+// RegisterReplayer exposes the number of records spooled while the storage is unavailable
+func (m *Metrics) RegisterReplayer(replayer *interceptor.Replayer) {
+	if m == nil || replayer == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "storage_spool",
+		Name:      "pending",
+		Help:      "Number of spooled records waiting to be replayed to storage.",
+	}, func() float64 { return float64(replayer.Pending()) }))
+}
+
+// RegisterRetention exposes the counters of the retention job
+func (m *Metrics) RegisterRetention(job *storage.RetentionJob) {
+	if m == nil || job == nil {
+		return
+	}
+	counter := func(name, help string, value func(stats storage.RetentionStats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "retention",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(job.Stats()) })
+	}
+	gauge := func(name, help string, value func(stats storage.RetentionStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "retention",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(job.Stats()) })
+	}
+	m.registry.MustRegister(
+		counter("runs_total", "Number of purges.", func(s storage.RetentionStats) float64 { return float64(s.Runs) }),
+		counter("failures_total", "Number of failed purges.", func(s storage.RetentionStats) float64 { return float64(s.Failures) }),
+		counter("deleted_conversations_total", "Number of purged conversations.", func(s storage.RetentionStats) float64 { return float64(s.DeletedConversations) }),
+		counter("deleted_tools_total", "Number of purged tool definitions.", func(s storage.RetentionStats) float64 { return float64(s.DeletedTools) }),
+		gauge("last_run_timestamp_seconds", "Time of the last purge.", func(s storage.RetentionStats) float64 {
+			if s.LastRun.IsZero() {
+				return 0
+			}
+			return float64(s.LastRun.UnixNano()) / 1e9
+		}),
+		gauge("last_duration_seconds", "Duration of the last purge.", func(s storage.RetentionStats) float64 { return s.LastDuration.Seconds() }),
+	)
+}
+
+// trackStream counts a streaming response as in flight until the returned function is called
+func (m *Metrics) trackStream(upstream string) func() {
+	if m == nil {
+		return func() {}
+	}
+	if upstream == "" {
+		upstream = noUpstream
+	}
+	gauge := m.inFlightStreams.WithLabelValues(upstream)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// observe records a complete request. The model and the token usage are taken from the record reported by the
+// interceptor, the upstream and the time of the first chunk from the exchange.
+func (m *Metrics) observe(exchange *interceptor.Exchange, endpoint string, status int, start time.Time) {
+	if m == nil {
+		return
+	}
+	end := time.Now()
+	record, _ := exchange.Record()
+	if exchange.SaveError() != nil {
+		m.saveErrors.Add(1)
+	}
+
+	model := unknownModel
+	if len(record.Choices) > 0 && record.Choices[0].Model != "" {
+		model = record.Choices[0].Model
+	}
+	if endpoint == "" {
+		endpoint = unmatchedEndpoint
+	}
+	upstream := exchange.Upstream()
+	if upstream == "" {
+		upstream = noUpstream
+	}
+
+	m.requests.WithLabelValues(model, endpoint, upstream, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(model, endpoint, upstream, strconv.Itoa(status)).Observe(end.Sub(start).Seconds())
+	if firstChunk := exchange.FirstChunk(); !firstChunk.IsZero() {
+		m.timeToFirstToken.WithLabelValues(model, endpoint, upstream).Observe(firstChunk.Sub(start).Seconds())
+	}
+
+	var promptTokens, completionTokens int
+	for _, choice := range record.Choices {
+		promptTokens += choice.PromptTokens
+		completionTokens += choice.CompletionTokens
+	}
+	if promptTokens > 0 {
+		m.promptTokens.WithLabelValues(model, endpoint, upstream).Add(float64(promptTokens))
+	}
+	if completionTokens > 0 {
+		m.completionTokens.WithLabelValues(model, endpoint, upstream).Add(float64(completionTokens))
+		// The generation time is reported by the upstream or measured by the interceptor
+		if generation := record.Choices[0].EvalDuration; generation > 0 {
+			m.tokensPerSecond.WithLabelValues(model, endpoint, upstream).Observe(float64(completionTokens) / generation.Seconds())
+		}
+	}
+}
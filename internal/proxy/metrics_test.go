@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMetrics_ObservesRequests(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"Hello"},"done":false}`)
+		w.(http.Flusher).Flush()
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":" world"},"done":true,"prompt_eval_count":12,"eval_count":30,"eval_duration":1500000000}`)
+	}))
+	defer upstream.Close()
+
+	ph, _ := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	ph.Metrics = NewMetrics()
+	saving := interceptor.SavingInterceptor{Storage: storage.NewMemoryStorage(0), Timeout: time.Second}
+	chat, err := CreateInterceptor(config.Intercept{Interceptor: "OllamaChatInterceptor"}, saving)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ph.RegisterRoute(interceptor.Route{Pattern: "/api/chat", Method: "POST", StripPrefix: "/ollama"}, chat); err != nil {
+		t.Fatal(err)
+	}
+	handler := ph.Metrics.Wrap("/metrics", ph)
+
+	body := `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true}`
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/ollama/api/chat", strings.NewReader(body)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/tags", nil))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := w.Body.String()
+	for _, expected := range []string{
+		`llm_monitor_requests_total{endpoint="/ollama/api/chat",model="llama3",status="200",upstream="default"} 1`,
+		`llm_monitor_requests_total{endpoint="unmatched",model="unknown",status="200",upstream="default"} 1`,
+		`llm_monitor_request_duration_seconds_count{endpoint="/ollama/api/chat",model="llama3",status="200",upstream="default"} 1`,
+		`llm_monitor_time_to_first_token_seconds_count{endpoint="/ollama/api/chat",model="llama3",upstream="default"} 1`,
+		`llm_monitor_prompt_tokens_total{endpoint="/ollama/api/chat",model="llama3",upstream="default"} 12`,
+		`llm_monitor_completion_tokens_total{endpoint="/ollama/api/chat",model="llama3",upstream="default"} 30`,
+		`llm_monitor_tokens_per_second_sum{endpoint="/ollama/api/chat",model="llama3",upstream="default"} 20`,
+		`llm_monitor_in_flight_streams{upstream="default"} 0`,
+		`llm_monitor_storage_write_errors_total 0`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected metric %s in\n%s", expected, metrics)
+		}
+	}
+}
+
+func TestMetrics_WithoutStorage(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "no-such-model") {
+			http.Error(w, `{"error":"model 'no-such-model' not found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"Hello"},"done":true,"prompt_eval_count":12,"eval_count":30,"eval_duration":1500000000}`)
+	}))
+	defer upstream.Close()
+
+	ph, _ := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	ph.Metrics = NewMetrics()
+	chat, err := CreateInterceptor(config.Intercept{Interceptor: "OllamaChatInterceptor"}, interceptor.SavingInterceptor{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ph.RegisterInterceptor("/api/chat", "POST", chat); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, model := range []string{"llama3", "no-such-model"} {
+		body := `{"model":"` + model + `","messages":[{"role":"user","content":"Hi"}],"stream":false}`
+		ph.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+	}
+
+	w := httptest.NewRecorder()
+	ph.Metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := w.Body.String()
+	for _, expected := range []string{
+		`llm_monitor_requests_total{endpoint="/api/chat",model="llama3",status="200",upstream="default"} 1`,
+		`llm_monitor_prompt_tokens_total{endpoint="/api/chat",model="llama3",upstream="default"} 12`,
+		`llm_monitor_completion_tokens_total{endpoint="/api/chat",model="llama3",upstream="default"} 30`,
+		`llm_monitor_tokens_per_second_count{endpoint="/api/chat",model="llama3",upstream="default"} 1`,
+		// The model requested by the client is not used as a label
+		`llm_monitor_requests_total{endpoint="/api/chat",model="unknown",status="404",upstream="default"} 1`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected metric %s in\n%s", expected, metrics)
+		}
+	}
+	if strings.Contains(metrics, "no-such-model") {
+		t.Errorf("Expected no series for the requested model:\n%s", metrics)
+	}
+}
+
+func TestMetrics_StorageWriteErrors(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	metrics := NewMetrics()
+	exchange := interceptor.NewExchange()
+	exchange.SetRecord(interceptor.Record{}, fmt.Errorf("connection refused"))
+	metrics.observe(exchange, "/api/chat", http.StatusOK, time.Now())
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "llm_monitor_storage_write_errors_total 1") {
+		t.Errorf("Expected the failed write to be counted:\n%s", w.Body.String())
+	}
+
+	// Disabled metrics are nil
+	var disabled *Metrics
+	disabled.observe(exchange, "/api/chat", http.StatusOK, time.Now())
+	disabled.trackStream("default")()
+}
@@ -1,13 +1,16 @@
 package proxy
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"llm-monitor/internal/proxy/interceptor"
 	"net"
 	"net/http"
-	"net/url"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -15,12 +18,19 @@ import (
 
 // ProxyHandler handles proxy requests
 type ProxyHandler struct {
-	UpstreamURL *url.URL
-	Manager     *interceptor.Manager
-	Client      *http.Client
-	Port        int
+	Router  *UpstreamRouter
+	Manager *interceptor.Manager
+	Retry   RetryPolicy
+	Port    int
+	// Metrics records the proxied requests if it is set
+	Metrics *Metrics
+	// Tracer traces the proxied requests if it is set
+	Tracer *Tracer
 }
 
+// errNoHealthyBackend is returned if an upstream has no healthy backend left
+var errNoHealthyBackend = errors.New("no healthy backend available")
+
 func createHttpTransport() *http.Transport {
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
@@ -42,36 +52,56 @@ func createHttpTransport() *http.Transport {
 	}
 }
 
-// NewProxyHandler creates a new proxy handler
+// NewProxyHandler creates a new proxy handler. The upstream URL is used as the default upstream for all requests
+// which are not routed to a specific upstream. It may be empty if all requests are routed by model.
 func NewProxyHandler(upstreamURL string, port int, timeout time.Duration) (*ProxyHandler, error) {
-	parsedURL, err := url.Parse(upstreamURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid upstream URL: %v", err)
+	var fallback *Upstream
+	if upstreamURL != "" {
+		var err error
+		fallback, err = NewUpstream("default", upstreamURL, nil, timeout)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Create a custom HTTP client with TLS configuration
-	transport := createHttpTransport()
-
 	logrus.WithFields(logrus.Fields{
 		"port":     port,
 		"upstream": upstreamURL,
 		"timeout":  timeout,
 	}).Info("Server configuration")
 
+	return NewProxyHandlerWithRouter(NewUpstreamRouter(fallback), port), nil
+}
+
+// NewProxyHandlerWithRouter creates a new proxy handler using the given upstream router
+func NewProxyHandlerWithRouter(router *UpstreamRouter, port int) *ProxyHandler {
 	return &ProxyHandler{
-		UpstreamURL: parsedURL,
-		Manager:     interceptor.NewInterceptorManager(),
-		Client: &http.Client{
-			Transport: transport,
-			Timeout:   timeout,
-		},
-		Port: port,
-	}, nil
+		Router:  router,
+		Manager: interceptor.NewInterceptorManager(),
+		Port:    port,
+	}
+}
+
+// RegisterUpstream registers an additional upstream serving the models matching its glob patterns
+func (ph *ProxyHandler) RegisterUpstream(upstream *Upstream) {
+	ph.Router.AddUpstream(upstream)
+
+	logrus.WithFields(logrus.Fields{
+		"name":     upstream.Name,
+		"backends": len(upstream.Backends),
+		"models":   upstream.Models,
+		"timeout":  upstream.Client.Timeout,
+	}).Info("Registered upstream")
+}
+
+// RegisterInterceptor registers an interceptor for an endpoint pattern and method
+func (ph *ProxyHandler) RegisterInterceptor(endpoint string, method string, interceptor interceptor.Interceptor) error {
+	return ph.Manager.RegisterInterceptor(endpoint, method, interceptor)
 }
 
-// RegisterInterceptor registers an interceptor for a specific endpoint and method
-func (ph *ProxyHandler) RegisterInterceptor(endpoint string, method string, interceptor interceptor.Interceptor) {
-	ph.Manager.RegisterInterceptor(endpoint, method, interceptor)
+// RegisterRoute registers an interceptor for the requests matching the route
+func (ph *ProxyHandler) RegisterRoute(route interceptor.Route, interceptor interceptor.Interceptor) error {
+	return ph.Manager.RegisterRoute(route, interceptor)
 }
 
 // modifyHeaders modifies headers before sending to upstream
@@ -100,29 +130,48 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		statusCode:     http.StatusOK,
 	}
 
+	// Collect information about the exchange for the interceptors
+	exchange := interceptor.NewExchange()
+	r = r.WithContext(interceptor.WithExchange(r.Context(), exchange))
+	r, span := ph.Tracer.start(r)
+	path := r.URL.Path
+
 	// Get interceptor for this endpoint and method
-	intcptor := ph.Manager.GetInterceptor(r.URL.Path, r.Method)
+	match, _ := ph.Manager.Match(r.URL.Path, r.Method)
+	intcptor := match.Interceptor
 	var state interceptor.State
 
 	if intcptor != nil {
 		// Create state for this interceptor
 		state = intcptor.CreateState()
+		exchange.SetParams(match.Params)
+
+		// Forward the request without the stripped prefix
+		if match.Path != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = match.Path, ""
+			r.URL = &u
+		}
 	}
 
 	err := ph.ServeHTTP2(lrw, r, intcptor, state)
 
+	endpoint := ""
 	if intcptor != nil {
 		if err != nil {
 			intcptor.OnError(state, err)
 		} else {
 			intcptor.OnComplete(state)
 		}
+		endpoint = strings.TrimSuffix(match.Route.StripPrefix, "/") + match.Route.Pattern
 	}
+	ph.Metrics.observe(exchange, endpoint, lrw.statusCode, start)
+	ph.Tracer.end(span, exchange, lrw.statusCode, err)
 
 	duration := time.Since(start)
 	logrus.WithFields(logrus.Fields{
 		"method":   r.Method,
-		"path":     r.URL.Path,
+		"path":     path,
 		"status":   lrw.statusCode,
 		"duration": duration,
 		"remote":   r.RemoteAddr,
@@ -133,10 +182,7 @@ func (ph *ProxyHandler) ServeHTTP2(w http.ResponseWriter, r *http.Request, intcp
 	// Create a copy of the request to modify headers
 	req := r.Clone(r.Context())
 	req.RequestURI = ""
-	req.Host = ph.UpstreamURL.Host
 	req.RemoteAddr = ""
-	req.URL.Scheme = ph.UpstreamURL.Scheme
-	req.URL.Host = ph.UpstreamURL.Host
 	modifyHeaders(req, map[string]string{
 		"X-Forwarded-Proto": "http",
 		"X-Forwarded-Host":  r.Host,
@@ -144,27 +190,80 @@ func (ph *ProxyHandler) ServeHTTP2(w http.ResponseWriter, r *http.Request, intcp
 	})
 
 	if intcptor != nil {
-		// Apply request interceptor
+		// Apply request interceptor, which may abort the request or answer it itself
 		if err := intcptor.RequestInterceptor(req, state); err != nil {
-			logrus.WithError(err).Warn("Error in intercepting request")
+			var abort *interceptor.AbortError
+			var shortCircuit *interceptor.ShortCircuit
+			switch {
+			case errors.As(err, &abort):
+				http.Error(w, http.StatusText(abort.StatusCode), abort.StatusCode)
+				return err
+			case errors.As(err, &shortCircuit):
+				return ph.writeResponse(w, shortCircuit.Response(req), intcptor, state)
+			default:
+				logrus.WithError(err).Warn("Error in intercepting request")
+			}
 		}
 	}
 
-	// Forward the request to upstream
-	resp, err := ph.Client.Do(req)
+	// Buffer the request body if needed for selecting the upstream or for replaying it on retries
+	var body []byte
+	if ph.Router.RoutesByModel() || ph.Retry.Enabled() {
+		var err error
+		body, err = readBody(req)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return err
+		}
+	}
+
+	// Select upstream by the model of the (possibly modified) request
+	model := ""
+	if ph.Router.RoutesByModel() {
+		model = peekModel(body)
+	}
+	upstream := ph.Router.Route(model)
+	if upstream == nil {
+		http.Error(w, "No upstream available", http.StatusBadGateway)
+		return fmt.Errorf("no upstream configured for model '%s'", model)
+	}
+	interceptor.ExchangeFromContext(r.Context()).SetUpstream(upstream.Name)
+
+	// Forward the request to upstream, continuing the trace of the request
+	ph.Tracer.inject(req)
+	resp, backend, attempts, err := ph.forward(req, body, upstream, clientKey(r))
+	if len(attempts) > 1 {
+		interceptor.ExchangeFromContext(r.Context()).SetMetadata("attempts", attempts)
+	}
+	if errors.Is(err, errNoHealthyBackend) {
+		http.Error(w, "No healthy upstream available", http.StatusServiceUnavailable)
+		return fmt.Errorf("%w in upstream '%s'", err, upstream.Name)
+	}
 	if err != nil {
 		http.Error(w, "Upstream error", http.StatusBadGateway)
 		return err
 	}
+	defer backend.Release()
 	defer func() {
 		if resp.Body != nil {
 			_ = resp.Body.Close()
 		}
 	}()
 
+	return ph.writeResponse(w, resp, intcptor, state)
+}
+
+// writeResponse passes the response through the interceptor and writes it to the client. It returns an error if
+// the response has an error status code.
+func (ph *ProxyHandler) writeResponse(w http.ResponseWriter, resp *http.Response, intcptor interceptor.Interceptor, state interceptor.State) error {
 	// Apply response interceptor if exists
 	if intcptor != nil {
 		if err := intcptor.ResponseInterceptor(resp, state); err != nil {
+			var abort *interceptor.AbortError
+			if errors.As(err, &abort) {
+				http.Error(w, http.StatusText(abort.StatusCode), abort.StatusCode)
+				return err
+			}
 			logrus.WithError(err).Warn("Error in intercepting response")
 		}
 	}
@@ -181,7 +280,11 @@ func (ph *ProxyHandler) ServeHTTP2(w http.ResponseWriter, r *http.Request, intcp
 
 	// Handle chunked responses
 	if len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked" {
-		err := ph.handleChunkedResponse(w, resp, intcptor, state)
+		var exchange *interceptor.Exchange
+		if resp.Request != nil {
+			exchange = interceptor.ExchangeFromContext(resp.Request.Context())
+		}
+		err := ph.handleChunkedResponse(w, resp, intcptor, state, exchange)
 		if err != nil {
 			// Don't send error response here - we already wrote headers
 			return err
@@ -203,13 +306,58 @@ func (ph *ProxyHandler) ServeHTTP2(w http.ResponseWriter, r *http.Request, intcp
 	return nil
 }
 
+// aborted reports whether an interceptor aborted the request
+func aborted(err error) bool {
+	var abort *interceptor.AbortError
+	return errors.As(err, &abort)
+}
+
+// clientKey identifies the client of a request for balancers with client affinity
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// readBody reads the complete request body and replaces it with a buffered copy.
+// Returns nil if the request has no body.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// peekModel extracts the model from a JSON request body
+func peekModel(body []byte) string {
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	return payload.Model
+}
+
 // handleChunkedResponse handles chunked responses with interceptors
-func (ph *ProxyHandler) handleChunkedResponse(w http.ResponseWriter, resp *http.Response, interceptor interceptor.Interceptor, state interceptor.State) error {
+func (ph *ProxyHandler) handleChunkedResponse(w http.ResponseWriter, resp *http.Response, interceptor interceptor.Interceptor, state interceptor.State, exchange *interceptor.Exchange) error {
+	defer ph.Metrics.trackStream(exchange.Upstream())()
+
 	// Create a custom response writer that intercepts chunks
 	chunkWriter := &chunkWriter{
 		ResponseWriter: w,
 		interceptor:    interceptor,
 		state:          state,
+		exchange:       exchange,
 	}
 
 	// Copy response body to our chunk writer
@@ -234,6 +382,8 @@ func (ph *ProxyHandler) handleRegularResponse(w http.ResponseWriter, resp *http.
 	if interceptor != nil {
 		if processedBody, err := interceptor.ContentInterceptor(body, state); err == nil {
 			body = processedBody
+		} else if aborted(err) {
+			return err
 		} else {
 			logrus.WithError(err).Warn("Error in intercepting body")
 		}
@@ -254,6 +404,7 @@ type chunkWriter struct {
 	http.ResponseWriter
 	interceptor interceptor.Interceptor
 	state       interceptor.State
+	exchange    *interceptor.Exchange
 }
 
 // Write intercepts chunks and applies chunk interceptors
@@ -262,6 +413,8 @@ func (cw *chunkWriter) Write(data []byte) (int, error) {
 	if cw.interceptor != nil {
 		if processedData, err := cw.interceptor.ChunkInterceptor(data, cw.state); err == nil {
 			data = processedData
+		} else if aborted(err) {
+			return 0, err
 		} else {
 			logrus.WithError(err).Warn("Error in intercepting chunk")
 			// Continue with original data if chunk processing fails
@@ -269,6 +422,9 @@ func (cw *chunkWriter) Write(data []byte) (int, error) {
 	}
 
 	// Write the processed chunk
+	if len(data) > 0 {
+		cw.exchange.MarkFirstChunk()
+	}
 	n, err := cw.ResponseWriter.Write(data)
 	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
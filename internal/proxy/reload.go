@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"llm-monitor/internal/config"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// generation is a ProxyHandler together with the number of requests it is serving
+type generation struct {
+	handler *ProxyHandler
+	active  atomic.Int64
+	retired atomic.Bool
+	drained chan struct{}
+	once    sync.Once
+}
+
+func newGeneration(handler *ProxyHandler) *generation {
+	return &generation{handler: handler, drained: make(chan struct{})}
+}
+
+func (g *generation) release() {
+	if g.active.Add(-1) == 0 && g.retired.Load() {
+		g.once.Do(func() { close(g.drained) })
+	}
+}
+
+// retire marks the generation as replaced, drained is closed once it doesn't serve any requests anymore
+func (g *generation) retire() {
+	g.retired.Store(true)
+	if g.active.Load() == 0 {
+		g.once.Do(func() { close(g.drained) })
+	}
+}
+
+// ReloadableHandler serves requests with a ProxyHandler which can be replaced atomically. Every request is served
+// completely by the handler which was current when it arrived, so in-flight requests and streams keep their
+// configuration while new requests use the new one.
+type ReloadableHandler struct {
+	current atomic.Pointer[generation]
+	// mu serializes replacing the handler
+	mu sync.Mutex
+}
+
+// NewReloadableHandler creates a reloadable handler serving requests with the handler
+func NewReloadableHandler(handler *ProxyHandler) *ReloadableHandler {
+	rh := &ReloadableHandler{}
+	rh.current.Store(newGeneration(handler))
+	return rh
+}
+
+// Handler returns the current handler
+func (rh *ReloadableHandler) Handler() *ProxyHandler {
+	return rh.current.Load().handler
+}
+
+func (rh *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		g := rh.current.Load()
+		g.active.Add(1)
+		// The handler may have been replaced in the meantime, its requests might already be considered drained
+		if rh.current.Load() != g {
+			g.release()
+			continue
+		}
+		defer g.release()
+		g.handler.ServeHTTP(w, r)
+		return
+	}
+}
+
+// Replace serves all new requests with the handler and starts its upstream health checks. The health checks of the
+// previous handler are stopped once its in-flight requests are complete.
+func (rh *ReloadableHandler) Replace(handler *ProxyHandler) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	handler.Router.Start()
+	previous := rh.current.Swap(newGeneration(handler))
+	previous.retire()
+	go func() {
+		<-previous.drained
+		previous.handler.Router.Close()
+		logrus.Debug("Closed the upstreams of the previous configuration")
+	}()
+}
+
+// Close stops the upstream health checks of the current handler
+func (rh *ReloadableHandler) Close() {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.current.Load().handler.Router.Close()
+}
+
+// ConfigReloader rebuilds the proxy handler when its config file changes or the process receives SIGHUP. Invalid
+// configurations are rejected and the current configuration is kept. Only the upstreams, retries and intercepts are
+// reloaded; other changes, e.g. of the port or the storage, require a restart.
+type ConfigReloader struct {
+	path     string
+	handler  *ReloadableHandler
+	build    func(cfg config.Config) (*ProxyHandler, error)
+	interval time.Duration
+
+	// mu guards the configuration and the checksum of the file it has been loaded from
+	mu       sync.Mutex
+	cfg      config.Config
+	checksum [sha256.Size]byte
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// NewConfigReloader creates a reloader of the config file, which builds new handlers for the reloadable handler.
+// The config file is checked for changes every interval, zero disables watching the file.
+func NewConfigReloader(path string, cfg config.Config, handler *ReloadableHandler, build func(cfg config.Config) (*ProxyHandler, error), interval time.Duration) *ConfigReloader {
+	cr := &ConfigReloader{
+		path:     path,
+		handler:  handler,
+		build:    build,
+		interval: interval,
+		cfg:      cfg,
+		done:     make(chan struct{}),
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		cr.checksum = sha256.Sum256(data)
+	}
+	return cr
+}
+
+// Reload loads the config file and replaces the proxy handler if the configuration is valid
+func (cr *ConfigReloader) Reload() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	data, err := os.ReadFile(cr.path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	return cr.reload(sha256.Sum256(data))
+}
+
+// reload loads the configuration with the checksum and replaces the proxy handler
+func (cr *ConfigReloader) reload(checksum [sha256.Size]byte) error {
+	cfg, err := config.LoadConfig(cr.path)
+	if err != nil {
+		return err
+	}
+	handler, err := cr.build(*cfg)
+	if err != nil {
+		return err
+	}
+	cr.handler.Replace(handler)
+	cr.checksum = checksum
+
+	if cfg.Proxy.Port != cr.cfg.Proxy.Port || !reflect.DeepEqual(cfg.Storage, cr.cfg.Storage) ||
+		!reflect.DeepEqual(cfg.API, cr.cfg.API) || !reflect.DeepEqual(cfg.Logging, cr.cfg.Logging) {
+		logrus.Warn("Changes of the port, the API, the logging or the storage require a restart")
+	}
+	cr.cfg = *cfg
+	logrus.WithFields(logrus.Fields{
+		"upstreams":  len(cfg.Proxy.Upstreams),
+		"intercepts": len(cfg.Proxy.Intercepts),
+	}).Info("Reloaded configuration")
+	return nil
+}
+
+// check reloads the configuration if the content of the config file has changed
+func (cr *ConfigReloader) check() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	data, err := os.ReadFile(cr.path)
+	if err != nil {
+		logrus.WithError(err).Debug("Could not read config file")
+		return
+	}
+	checksum := sha256.Sum256(data)
+	if checksum == cr.checksum {
+		return
+	}
+	if err := cr.reload(checksum); err != nil {
+		// Don't retry the invalid content until it changes again
+		cr.checksum = checksum
+		logrus.WithError(err).Error("Rejected invalid configuration, keeping the current one")
+	}
+}
+
+// Start watches the config file and handles SIGHUP in the background
+func (cr *ConfigReloader) Start() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	cr.wg.Add(1)
+	go func() {
+		defer cr.wg.Done()
+		defer signal.Stop(signals)
+		var tick <-chan time.Time
+		if cr.interval > 0 {
+			ticker := time.NewTicker(cr.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-cr.done:
+				return
+			case <-signals:
+				logrus.Info("Received SIGHUP, reloading configuration")
+				if err := cr.Reload(); err != nil {
+					logrus.WithError(err).Error("Rejected invalid configuration, keeping the current one")
+				}
+			case <-tick:
+				cr.check()
+			}
+		}
+	}()
+}
+
+// Close stops watching the config file and handling SIGHUP
+func (cr *ConfigReloader) Close() {
+	cr.once.Do(func() {
+		close(cr.done)
+	})
+	cr.wg.Wait()
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/proxy/interceptor/openai"
+	"llm-monitor/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// namedUpstream returns an upstream server answering with its name
+func namedUpstream(name string, release <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+		if release != nil {
+			w.(http.Flusher).Flush()
+			<-release
+		}
+	}))
+}
+
+func TestReloadableHandler_KeepsInFlightRequests(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	release := make(chan struct{})
+	oldUpstream := namedUpstream("old", release)
+	defer oldUpstream.Close()
+	newUpstream := namedUpstream("new", nil)
+	defer newUpstream.Close()
+
+	oldHandler, _ := NewProxyHandler(oldUpstream.URL, 8080, 30*time.Second)
+	handler := NewReloadableHandler(oldHandler)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Start a request which is held open by the old upstream
+	done := make(chan string)
+	go func() {
+		resp, err := http.Get(server.URL + "/api/chat")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		done <- string(body)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for handler.current.Load().active.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the request to be in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	previous := handler.current.Load()
+	newHandler, _ := NewProxyHandler(newUpstream.URL, 8080, 30*time.Second)
+	handler.Replace(newHandler)
+
+	resp, err := http.Get(server.URL + "/api/chat")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "new" {
+		t.Errorf("Expected new requests to use the new configuration, got %q", body)
+	}
+
+	select {
+	case <-previous.drained:
+		t.Fatalf("Expected the previous configuration to serve the in-flight request")
+	default:
+	}
+	close(release)
+	if body := <-done; body != "old" {
+		t.Errorf("Expected the in-flight request to complete with the old configuration, got %q", body)
+	}
+	select {
+	case <-previous.drained:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the previous configuration to be drained")
+	}
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	first := namedUpstream("first", nil)
+	defer first.Close()
+	second := namedUpstream("second", nil)
+	defer second.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(upstream string, interceptorName string) {
+		content := "proxy:\n  upstream:\n    url: " + upstream + "\n  intercepts:\n    - endpoint: /api/chat\n      method: POST\n      interceptor: " + interceptorName + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(first.URL, "LoggingInterceptor")
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	build := func(cfg config.Config) (*ProxyHandler, error) {
+		return createProxyHandler(cfg.Proxy, interceptor.SavingInterceptor{}, nil)
+	}
+	initial, err := build(*cfg)
+	if err != nil {
+		t.Fatalf("Failed to build handler: %v", err)
+	}
+	handler := NewReloadableHandler(initial)
+	reloader := NewConfigReloader(path, *cfg, handler, build, 0)
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader("{}")))
+		return w.Body.String()
+	}
+
+	// Unchanged files are not reloaded
+	reloader.check()
+	if handler.Handler() != initial {
+		t.Errorf("Expected the handler to be kept for an unchanged config file")
+	}
+
+	writeConfig(second.URL, "LoggingInterceptor")
+	reloader.check()
+	if body := get(); body != "second" {
+		t.Errorf("Expected the reloaded upstream, got %q", body)
+	}
+
+	// Invalid configurations are rejected
+	reloaded := handler.Handler()
+	writeConfig(first.URL, "UnknownInterceptor")
+	if err := reloader.Reload(); err == nil {
+		t.Errorf("Expected an invalid configuration to be rejected")
+	}
+	reloader.check()
+	if handler.Handler() != reloaded || get() != "second" {
+		t.Errorf("Expected the current configuration to be kept")
+	}
+	if err := os.WriteFile(path, []byte("proxy: [invalid"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err == nil || handler.Handler() != reloaded {
+		t.Errorf("Expected malformed YAML to be rejected")
+	}
+	for _, content := range []string{
+		"proxy:\n  upstream:\n    url: " + first.URL + "\n    timeout: 30\n",
+		"proxy:\n  upstream:\n    url: " + first.URL + "\n  retry:\n    max_attempts: 2\n    backoff: 5x\n",
+		"proxy:\n  upstream:\n    url: " + first.URL + "\n    health_check:\n      interval: soon\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloader.Reload(); err == nil || handler.Handler() != reloaded {
+			t.Errorf("Expected a malformed duration to be rejected:\n%s", content)
+		}
+	}
+	if get() != "second" {
+		t.Errorf("Expected the current configuration to be kept")
+	}
+}
+
+func TestConfigReloader_KeepsResponseHistories(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		id := "resp_1"
+		if strings.Contains(string(body), "previous_response_id") {
+			id = "resp_2"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"` + id + `","model":"gpt-4.1","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Answer ` + id + `"}]}]}`))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(method string) {
+		content := "proxy:\n  upstream:\n    url: " + upstream.URL + "\n  intercepts:\n    - endpoint: /v1/responses\n      method: " + method + "\n      interceptor: OpenAIResponsesInterceptor\n"
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("POST")
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	store := storage.NewMemoryStorage(0)
+	histories := &openai.ResponseHistories{}
+	build := func(cfg config.Config) (*ProxyHandler, error) {
+		return createProxyHandler(cfg.Proxy, interceptor.SavingInterceptor{Storage: store, Timeout: time.Second}, histories)
+	}
+	initial, err := build(*cfg)
+	if err != nil {
+		t.Fatalf("Failed to build handler: %v", err)
+	}
+	handler := NewReloadableHandler(initial)
+	reloader := NewConfigReloader(path, *cfg, handler, build, 0)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/responses", strings.NewReader(`{"model":"gpt-4.1","input":"Hi"}`)))
+	writeConfig("\"*\"")
+	if err := reloader.Reload(); err != nil || handler.Handler() == initial {
+		t.Fatalf("Expected the configuration to be reloaded: %v", err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/responses", strings.NewReader(`{"model":"gpt-4.1","previous_response_id":"resp_1","input":"And then?"}`)))
+
+	// The chained response continues the conversation started before the reload
+	conversations, err := store.ListConversations(context.Background(), storage.ConversationFilter{}, storage.Pagination{Limit: 10})
+	if err != nil || len(conversations) != 1 {
+		t.Errorf("Expected a single conversation, got %d (%v)", len(conversations), err)
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy describes how requests failing with a connection error or a retryable status code are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// Backoff is the delay before the first retry, which is doubled for every further retry
+	Backoff time.Duration
+	// MaxBackoff limits the delay between two attempts
+	MaxBackoff time.Duration
+	// StatusCodes contains the upstream status codes which trigger a retry
+	StatusCodes []int
+	// Failover prefers backends which have not been tried yet for retries
+	Failover bool
+}
+
+// DefaultRetryStatusCodes are the status codes which are retried if not configured otherwise
+var DefaultRetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable}
+
+// Attempt describes a single attempt of forwarding a request to an upstream backend
+type Attempt struct {
+	Upstream   string        `json:"upstream"`
+	StatusCode int           `json:"status_code,omitzero"`
+	Error      string        `json:"error,omitzero"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Enabled returns true if failed requests are retried at all
+func (p *RetryPolicy) Enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// shouldRetry returns true if the outcome of an attempt is retryable
+func (p *RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return slices.Contains(p.StatusCodes, resp.StatusCode)
+}
+
+// delay returns the backoff before the given retry (starting with 1). A Retry-After header of the
+// failed response is honored as long as it doesn't exceed the maximum backoff.
+func (p *RetryPolicy) delay(retry int, resp *http.Response) time.Duration {
+	d := p.Backoff << (retry - 1)
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			d = max(d, time.Duration(seconds)*time.Second)
+		}
+	}
+	if p.MaxBackoff > 0 && (d > p.MaxBackoff || d < 0) {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// forward sends the request to a backend of the upstream, retrying according to the retry policy. The body
+// contains the buffered request body, which is replayed for every attempt. It may only be nil for a single attempt.
+// On success, the selected backend has been acquired and needs to be released by the caller.
+func (ph *ProxyHandler) forward(req *http.Request, body []byte, upstream *Upstream, clientKey string) (*http.Response, *Backend, []Attempt, error) {
+	maxAttempts := max(ph.Retry.MaxAttempts, 1)
+	var attempts []Attempt
+	var tried []*Backend
+
+	for attempt := 1; ; attempt++ {
+		var exclude []*Backend
+		if ph.Retry.Failover {
+			exclude = tried
+		}
+		backend := upstream.SelectBackend(clientKey, exclude...)
+		if backend == nil {
+			return nil, nil, attempts, errNoHealthyBackend
+		}
+		tried = append(tried, backend)
+
+		outReq := req.Clone(req.Context())
+		if body != nil {
+			outReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		outReq.Host = backend.URL.Host
+		outReq.URL.Scheme = backend.URL.Scheme
+		outReq.URL.Host = backend.URL.Host
+
+		backend.Acquire()
+		start := time.Now()
+		resp, err := upstream.Client.Do(outReq)
+		if err != nil && req.Context().Err() != nil {
+			// The client cancelled the request or timed out, which says nothing about the health of the backend
+			backend.Release()
+			return nil, nil, attempts, err
+		}
+
+		result := Attempt{
+			Upstream: backend.URL.Host,
+			Duration: time.Since(start),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			upstream.ReportFailure(backend, err)
+		} else {
+			result.StatusCode = resp.StatusCode
+		}
+		attempts = append(attempts, result)
+
+		if attempt >= maxAttempts || !ph.Retry.shouldRetry(resp, err) || req.Context().Err() != nil {
+			if err != nil {
+				backend.Release()
+				return nil, nil, attempts, err
+			}
+			return resp, backend, attempts, nil
+		}
+
+		// Discard the failed response before retrying. Nothing has been written to the client yet.
+		delay := ph.Retry.delay(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			_ = resp.Body.Close()
+		}
+		backend.Release()
+
+		logrus.WithFields(logrus.Fields{
+			"upstream": upstream.Name,
+			"backend":  result.Upstream,
+			"status":   result.StatusCode,
+			"error":    result.Error,
+			"attempt":  attempt,
+			"delay":    delay,
+		}).Warn("Upstream request failed, retrying")
+
+		if !sleepContext(req.Context(), delay) {
+			return nil, nil, attempts, req.Context().Err()
+		}
+	}
+}
+
+// sleepContext waits for the given duration. Returns false if the context was cancelled before.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
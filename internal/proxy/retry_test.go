@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/proxy/interceptor"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exchangeInterceptor captures the exchange of the request for inspection by tests
+type exchangeInterceptor struct {
+	interceptor.SimpleInterceptor
+	exchange *interceptor.Exchange
+}
+
+func (ei *exchangeInterceptor) RequestInterceptor(req *http.Request, state interceptor.State) error {
+	ei.exchange = interceptor.ExchangeFromContext(req.Context())
+	return nil
+}
+
+func TestProxyHandler_RetryOnStatusCode(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	ph, err := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	ph.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, StatusCodes: DefaultRetryStatusCodes}
+	ei := &exchangeInterceptor{}
+	ph.RegisterInterceptor("/api/chat", "POST", ei)
+
+	req := httptest.NewRequest("POST", "/api/chat", bytes.NewBufferString(`{"model":"llama3"}`))
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != `{"model":"llama3"}` {
+		t.Errorf("Expected replayed request body, got %q", w.Body.String())
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls.Load())
+	}
+
+	attempts, ok := ei.exchange.Metadata()["attempts"].([]Attempt)
+	if !ok || len(attempts) != 3 {
+		t.Fatalf("Expected 3 recorded attempts, got %v", ei.exchange.Metadata()["attempts"])
+	}
+	if attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[2].StatusCode != http.StatusOK {
+		t.Errorf("Unexpected attempts: %+v", attempts)
+	}
+}
+
+func TestProxyHandler_RetryExhausted(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("rate limited"))
+	}))
+	defer upstream.Close()
+
+	ph, err := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	ph.Retry = RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond, StatusCodes: DefaultRetryStatusCodes}
+
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", bytes.NewBufferString(`{}`)))
+
+	if w.Code != http.StatusTooManyRequests || w.Body.String() != "rate limited" {
+		t.Errorf("Expected last upstream response to be passed through, got %d %q", w.Code, w.Body.String())
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestProxyHandler_NoRetryForOtherStatusCodes(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	ph, err := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	ph.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, StatusCodes: DefaultRetryStatusCodes}
+	ei := &exchangeInterceptor{}
+	ph.RegisterInterceptor("/api/chat", "POST", ei)
+
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", bytes.NewBufferString(`{}`)))
+
+	if w.Code != http.StatusBadRequest || calls.Load() != 1 {
+		t.Errorf("Expected a single attempt with status 400, got %d calls and status %d", calls.Load(), w.Code)
+	}
+	if _, ok := ei.exchange.Metadata()["attempts"]; ok {
+		t.Errorf("Expected no attempts to be recorded for a single attempt")
+	}
+}
+
+func TestProxyHandler_Failover(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	var failing atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer good.Close()
+
+	upstream, err := NewUpstreamFromConfig(config.UpstreamConfig{
+		Backends: []config.BackendConfig{{URL: bad.URL, Weight: 100}, {URL: good.URL}},
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	ph := NewProxyHandlerWithRouter(NewUpstreamRouter(upstream), 8080)
+	ph.Retry = RetryPolicy{MaxAttempts: 2, StatusCodes: DefaultRetryStatusCodes, Failover: true}
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		ph.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"n":1}`)))
+		if w.Code != http.StatusOK || w.Body.String() != `{"n":1}` {
+			t.Errorf("Expected failover to healthy backend, got %d %q", w.Code, w.Body.String())
+		}
+	}
+	if failing.Load() == 0 {
+		t.Errorf("Expected the failing backend to be tried first")
+	}
+}
+
+func TestProxyHandler_RetryConnectionError(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer good.Close()
+
+	upstream, err := NewUpstreamFromConfig(config.UpstreamConfig{
+		Backends: []config.BackendConfig{{URL: "http://127.0.0.1:1", Weight: 100}, {URL: good.URL}},
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	ph := NewProxyHandlerWithRouter(NewUpstreamRouter(upstream), 8080)
+	ph.Retry = RetryPolicy{MaxAttempts: 2, Failover: true}
+	ei := &exchangeInterceptor{}
+	ph.RegisterInterceptor("/api/tags", "GET", ei)
+
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, httptest.NewRequest("GET", "/api/tags", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	attempts, _ := ei.exchange.Metadata()["attempts"].([]Attempt)
+	if len(attempts) != 2 || attempts[0].Error == "" || attempts[1].StatusCode != http.StatusOK {
+		t.Errorf("Unexpected attempts: %+v", attempts)
+	}
+}
+
+func TestProxyHandler_ClientCancelKeepsBackendHealthy(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	received := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		close(received)
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	upstream, err := NewUpstreamFromConfig(config.UpstreamConfig{
+		Backends:    []config.BackendConfig{{URL: slow.URL}},
+		HealthCheck: &config.HealthCheckConfig{Path: "/", Interval: "1h"},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	ph := NewProxyHandlerWithRouter(NewUpstreamRouter(upstream), 8080)
+	ph.Retry = RetryPolicy{MaxAttempts: 3, Failover: true}
+	ei := &exchangeInterceptor{}
+	ph.RegisterInterceptor("/api/chat", "POST", ei)
+
+	// The client gives up while waiting for the upstream
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	r := httptest.NewRequest("POST", "/api/chat", bytes.NewReader([]byte(`{"model":"llama3"}`))).WithContext(ctx)
+	ph.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !upstream.Backends[0].Healthy() {
+		t.Errorf("Expected the backend to stay healthy after the client cancelled the request")
+	}
+	if attempts, ok := ei.exchange.Metadata()["attempts"]; ok {
+		t.Errorf("Expected the cancelled request not to be recorded as failed attempt, got %+v", attempts)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	if d := p.delay(1, nil); d != 100*time.Millisecond {
+		t.Errorf("Expected 100ms, got %s", d)
+	}
+	if d := p.delay(3, nil); d != 400*time.Millisecond {
+		t.Errorf("Expected 400ms, got %s", d)
+	}
+	if d := p.delay(10, nil); d != time.Second {
+		t.Errorf("Expected delay to be capped at 1s, got %s", d)
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"0"}}}
+	if d := p.delay(1, resp); d != 100*time.Millisecond {
+		t.Errorf("Expected 100ms, got %s", d)
+	}
+	resp.Header.Set("Retry-After", "30")
+	if d := p.delay(1, resp); d != time.Second {
+		t.Errorf("Expected Retry-After to be capped at 1s, got %s", d)
+	}
+}
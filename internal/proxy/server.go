@@ -1,9 +1,13 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"llm-monitor/internal/api"
 	"llm-monitor/internal/config"
 	interceptor2 "llm-monitor/internal/proxy/interceptor"
+	anthropic2 "llm-monitor/internal/proxy/interceptor/anthropic"
 	ollama2 "llm-monitor/internal/proxy/interceptor/ollama"
 	openai2 "llm-monitor/internal/proxy/interceptor/openai"
 	"llm-monitor/internal/storage"
@@ -13,69 +17,504 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func CreateServer(cfg config.Config) *http.Server {
+// CreateServer creates the proxy server. If the config file is given, the upstreams, retries and intercepts are
+// reloaded when it changes or the process receives SIGHUP.
+func CreateServer(cfg config.Config, configFile string) *Server {
 	// Parse timeouts
-	upstreamTimeout := 30 * time.Second
-	if cfg.Proxy.Upstream.Timeout != "" {
-		if d, err := time.ParseDuration(cfg.Proxy.Upstream.Timeout); err == nil {
-			upstreamTimeout = d
-		} else {
-			logrus.WithError(err).Warnf("Failed to parse upstream timeout '%s', using default 30s", cfg.Proxy.Upstream.Timeout)
+	storageTimeout := parseDuration(cfg.Storage.Timeout, 30*time.Second, "storage timeout")
+
+	// Initialize storage. If messages can be spooled, an unavailable storage is connected later instead.
+	store, err := storage.CreateStorage(cfg.Storage)
+	if err != nil {
+		if cfg.Storage.Spool == nil {
+			logrus.WithError(err).Fatal("Failed to initialize storage")
 		}
+		logrus.WithError(err).Warn("Storage is unavailable, spooling messages until it can be connected")
+		store = storage.NewReconnectingStorage(func() (storage.Storage, error) {
+			return storage.CreateStorage(cfg.Storage)
+		}, replayInterval(cfg.Storage))
+	} else if store != nil {
+		logrus.Info("Initialized storage backend")
+	}
+	apiServer := startMemoryAPI(cfg, store)
+	replayer := createReplayer(cfg.Storage, store, storageTimeout)
+	writer := createStorageWriter(cfg.Storage, store, storageTimeout, replayer)
+	retention := createRetentionJob(cfg.Storage, store)
+	saving := interceptor2.SavingInterceptor{
+		Storage:  store,
+		Timeout:  storageTimeout,
+		Writer:   writer,
+		Replayer: replayer,
 	}
 
-	storageTimeout := 30 * time.Second
-	if cfg.Storage.Timeout != "" {
-		if d, err := time.ParseDuration(cfg.Storage.Timeout); err == nil {
-			storageTimeout = d
-		} else {
-			logrus.WithError(err).Warnf("Failed to parse storage timeout '%s', using default 30s", cfg.Storage.Timeout)
+	// The histories of the Responses API are kept across reloads, so chained conversations aren't split
+	histories := &openai2.ResponseHistories{}
+	metrics := createMetrics(cfg.Proxy.Metrics, writer, replayer, retention)
+	tracer := createTracer(cfg.Proxy.Tracing)
+
+	// Create the proxy handler, which is rebuilt when the configuration is reloaded
+	build := func(cfg config.Config) (*ProxyHandler, error) {
+		proxy, err := createProxyHandler(cfg.Proxy, saving, histories)
+		if err != nil {
+			return nil, err
 		}
+		proxy.Metrics = metrics
+		proxy.Tracer = tracer
+		return proxy, nil
+	}
+	proxy, err := build(cfg)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create proxy handler")
+	}
+	handler := NewReloadableHandler(proxy)
+	handler.Handler().Router.Start()
+	var reloader *ConfigReloader
+	if configFile != "" {
+		interval := parseDuration(cfg.Proxy.Reload.Interval, 2*time.Second, "config reload interval")
+		reloader = NewConfigReloader(configFile, cfg, handler, build, interval)
+		reloader.Start()
+		logrus.WithFields(logrus.Fields{
+			"file":     configFile,
+			"interval": interval,
+		}).Info("Reloading configuration on changes and SIGHUP")
 	}
 
-	// Initialize storage
-	store, err := storage.CreateStorage(cfg.Storage)
+	// Create a custom server
+	return &Server{
+		Server: &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.Proxy.Port),
+			Handler: metrics.Wrap(metricsPath(cfg.Proxy.Metrics), handler),
+		},
+		reloader:  reloader,
+		handler:   handler,
+		apiServer: apiServer,
+		retention: retention,
+		writer:    writer,
+		replayer:  replayer,
+		store:     store,
+		tracer:    tracer,
+	}
+}
+
+// Server is the proxy server together with the background jobs and the storage it uses
+type Server struct {
+	*http.Server
+	reloader  *ConfigReloader
+	handler   *ReloadableHandler
+	apiServer *http.Server
+	retention *storage.RetentionJob
+	writer    *interceptor2.StorageWriter
+	replayer  *interceptor2.Replayer
+	store     storage.Storage
+	tracer    *Tracer
+}
+
+// Shutdown shuts the server down gracefully. It stops accepting connections and waits for in-flight requests and
+// streams to complete, then flushes the queued storage writes and closes the storage. Everything not done when the
+// context expires is abandoned, queued writes which can't be flushed anymore are kept in the spool if one is
+// configured.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s.reloader != nil {
+		s.reloader.Close()
+	}
+	// Messages of in-flight requests are saved or queued when they complete
+	if err := s.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to wait for in-flight requests: %w", err))
+	}
+	s.handler.Close()
+	if s.apiServer != nil {
+		if err := s.apiServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down API server: %w", err))
+		}
+	}
+	if s.retention != nil {
+		s.retention.Close()
+	}
+	// Flush the writer first, as it falls back to the spool of the replayer
+	if s.writer != nil {
+		if err := s.writer.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush storage writer: %w", err))
+		}
+	}
+	if s.replayer != nil {
+		if err := s.replayer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close storage spool: %w", err))
+		}
+	}
+	if err := storage.Close(s.store); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close storage: %w", err))
+	}
+	// Export the spans of the last requests
+	if err := s.tracer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to export traces: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// createProxyHandler creates a proxy handler with the upstreams, retries and interceptors of the configuration. The
+// health checks of the upstreams are not started yet. Errors are returned instead of being fatal, so an invalid
+// configuration can be rejected when it is reloaded. The response histories are shared by all Responses API
+// interceptors.
+func createProxyHandler(cfg config.ProxyConfig, saving interceptor2.SavingInterceptor, histories *openai2.ResponseHistories) (*ProxyHandler, error) {
+	// Create upstreams, the default upstream serves all requests not matching any model specific upstream
+	if cfg.Upstream.Name == "" {
+		cfg.Upstream.Name = "default"
+	}
+	fallback, err := createUpstream(cfg.Upstream)
 	if err != nil {
-		logrus.WithError(err).Fatal("Failed to initialize storage")
+		return nil, fmt.Errorf("failed to create default upstream: %w", err)
 	}
-	if store != nil {
-		logrus.Info("Initialized storage backend")
+	router := NewUpstreamRouter(fallback)
+	if fallback != nil {
+		logrus.WithFields(logrus.Fields{
+			"backends": len(fallback.Backends),
+			"balancer": cfg.Upstream.Balancer,
+			"timeout":  fallback.Client.Timeout,
+		}).Info("Created default upstream")
 	}
 
 	// Create proxy handler
-	proxy, err := NewProxyHandler(cfg.Proxy.Upstream.URL, cfg.Proxy.Port, upstreamTimeout)
+	proxy := NewProxyHandlerWithRouter(router, cfg.Port)
+	proxy.Retry, err = createRetryPolicy(cfg.Retry)
 	if err != nil {
-		logrus.WithError(err).Fatal("Failed to create proxy handler")
+		return nil, fmt.Errorf("failed to create retry policy: %w", err)
+	}
+	logrus.WithField("port", cfg.Port).Info("Server configuration")
+	if proxy.Retry.Enabled() {
+		logrus.WithFields(logrus.Fields{
+			"max_attempts": proxy.Retry.MaxAttempts,
+			"backoff":      proxy.Retry.Backoff,
+			"status_codes": proxy.Retry.StatusCodes,
+			"failover":     proxy.Retry.Failover,
+		}).Info("Enabled retries of failed upstream requests")
+	}
+
+	// Register model specific upstreams
+	for _, uc := range cfg.Upstreams {
+		upstream, err := createUpstream(uc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create upstream '%s': %w", uc.Name, err)
+		}
+		if upstream == nil {
+			return nil, fmt.Errorf("upstream '%s' has neither a URL nor backends", uc.Name)
+		}
+		proxy.RegisterUpstream(upstream)
 	}
 
 	// Register interceptors based on configuration
-	for _, intercept := range cfg.Proxy.Intercepts {
-		interceptorInstance, err := CreateInterceptor(intercept.Interceptor, store, storageTimeout)
+	for _, intercept := range cfg.Intercepts {
+		interceptorInstance, err := newInterceptor(intercept, saving, histories)
 		if err != nil {
-			logrus.WithError(err).Fatal("Failed to create interceptor")
+			return nil, fmt.Errorf("failed to create interceptor: %w", err)
+		}
+		route := interceptor2.Route{Pattern: intercept.Endpoint, Method: intercept.Method, StripPrefix: intercept.StripPrefix}
+		if err := proxy.RegisterRoute(route, interceptorInstance); err != nil {
+			return nil, fmt.Errorf("failed to register interceptor %s: %w", intercept.Interceptor, err)
 		}
-		proxy.RegisterInterceptor(intercept.Endpoint, intercept.Method, interceptorInstance)
 		logrus.WithFields(logrus.Fields{
-			"interceptor": intercept.Interceptor,
-			"endpoint":    intercept.Endpoint,
-			"method":      intercept.Method,
+			"interceptor":  intercept.Interceptor,
+			"endpoint":     intercept.Endpoint,
+			"method":       intercept.Method,
+			"strip_prefix": intercept.StripPrefix,
 		}).Info("Registered interceptor")
 	}
-	if len(cfg.Proxy.Intercepts) == 0 {
+	if len(cfg.Intercepts) == 0 {
 		logrus.Println("No interceptors configured")
 	}
+	return proxy, nil
+}
 
-	// Create a custom server
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Proxy.Port),
-		Handler: proxy,
+// createMetrics creates the Prometheus metrics of the proxy unless they are disabled
+func createMetrics(cfg config.MetricsConfig, writer *interceptor2.StorageWriter, replayer *interceptor2.Replayer, retention *storage.RetentionJob) *Metrics {
+	if cfg.Disabled {
+		return nil
+	}
+	metrics := NewMetrics()
+	metrics.RegisterWriter(writer)
+	metrics.RegisterReplayer(replayer)
+	metrics.RegisterRetention(retention)
+	logrus.WithField("path", metricsPath(cfg)).Info("Serving Prometheus metrics")
+	return metrics
+}
+
+// createTracer creates the tracer exporting the spans of the proxied requests if tracing is enabled
+func createTracer(cfg config.TracingConfig) *Tracer {
+	if !cfg.Enabled {
+		return nil
+	}
+	tracer, err := NewTracer(cfg)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create tracer")
+	}
+	logrus.WithFields(logrus.Fields{
+		"endpoint":        cfg.Endpoint,
+		"capture_content": cfg.CaptureContent,
+	}).Info("Tracing requests with OpenTelemetry")
+	return tracer
+}
+
+// metricsPath returns the path of the metrics endpoint
+func metricsPath(cfg config.MetricsConfig) string {
+	if cfg.Path == "" {
+		return "/metrics"
+	}
+	return cfg.Path
+}
+
+// createUpstream creates an upstream pool from its configuration
+func createUpstream(cfg config.UpstreamConfig) (*Upstream, error) {
+	timeout, err := parseDurationStrict(cfg.Timeout, 30*time.Second, "upstream timeout")
+	if err != nil {
+		return nil, err
+	}
+	return NewUpstreamFromConfig(cfg, timeout)
+}
+
+// createRetryPolicy creates the retry policy from its configuration
+func createRetryPolicy(cfg config.RetryConfig) (RetryPolicy, error) {
+	backoff, err := parseDurationStrict(cfg.Backoff, 500*time.Millisecond, "retry backoff")
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	maxBackoff, err := parseDurationStrict(cfg.MaxBackoff, 10*time.Second, "retry max backoff")
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	policy := RetryPolicy{
+		MaxAttempts: max(cfg.MaxAttempts, 1),
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+		StatusCodes: cfg.StatusCodes,
+		Failover:    cfg.Failover,
 	}
+	if len(policy.StatusCodes) == 0 {
+		policy.StatusCodes = DefaultRetryStatusCodes
+	}
+	return policy, nil
+}
 
+// startMemoryAPI serves the API from the proxy if messages are stored in memory, as the conversations aren't
+// accessible by a separate API server in this case
+func startMemoryAPI(cfg config.Config, store storage.Storage) *http.Server {
+	memory, ok := store.(*storage.MemoryStorage)
+	if !ok || cfg.API.Port == 0 {
+		return nil
+	}
+	if cfg.API.Port == cfg.Proxy.Port {
+		logrus.Fatal("The API port must be different from the proxy port when messages are stored in memory")
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.API.Port),
+		Handler: api.NewAPIHandler(memory),
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).Fatal("API server failed")
+		}
+	}()
+	logrus.WithField("port", cfg.API.Port).Info("Serving the API of the in-memory storage")
 	return server
 }
 
-// CreateInterceptor creates an interceptor instance based on name
-func CreateInterceptor(name string, store storage.Storage, timeout time.Duration) (interceptor2.Interceptor, error) {
+// createReplayer creates the replayer of messages which could not be saved to storage if a spool is configured
+func createReplayer(cfg config.Storage, store storage.Storage, timeout time.Duration) *interceptor2.Replayer {
+	if store == nil || cfg.Spool == nil {
+		return nil
+	}
+	if cfg.Writer != nil && cfg.Writer.SpillDir == cfg.Spool.Dir {
+		logrus.Fatal("The storage spool and the spill directory of the storage writer must be different")
+	}
+	spool, err := interceptor2.NewSpool(cfg.Spool.Dir)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to open storage spool")
+	}
+	replayer := interceptor2.NewReplayer(store, spool, replayInterval(cfg), timeout)
+	replayer.Start()
+	logrus.WithFields(logrus.Fields{
+		"dir":     cfg.Spool.Dir,
+		"pending": replayer.Pending(),
+	}).Info("Opened storage spool")
+	return replayer
+}
+
+// replayInterval returns the interval of replaying spooled messages, which is also used to reconnect the storage
+func replayInterval(cfg config.Storage) time.Duration {
+	if cfg.Spool == nil {
+		return 10 * time.Second
+	}
+	return parseDuration(cfg.Spool.ReplayInterval, 10*time.Second, "spool replay interval")
+}
+
+// createRetentionJob creates and starts the job purging expired conversations if a retention policy is configured
+func createRetentionJob(cfg config.Storage, store storage.Storage) *storage.RetentionJob {
+	if store == nil || cfg.Retention == nil {
+		return nil
+	}
+	purger, ok := store.(storage.Purger)
+	if !ok {
+		logrus.Fatalf("Storage type '%s' doesn't support retention policies", cfg.Type)
+	}
+	policy := storage.RetentionPolicy{
+		MaxAge:           parseMaxAge(cfg.Retention.MaxAge),
+		MaxConversations: cfg.Retention.MaxConversations,
+		BatchSize:        cfg.Retention.BatchSize,
+	}
+	for _, rule := range cfg.Retention.Rules {
+		policy.Rules = append(policy.Rules, storage.RetentionRule{
+			Model:       rule.Model,
+			RequestType: rule.RequestType,
+			MaxAge:      parseMaxAge(rule.MaxAge),
+		})
+	}
+	interval := parseDuration(cfg.Retention.Interval, time.Hour, "retention interval")
+	job := storage.NewRetentionJob(purger, policy, interval)
+	job.Start()
+	logrus.WithFields(logrus.Fields{
+		"interval":          interval,
+		"max_age":           policy.MaxAge,
+		"max_conversations": policy.MaxConversations,
+		"rules":             len(policy.Rules),
+	}).Info("Started purging expired conversations")
+	return job
+}
+
+// parseMaxAge parses the maximum age of a retention policy, where an empty value means no limit. Unlike other
+// durations, an invalid value is fatal, as falling back to a default could delete conversations unexpectedly.
+func parseMaxAge(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.WithError(err).Fatalf("Failed to parse retention max age '%s'", value)
+	}
+	return d
+}
+
+// createStorageWriter creates the asynchronous storage writer if it is configured
+func createStorageWriter(cfg config.Storage, store storage.Storage, timeout time.Duration, replayer *interceptor2.Replayer) *interceptor2.StorageWriter {
+	if store == nil || cfg.Writer == nil {
+		return nil
+	}
+	options := interceptor2.WriterOptions{
+		QueueSize:     cfg.Writer.QueueSize,
+		Workers:       cfg.Writer.Workers,
+		BatchSize:     cfg.Writer.BatchSize,
+		FlushInterval: parseDuration(cfg.Writer.FlushInterval, 50*time.Millisecond, "storage writer flush interval"),
+		Timeout:       timeout,
+		Overflow:      interceptor2.OverflowPolicy(cfg.Writer.Overflow),
+		BlockTimeout:  parseDuration(cfg.Writer.BlockTimeout, time.Second, "storage writer block timeout"),
+		SpillDir:      cfg.Writer.SpillDir,
+		Replayer:      replayer,
+	}
+	if options.QueueSize == 0 {
+		options.QueueSize = 1000
+	}
+	if options.Workers == 0 {
+		options.Workers = 4
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = 32
+	}
+	writer, err := interceptor2.NewStorageWriter(store, options)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create storage writer")
+	}
+	logrus.WithFields(logrus.Fields{
+		"queue_size": options.QueueSize,
+		"workers":    options.Workers,
+		"batch_size": options.BatchSize,
+		"overflow":   options.Overflow,
+	}).Info("Created asynchronous storage writer")
+	return writer
+}
+
+// parseDuration parses a duration from the configuration, falling back to a default value
+func parseDuration(value string, defaultValue time.Duration, name string) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to parse %s '%s', using default %s", name, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// parseDurationStrict parses a duration of the proxy handler configuration, falling back to a default value if it is
+// empty. An invalid value is returned as error, so a reloaded configuration containing it is rejected.
+func parseDurationStrict(value string, defaultValue time.Duration, name string) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %w", name, value, err)
+	}
+	return d, nil
+}
+
+// CreateInterceptor creates an interceptor instance based on its configuration
+// The saving interceptor is the base of all interceptors which save messages to storage.
+// If a rewrite policy is configured, the interceptor is wrapped by a RewriteInterceptor.
+func CreateInterceptor(intercept config.Intercept, saving interceptor2.SavingInterceptor) (interceptor2.Interceptor, error) {
+	return newInterceptor(intercept, saving, nil)
+}
+
+// newInterceptor creates an interceptor like CreateInterceptor, a Responses API interceptor uses the given histories
+func newInterceptor(intercept config.Intercept, saving interceptor2.SavingInterceptor, histories *openai2.ResponseHistories) (interceptor2.Interceptor, error) {
+	if intercept.Rewrite == nil && intercept.Interceptor != "RewriteInterceptor" {
+		return createInterceptor(intercept, saving, histories)
+	}
+	rewrite := &interceptor2.RewriteInterceptor{Name: intercept.Interceptor}
+	if cfg := intercept.Rewrite; cfg != nil {
+		rewrite.Policy = interceptor2.RewritePolicy{
+			Format:           interceptor2.RequestFormat(cfg.Format),
+			Model:            cfg.Model,
+			Models:           cfg.Models,
+			SystemPrompt:     cfg.SystemPrompt,
+			SystemPromptMode: cfg.SystemPromptMode,
+			MaxTokens:        cfg.MaxTokens,
+			MaxTemperature:   cfg.MaxTemperature,
+			Options:          cfg.Options,
+			Strip:            cfg.Strip,
+		}
+		if err := rewrite.Policy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid rewrite for %s: %w", intercept.Interceptor, err)
+		}
+	}
+	if intercept.Interceptor != "RewriteInterceptor" {
+		next, err := createInterceptor(intercept, saving, histories)
+		if err != nil {
+			return nil, err
+		}
+		rewrite.Next = next
+	}
+	return rewrite, nil
+}
+
+// createInterceptor creates the interceptor of the given name
+func createInterceptor(intercept config.Intercept, saving interceptor2.SavingInterceptor, histories *openai2.ResponseHistories) (interceptor2.Interceptor, error) {
+	name := intercept.Interceptor
+	saving.Name = name
+	if len(intercept.Redact) > 0 {
+		rules := make([]interceptor2.RedactionRule, 0, len(intercept.Redact))
+		for _, rule := range intercept.Redact {
+			rules = append(rules, interceptor2.RedactionRule{
+				Name:    rule.Name,
+				Type:    rule.Type,
+				Pattern: rule.Pattern,
+				Action:  interceptor2.RedactionAction(rule.Action),
+			})
+		}
+		redactor, err := interceptor2.NewRedactor(rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction for %s: %w", name, err)
+		}
+		saving.Redactor = redactor
+	}
 	switch name {
 	case "CustomInterceptor":
 		return &interceptor2.CustomInterceptor{Name: name}, nil
@@ -85,27 +524,38 @@ func CreateInterceptor(name string, store storage.Storage, timeout time.Duration
 		return &interceptor2.LoggingInterceptor{Name: name}, nil
 	case "OllamaChatInterceptor":
 		return &ollama2.ChatInterceptor{
-			SavingInterceptor: interceptor2.SavingInterceptor{
-				Name:    name,
-				Storage: store,
-				Timeout: timeout,
-			},
+			SavingInterceptor: saving,
 		}, nil
 	case "OllamaGenerateInterceptor":
 		return &ollama2.GenerateInterceptor{
-			SavingInterceptor: interceptor2.SavingInterceptor{
-				Name:    name,
-				Storage: store,
-				Timeout: timeout,
-			},
+			SavingInterceptor: saving,
 		}, nil
 	case "OpenAIChatInterceptor":
 		return &openai2.ChatInterceptor{
-			SavingInterceptor: interceptor2.SavingInterceptor{
-				Name:    name,
-				Storage: store,
-				Timeout: timeout,
-			},
+			SavingInterceptor: saving,
+		}, nil
+	case "OllamaEmbedInterceptor":
+		return &ollama2.EmbedInterceptor{
+			SavingInterceptor: saving,
+			StoreVectors:      intercept.StoreVectors,
+		}, nil
+	case "OpenAICompletionInterceptor":
+		return &openai2.CompletionInterceptor{
+			SavingInterceptor: saving,
+		}, nil
+	case "OpenAIEmbeddingsInterceptor":
+		return &openai2.EmbeddingsInterceptor{
+			SavingInterceptor: saving,
+			StoreVectors:      intercept.StoreVectors,
+		}, nil
+	case "OpenAIResponsesInterceptor":
+		return &openai2.ResponsesInterceptor{
+			SavingInterceptor: saving,
+			Histories:         histories,
+		}, nil
+	case "AnthropicMessagesInterceptor":
+		return &anthropic2.MessagesInterceptor{
+			SavingInterceptor: saving,
 		}, nil
 	default:
 		return nil, fmt.Errorf("invalid interceptor type: %s", name)
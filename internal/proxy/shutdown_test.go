@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/storage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestServer_Shutdown_DrainsStreamsAndWrites(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	// The upstream holds the stream open until it is released
+	received := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"Hello"},"done":false}`)
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":" world"},"done":true,"done_reason":"stop"}`)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "llm-monitor.db")
+	cfg := config.Config{
+		Proxy: config.ProxyConfig{
+			Upstream: config.UpstreamConfig{URL: upstream.URL},
+			Intercepts: []config.Intercept{
+				{Endpoint: "/api/chat", Method: "POST", Interceptor: "OllamaChatInterceptor"},
+			},
+		},
+		Storage: config.Storage{
+			Type:   "sqlite",
+			SQLite: &config.SQLiteConfig{Path: path},
+			// Records are only written when the writer is flushed on shutdown
+			Writer: &config.WriterConfig{BatchSize: 100, FlushInterval: "1h"},
+		},
+	}
+	server := CreateServer(cfg, "")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	response := make(chan string)
+	go func() {
+		body := `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true}`
+		resp, err := http.Post("http://"+listener.Addr().String()+"/api/chat", "application/json", strings.NewReader(body))
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		content, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		response <- string(content)
+	}()
+	<-received
+
+	shutdown := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Expected the shutdown to wait for the stream, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		_ = conn.Close()
+		t.Errorf("Expected no new connections to be accepted")
+	}
+
+	close(release)
+	if content := <-response; !strings.Contains(content, `"done":true`) {
+		t.Errorf("Expected the stream to complete, got %q", content)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// The queued message has been written before the database was closed
+	if _, err := server.store.ListConversations(context.Background(), storage.ConversationFilter{}, storage.Pagination{Limit: 10}); err == nil {
+		t.Errorf("Expected the database to be closed")
+	}
+	store, err := storage.NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	conversations, err := store.ListConversations(context.Background(), storage.ConversationFilter{}, storage.Pagination{Limit: 10})
+	if err != nil || len(conversations) != 1 {
+		t.Errorf("Expected the conversation to be saved on shutdown, got %d (%v)", len(conversations), err)
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/proxy/interceptor"
+	"llm-monitor/internal/storage"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attributes and events of the OpenTelemetry semantic conventions for generative AI
+const (
+	genAIOperationName         = attribute.Key("gen_ai.operation.name")
+	genAISystem                = attribute.Key("gen_ai.system")
+	genAIRequestModel          = attribute.Key("gen_ai.request.model")
+	genAIRequestMaxTokens      = attribute.Key("gen_ai.request.max_tokens")
+	genAIRequestTemperature    = attribute.Key("gen_ai.request.temperature")
+	genAIRequestTopP           = attribute.Key("gen_ai.request.top_p")
+	genAIRequestTopK           = attribute.Key("gen_ai.request.top_k")
+	genAIResponseModel         = attribute.Key("gen_ai.response.model")
+	genAIResponseFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+	genAIUsageInputTokens      = attribute.Key("gen_ai.usage.input_tokens")
+	genAIUsageOutputTokens     = attribute.Key("gen_ai.usage.output_tokens")
+	genAIPrompt                = attribute.Key("gen_ai.prompt")
+	genAICompletion            = attribute.Key("gen_ai.completion")
+
+	genAIPromptEvent     = "gen_ai.content.prompt"
+	genAICompletionEvent = "gen_ai.content.completion"
+)
+
+// tracerName is the name of the instrumentation scope of the spans
+const tracerName = "llm-monitor/internal/proxy"
+
+// Tracer creates a span for every proxied request following the OpenTelemetry semantic conventions for generative
+// AI. The span continues the trace of an incoming traceparent header and is propagated to the upstream. Model, token
+// usage and finish reasons are taken from the record reported by the interceptor, whether or not it is saved. All
+// methods can safely be called on a nil tracer, which disables tracing.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	// captureContent adds the prompts and completions as events
+	captureContent bool
+	// shutdown flushes and stops the exporter
+	shutdown func(ctx context.Context) error
+}
+
+// NewTracer creates a tracer exporting spans via OTLP/HTTP
+func NewTracer(cfg config.TracingConfig) (*Tracer, error) {
+	var options []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		endpoint, err := url.Parse(cfg.Endpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid tracing endpoint '%s'", cfg.Endpoint)
+		}
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = "/v1/traces"
+		}
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint.String()))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "llm-monitor-proxy"
+	}
+	sampleRatio := 1.0
+	if cfg.SampleRatio != nil {
+		sampleRatio = *cfg.SampleRatio
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	return &Tracer{
+		tracer:         provider.Tracer(tracerName),
+		propagator:     propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+		captureContent: cfg.CaptureContent,
+		shutdown:       provider.Shutdown,
+	}, nil
+}
+
+// Shutdown exports the remaining spans and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.shutdown(ctx)
+}
+
+// start starts the span of a request, continuing the trace of the incoming headers
+func (t *Tracer) start(r *http.Request) (*http.Request, trace.Span) {
+	if t == nil {
+		return r, nil
+	}
+	ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer.Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// inject propagates the span of the request to the upstream
+func (t *Tracer) inject(req *http.Request) {
+	if t == nil {
+		return
+	}
+	t.propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// end ends the span of a request with the attributes of the record reported by the interceptor
+func (t *Tracer) end(span trace.Span, exchange *interceptor.Exchange, status int, err error) {
+	if t == nil {
+		return
+	}
+	defer span.End()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 400 {
+		span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(status)))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	record, ok := exchange.Record()
+	if !ok {
+		return
+	}
+	operation := genAIOperation(record.RequestType)
+	var requestModel, responseModel string
+	if len(record.History) > 0 {
+		requestModel = record.History[0].Model
+	}
+	if len(record.Choices) > 0 {
+		responseModel = record.Choices[0].Model
+	}
+	if requestModel == "" {
+		requestModel = responseModel
+	}
+	span.SetName(strings.TrimSpace(operation + " " + requestModel))
+	span.SetAttributes(
+		genAIOperationName.String(operation),
+		genAISystem.String(genAISystemOf(record.Interceptor)),
+		genAIRequestModel.String(requestModel),
+	)
+	if responseModel != "" {
+		span.SetAttributes(genAIResponseModel.String(responseModel))
+	}
+
+	var inputTokens, outputTokens int
+	var finishReasons []string
+	for _, choice := range record.Choices {
+		inputTokens += choice.PromptTokens
+		outputTokens += choice.CompletionTokens
+		if reason, ok := choice.Metadata["finish_reason"].(string); ok {
+			finishReasons = append(finishReasons, reason)
+		}
+	}
+	if inputTokens > 0 {
+		span.SetAttributes(genAIUsageInputTokens.Int(inputTokens))
+	}
+	if outputTokens > 0 {
+		span.SetAttributes(genAIUsageOutputTokens.Int(outputTokens))
+	}
+	if len(finishReasons) > 0 {
+		span.SetAttributes(genAIResponseFinishReasons.StringSlice(finishReasons))
+	}
+	if len(record.Choices) > 0 {
+		if parameters, ok := record.Choices[0].Metadata["parameters"].(map[string]any); ok {
+			span.SetAttributes(requestParameters(parameters)...)
+		}
+		if host, port, err := net.SplitHostPort(record.Choices[0].UpstreamHost); err == nil {
+			span.SetAttributes(semconv.ServerAddress(host))
+			if port, err := strconv.Atoi(port); err == nil {
+				span.SetAttributes(semconv.ServerPort(port))
+			}
+		} else if record.Choices[0].UpstreamHost != "" {
+			span.SetAttributes(semconv.ServerAddress(record.Choices[0].UpstreamHost))
+		}
+	}
+
+	if t.captureContent {
+		span.AddEvent(genAIPromptEvent, trace.WithAttributes(genAIPrompt.String(contentJSON(record.History))))
+		span.AddEvent(genAICompletionEvent, trace.WithAttributes(genAICompletion.String(contentJSON(record.Choices))))
+	}
+}
+
+// genAIOperation returns the operation name of a request type
+func genAIOperation(requestType string) string {
+	switch requestType {
+	case "completion", "generate":
+		return "text_completion"
+	case "embedding":
+		return "embeddings"
+	default:
+		return requestType
+	}
+}
+
+// genAISystem returns the provider of the API handled by an interceptor, e.g. "openai" for "OpenAIChatInterceptor"
+func genAISystemOf(interceptorName string) string {
+	name := strings.ToLower(interceptorName)
+	for _, system := range []string{"openai", "anthropic", "ollama"} {
+		if strings.HasPrefix(name, system) {
+			return system
+		}
+	}
+	return "_OTHER"
+}
+
+// requestParameters returns the attributes of the sampling parameters of a request
+func requestParameters(parameters map[string]any) []attribute.KeyValue {
+	var attributes []attribute.KeyValue
+	float := func(key attribute.Key, names ...string) {
+		for _, name := range names {
+			if value, ok := parameterNumber(parameters[name]); ok {
+				attributes = append(attributes, key.Float64(value))
+				return
+			}
+		}
+	}
+	integer := func(key attribute.Key, names ...string) {
+		for _, name := range names {
+			if value, ok := parameterNumber(parameters[name]); ok {
+				attributes = append(attributes, key.Int64(int64(value)))
+				return
+			}
+		}
+	}
+	float(genAIRequestTemperature, "temperature")
+	float(genAIRequestTopP, "top_p")
+	integer(genAIRequestTopK, "top_k")
+	integer(genAIRequestMaxTokens, "max_tokens", "max_completion_tokens", "max_output_tokens", "num_predict")
+	return attributes
+}
+
+// parameterNumber converts a numeric parameter decoded from JSON
+func parameterNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// contentJSON returns the roles and contents of the messages as JSON
+func contentJSON(messages []storage.SimpleMessage) string {
+	type content struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	contents := make([]content, len(messages))
+	for i, m := range messages {
+		contents[i] = content{Role: m.Role, Content: m.Content}
+	}
+	data, _ := json.Marshal(contents)
+	return string(data)
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/proxy/interceptor"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an OTLP/HTTP collector receiving the exported spans
+type collector struct {
+	*httptest.Server
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("Expected spans to be exported to /v1/traces, got %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var request coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &request); err != nil {
+			t.Errorf("Failed to decode the exported spans: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				c.spans = append(c.spans, scopeSpans.Spans...)
+			}
+		}
+		c.mu.Unlock()
+		response, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(response)
+	}))
+	return c
+}
+
+func (c *collector) Spans() []*tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spans
+}
+
+// attributes returns the attributes of a span as strings
+func attributes(span *tracepb.Span) map[string]string {
+	values := make(map[string]string)
+	for _, kv := range span.Attributes {
+		values[kv.Key] = attributeValue(kv.Value)
+	}
+	return values
+}
+
+func attributeValue(value *commonpb.AnyValue) string {
+	switch v := value.Value.(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return fmt.Sprint(v.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprint(v.DoubleValue)
+	case *commonpb.AnyValue_ArrayValue:
+		var values []string
+		for _, element := range v.ArrayValue.Values {
+			values = append(values, attributeValue(element))
+		}
+		return strings.Join(values, ",")
+	}
+	return value.String()
+}
+
+func TestTracer_TracesRequests(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	collector := newCollector(t)
+	defer collector.Close()
+
+	traceparent := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":"Hello"},"done":false}`)
+		w.(http.Flusher).Flush()
+		fmt.Fprintln(w, `{"model":"llama3","message":{"role":"assistant","content":" world"},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":30}`)
+	}))
+	defer upstream.Close()
+
+	tracer, err := NewTracer(config.TracingConfig{Enabled: true, Endpoint: collector.URL, CaptureContent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ph, _ := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	ph.Tracer = tracer
+	// The attributes don't depend on a storage
+	chat, err := CreateInterceptor(config.Intercept{Interceptor: "OllamaChatInterceptor"}, interceptor.SavingInterceptor{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ph.RegisterInterceptor("/api/chat", "POST", chat); err != nil {
+		t.Fatal(err)
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	body := `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"options":{"temperature":0.5,"num_predict":100}}`
+	r := httptest.NewRequest("POST", "/api/chat", strings.NewReader(body))
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	ph.ServeHTTP(httptest.NewRecorder(), r)
+
+	// The trace is propagated to the upstream
+	if header := <-traceparent; !strings.HasPrefix(header, "00-"+traceID+"-") || strings.Contains(header, "00f067aa0ba902b7") {
+		t.Errorf("Expected the upstream to receive the span of the proxy in trace %s, got %q", traceID, header)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := collector.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if hex.EncodeToString(span.TraceId) != traceID || hex.EncodeToString(span.ParentSpanId) != "00f067aa0ba902b7" {
+		t.Errorf("Expected the span to continue the incoming trace, got trace %x parent %x", span.TraceId, span.ParentSpanId)
+	}
+	if span.Name != "chat llama3" || span.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Errorf("Expected client span 'chat llama3', got %s '%s'", span.Kind, span.Name)
+	}
+	values := attributes(span)
+	for key, expected := range map[string]string{
+		"gen_ai.operation.name":          "chat",
+		"gen_ai.system":                  "ollama",
+		"gen_ai.request.model":           "llama3",
+		"gen_ai.response.model":          "llama3",
+		"gen_ai.request.temperature":     "0.5",
+		"gen_ai.request.max_tokens":      "100",
+		"gen_ai.usage.input_tokens":      "12",
+		"gen_ai.usage.output_tokens":     "30",
+		"gen_ai.response.finish_reasons": "stop",
+		"http.request.method":            "POST",
+		"http.response.status_code":      "200",
+	} {
+		if values[key] != expected {
+			t.Errorf("Expected attribute %s = %q, got %q", key, expected, values[key])
+		}
+	}
+
+	events := make(map[string]string)
+	for _, event := range span.Events {
+		for _, kv := range event.Attributes {
+			events[event.Name] += attributeValue(kv.Value)
+		}
+	}
+	if events["gen_ai.content.prompt"] != `[{"role":"user","content":"Hi"}]` {
+		t.Errorf("Expected the prompt event, got %q", events["gen_ai.content.prompt"])
+	}
+	if events["gen_ai.content.completion"] != `[{"role":"assistant","content":"Hello world"}]` {
+		t.Errorf("Expected the completion event, got %q", events["gen_ai.content.completion"])
+	}
+}
+
+func TestTracer_Disabled(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	// The incoming trace is passed through unchanged without a tracer
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	received := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	ph, _ := NewProxyHandler(upstream.URL, 8080, 30*time.Second)
+	r := httptest.NewRequest("GET", "/api/tags", nil)
+	r.Header.Set("traceparent", traceparent)
+	ph.ServeHTTP(httptest.NewRecorder(), r)
+	if header := <-received; header != traceparent {
+		t.Errorf("Expected traceparent %q, got %q", traceparent, header)
+	}
+
+	var disabled *Tracer
+	if err := disabled.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
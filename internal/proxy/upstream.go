@@ -0,0 +1,357 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"llm-monitor/internal/config"
+	"llm-monitor/internal/glob"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Backend represents a single server of an upstream pool
+type Backend struct {
+	URL    *url.URL
+	Weight int
+
+	healthy     atomic.Bool
+	outstanding atomic.Int64
+}
+
+// NewBackend creates a new backend for the given URL. Backends are considered healthy until a health check fails.
+func NewBackend(backendURL string, weight int) (*Backend, error) {
+	parsedURL, err := url.Parse(backendURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %v", err)
+	}
+	if parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL '%s': scheme and host are required", backendURL)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+
+	b := &Backend{
+		URL:    parsedURL,
+		Weight: weight,
+	}
+	b.healthy.Store(true)
+	return b, nil
+}
+
+// Healthy returns true if the backend is in rotation
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+// Outstanding returns the number of requests currently in flight to the backend
+func (b *Backend) Outstanding() int64 {
+	return b.outstanding.Load()
+}
+
+// Acquire marks the start of a request to the backend
+func (b *Backend) Acquire() {
+	b.outstanding.Add(1)
+}
+
+// Release marks the end of a request to the backend
+func (b *Backend) Release() {
+	b.outstanding.Add(-1)
+}
+
+// setHealthy updates the health status of the backend and logs changes
+func (b *Backend) setHealthy(healthy bool, reason string) {
+	if b.healthy.Swap(healthy) != healthy {
+		entry := logrus.WithFields(logrus.Fields{
+			"backend": b.URL.String(),
+			"reason":  reason,
+		})
+		if healthy {
+			entry.Info("Backend is healthy again, adding back into rotation")
+		} else {
+			entry.Warn("Backend is unhealthy, removing from rotation")
+		}
+	}
+}
+
+// HealthCheck describes the active health checks of an upstream pool
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Upstream represents a pool of upstream LLM servers together with its own HTTP client
+type Upstream struct {
+	Name        string
+	Backends    []*Backend
+	Models      []string
+	Client      *http.Client
+	Balancer    Balancer
+	HealthCheck *HealthCheck
+
+	patterns []*regexp.Regexp
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewUpstream creates a new upstream with a single backend for the given URL. The models are glob patterns,
+// where '*' matches any sequence of characters (including '/') and '?' matches a single character.
+func NewUpstream(name string, upstreamURL string, models []string, timeout time.Duration) (*Upstream, error) {
+	backend, err := NewBackend(upstreamURL, 1)
+	if err != nil {
+		return nil, err
+	}
+	return newUpstream(name, []*Backend{backend}, models, timeout, newRoundRobinBalancer()), nil
+}
+
+// NewUpstreamFromConfig creates a new upstream pool from its configuration. Returns nil if the configuration
+// contains neither a URL nor any backends.
+func NewUpstreamFromConfig(cfg config.UpstreamConfig, timeout time.Duration) (*Upstream, error) {
+	var backends []*Backend
+	if cfg.URL != "" {
+		backend, err := NewBackend(cfg.URL, 1)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	for _, bc := range cfg.Backends {
+		backend, err := NewBackend(bc.URL, bc.Weight)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	if len(backends) == 0 {
+		return nil, nil
+	}
+
+	balancer, err := NewBalancer(cfg.Balancer, backends)
+	if err != nil {
+		return nil, err
+	}
+
+	upstream := newUpstream(cfg.Name, backends, cfg.Models, timeout, balancer)
+
+	if cfg.HealthCheck != nil {
+		interval, err := parseDurationStrict(cfg.HealthCheck.Interval, 10*time.Second, "health check interval")
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := parseDurationStrict(cfg.HealthCheck.Timeout, 5*time.Second, "health check timeout")
+		if err != nil {
+			return nil, err
+		}
+		upstream.HealthCheck = &HealthCheck{
+			Path:     cfg.HealthCheck.Path,
+			Interval: interval,
+			Timeout:  timeout,
+		}
+		if upstream.HealthCheck.Path == "" {
+			upstream.HealthCheck.Path = "/"
+		}
+	}
+
+	return upstream, nil
+}
+
+func newUpstream(name string, backends []*Backend, models []string, timeout time.Duration, balancer Balancer) *Upstream {
+	patterns := make([]*regexp.Regexp, len(models))
+	for i, model := range models {
+		patterns[i] = glob.Compile(model)
+	}
+
+	if name == "" {
+		name = backends[0].URL.Host
+	}
+
+	return &Upstream{
+		Name:     name,
+		Backends: backends,
+		Models:   models,
+		Client: &http.Client{
+			Transport: createHttpTransport(),
+			Timeout:   timeout,
+		},
+		Balancer: balancer,
+		patterns: patterns,
+	}
+}
+
+// Matches returns true if the upstream is configured to serve the given model
+func (u *Upstream) Matches(model string) bool {
+	for _, pattern := range u.patterns {
+		if pattern.MatchString(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectBackend selects a healthy backend for a request from the given client. Excluded backends are
+// only selected if no other healthy backend is available. Returns nil if no healthy backend is available.
+func (u *Upstream) SelectBackend(clientKey string, exclude ...*Backend) *Backend {
+	candidates := make([]*Backend, 0, len(u.Backends))
+	fallbacks := make([]*Backend, 0, len(exclude))
+	for _, b := range u.Backends {
+		if !b.Healthy() {
+			continue
+		}
+		if slices.Contains(exclude, b) {
+			fallbacks = append(fallbacks, b)
+		} else {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallbacks
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return u.Balancer.Select(candidates, clientKey)
+}
+
+// ReportFailure takes a backend out of rotation after a connection error. This is only done when health checks
+// are enabled, since otherwise the backend would never be put back into rotation.
+func (u *Upstream) ReportFailure(backend *Backend, err error) {
+	if u.HealthCheck != nil {
+		backend.setHealthy(false, err.Error())
+	}
+}
+
+// Start starts the background health checks of the upstream, if configured
+func (u *Upstream) Start() {
+	if u.HealthCheck == nil || u.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+
+	client := &http.Client{
+		Transport: createHttpTransport(),
+		Timeout:   u.HealthCheck.Timeout,
+	}
+	for _, b := range u.Backends {
+		u.wg.Add(1)
+		go func(b *Backend) {
+			defer u.wg.Done()
+			u.runHealthCheck(ctx, client, b)
+		}(b)
+	}
+}
+
+// Close stops the background health checks of the upstream
+func (u *Upstream) Close() {
+	if u.cancel != nil {
+		u.cancel()
+		u.wg.Wait()
+		u.cancel = nil
+	}
+}
+
+func (u *Upstream) runHealthCheck(ctx context.Context, client *http.Client, b *Backend) {
+	ticker := time.NewTicker(u.HealthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		u.probe(ctx, client, b)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe performs a single health check of a backend
+func (u *Upstream) probe(ctx context.Context, client *http.Client, b *Backend) {
+	probeURL := b.URL.JoinPath(u.HealthCheck.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		b.setHealthy(false, err.Error())
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			b.setHealthy(false, err.Error())
+		}
+		return
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		b.setHealthy(true, resp.Status)
+	} else {
+		b.setHealthy(false, resp.Status)
+	}
+}
+
+// UpstreamRouter selects the upstream for a request based on the requested model
+type UpstreamRouter struct {
+	upstreams []*Upstream
+	fallback  *Upstream
+}
+
+// NewUpstreamRouter creates a new router with an optional fallback upstream
+func NewUpstreamRouter(fallback *Upstream) *UpstreamRouter {
+	return &UpstreamRouter{
+		fallback: fallback,
+	}
+}
+
+// AddUpstream adds an upstream to the router. Upstreams are matched in the order they were added.
+func (r *UpstreamRouter) AddUpstream(upstream *Upstream) {
+	r.upstreams = append(r.upstreams, upstream)
+}
+
+// RoutesByModel returns true if any upstream is selected by model name, which requires inspecting the request body
+func (r *UpstreamRouter) RoutesByModel() bool {
+	return len(r.upstreams) > 0
+}
+
+// Route returns the first upstream matching the model, or the fallback upstream if none matches.
+// Returns nil if no upstream is available for the model.
+func (r *UpstreamRouter) Route(model string) *Upstream {
+	if model != "" {
+		for _, upstream := range r.upstreams {
+			if upstream.Matches(model) {
+				return upstream
+			}
+		}
+	}
+	return r.fallback
+}
+
+// Start starts the health checks of all upstreams
+func (r *UpstreamRouter) Start() {
+	for _, upstream := range r.all() {
+		upstream.Start()
+	}
+}
+
+// Close stops the health checks of all upstreams
+func (r *UpstreamRouter) Close() {
+	for _, upstream := range r.all() {
+		upstream.Close()
+	}
+}
+
+func (r *UpstreamRouter) all() []*Upstream {
+	all := r.upstreams
+	if r.fallback != nil {
+		all = append([]*Upstream{r.fallback}, all...)
+	}
+	return all
+}
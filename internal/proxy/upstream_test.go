@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"llm-monitor/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestUpstreamRouter_Route(t *testing.T) {
+	fallback, err := NewUpstream("default", "http://localhost:11434", nil, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	vllm, err := NewUpstream("vllm", "http://vllm:8000", []string{"meta-llama/*", "qwen?.5-*"}, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	gpu, err := NewUpstream("", "http://gpu-host:11434", []string{"llama3*"}, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	if gpu.Name != "gpu-host:11434" {
+		t.Errorf("Expected upstream name to default to host, got %s", gpu.Name)
+	}
+
+	router := NewUpstreamRouter(fallback)
+	router.AddUpstream(vllm)
+	router.AddUpstream(gpu)
+
+	tests := []struct {
+		name     string
+		model    string
+		expected *Upstream
+	}{
+		{"Glob with slash", "meta-llama/Llama-3-8B", vllm},
+		{"Single character wildcard", "qwen2.5-coder", vllm},
+		{"Prefix match", "llama3.1:8b", gpu},
+		{"No match uses fallback", "mistral", fallback},
+		{"Empty model uses fallback", "", fallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := router.Route(tt.model); got != tt.expected {
+				t.Errorf("Route(%q) = %v, want %v", tt.model, got.Name, tt.expected.Name)
+			}
+		})
+	}
+
+	noFallback := NewUpstreamRouter(nil)
+	noFallback.AddUpstream(gpu)
+	if got := noFallback.Route("mistral"); got != nil {
+		t.Errorf("Expected no upstream without fallback, got %s", got.Name)
+	}
+}
+
+func TestNewUpstream_InvalidURL(t *testing.T) {
+	if _, err := NewUpstream("invalid", "localhost", nil, time.Second); err == nil {
+		t.Errorf("Expected error for URL without scheme")
+	}
+}
+
+func TestProxyHandler_RoutesByModel(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte(name + ":" + string(body)))
+		}))
+	}
+	defaultUpstream := newUpstream("default")
+	defer defaultUpstream.Close()
+	vllmUpstream := newUpstream("vllm")
+	defer vllmUpstream.Close()
+
+	ph, err := NewProxyHandler(defaultUpstream.URL, 8080, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	vllm, err := NewUpstream("vllm", vllmUpstream.URL, []string{"qwen*"}, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	ph.RegisterUpstream(vllm)
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"model":"qwen3:8b"}`, `vllm:{"model":"qwen3:8b"}`},
+		{`{"model":"llama3"}`, `default:{"model":"llama3"}`},
+		{`not json`, `default:not json`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/chat", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+		ph.ServeHTTP(w, req)
+
+		if w.Body.String() != tt.expected {
+			t.Errorf("Expected response %q, got %q", tt.expected, w.Body.String())
+		}
+	}
+}
+
+func TestUpstream_HealthCheck(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("Unexpected health check path %s", r.URL.Path)
+		}
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer sick.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+
+	upstream, err := NewUpstreamFromConfig(config.UpstreamConfig{
+		Backends: []config.BackendConfig{{URL: sick.URL}, {URL: ok.URL}},
+		HealthCheck: &config.HealthCheckConfig{
+			Path:     "/api/tags",
+			Interval: "10ms",
+		},
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create upstream: %v", err)
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("Timeout waiting for health check")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	healthy.Store(false)
+	waitFor(func() bool { return !upstream.Backends[0].Healthy() })
+	for i := 0; i < 5; i++ {
+		if got := upstream.SelectBackend(""); got != upstream.Backends[1] {
+			t.Errorf("Expected unhealthy backend to be out of rotation, got %s", got.URL)
+		}
+	}
+
+	healthy.Store(true)
+	waitFor(func() bool { return upstream.Backends[0].Healthy() })
+}
+
+func TestProxyHandler_NoHealthyBackend(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(nil)
+
+	ph, err := NewProxyHandler("http://localhost:1", 8080, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create proxy handler: %v", err)
+	}
+	ph.Router.Route("").Backends[0].setHealthy(false, "test")
+
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, httptest.NewRequest("GET", "/api/tags", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
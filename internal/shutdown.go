@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"llm-monitor/internal/config"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultShutdownTimeout is the deadline of the graceful shutdown if none is configured
+const DefaultShutdownTimeout = 30 * time.Second
+
+// forceExitDelay is the time after the shutdown deadline until the process exits if the shutdown still hangs, and
+// exit terminates the process. Both are replaced in tests.
+var (
+	forceExitDelay = 5 * time.Second
+	exit           = os.Exit
+)
+
+// Server is a server which can be shut down gracefully, like http.Server
+type Server interface {
+	Serve(listener net.Listener) error
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// ShutdownTimeout returns the configured deadline of the graceful shutdown
+func ShutdownTimeout(cfg config.Shutdown) time.Duration {
+	if cfg.Timeout == "" {
+		return DefaultShutdownTimeout
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil || timeout <= 0 {
+		logrus.WithError(err).Warnf("Invalid shutdown timeout '%s', using default %s", cfg.Timeout, DefaultShutdownTimeout)
+		return DefaultShutdownTimeout
+	}
+	return timeout
+}
+
+// Serve serves requests on the listener until the process receives SIGINT or SIGTERM, then shuts the server down
+// gracefully within the timeout. A second signal or a shutdown hanging past the deadline terminates the process.
+func Serve(server Server, listener net.Listener, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serve(ctx, stop, server, listener, timeout)
+}
+
+// serve serves requests until the context is done, then calls stop and shuts the server down
+func serve(ctx context.Context, stop context.CancelFunc, server Server, listener net.Listener, timeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	// Restore the default handling of the signals, so another one terminates the process right away
+	stop()
+	logrus.WithField("timeout", timeout).Info("Shutting down, waiting for in-flight requests to complete")
+
+	watchdog := time.AfterFunc(timeout+forceExitDelay, func() {
+		logrus.Error("Graceful shutdown did not complete in time, exiting")
+		exit(1)
+	})
+	defer watchdog.Stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		_ = server.Close()
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"llm-monitor/internal/config"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// slowServer serves requests which complete once they are released
+func slowServer(t *testing.T, release <-chan struct{}) (*http.Server, net.Listener, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan struct{}, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})}
+	return server, listener, received
+}
+
+func TestServe_WaitsForInFlightRequests(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	release := make(chan struct{})
+	server, listener, received := slowServer(t, release)
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- serve(ctx, stop, server, listener, 5*time.Second)
+	}()
+
+	response := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		response <- string(body)
+	}()
+	<-received
+
+	stop()
+	select {
+	case err := <-served:
+		t.Fatalf("Expected the in-flight request to be awaited, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if body := <-response; body != "done" {
+		t.Errorf("Expected the in-flight request to complete, got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected a graceful shutdown, got %v", err)
+	}
+}
+
+func TestServe_Deadline(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	release := make(chan struct{})
+	defer close(release)
+	server, listener, received := slowServer(t, release)
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- serve(ctx, stop, server, listener, 50*time.Millisecond)
+	}()
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-received
+
+	stop()
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Errorf("Expected the shutdown to fail after the deadline")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the shutdown to give up after the deadline")
+	}
+}
+
+// hangingServer is a server whose shutdown never completes
+type hangingServer struct {
+	served chan struct{}
+}
+
+func (s *hangingServer) Serve(_ net.Listener) error {
+	<-s.served
+	return http.ErrServerClosed
+}
+
+func (s *hangingServer) Shutdown(_ context.Context) error {
+	close(s.served)
+	select {}
+}
+
+func (s *hangingServer) Close() error {
+	return nil
+}
+
+func TestServe_ForcedExit(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	exited := make(chan int, 1)
+	defer func(delay time.Duration) {
+		exit, forceExitDelay = os.Exit, delay
+	}(forceExitDelay)
+	exit = func(code int) { exited <- code }
+	forceExitDelay = 10 * time.Millisecond
+
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+	go func() {
+		_ = serve(ctx, stop, &hangingServer{served: make(chan struct{})}, nil, 10*time.Millisecond)
+	}()
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("Expected exit code 1, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the process to exit after the deadline")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+
+	tests := map[string]time.Duration{
+		"":        DefaultShutdownTimeout,
+		"10s":     10 * time.Second,
+		"invalid": DefaultShutdownTimeout,
+		"-1s":     DefaultShutdownTimeout,
+	}
+	for value, expected := range tests {
+		if timeout := ShutdownTimeout(config.Shutdown{Timeout: value}); timeout != expected {
+			t.Errorf("ShutdownTimeout(%q) = %s, expected %s", value, timeout, expected)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package storage
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"llm-monitor/internal/glob"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStorage keeps conversations, branches, and messages in memory, using the same branching semantics as
+// PostgresStorage. If a maximum number of conversations is set, the least recently used conversations are evicted
+// once it is exceeded, which makes it suitable for running the proxy without a database.
+type MemoryStorage struct {
+	maxConversations int
+
+	mu            sync.Mutex
+	conversations map[uuid.UUID]*memoryConversation
+	branches      map[uuid.UUID]*Branch
+	messages      map[uuid.UUID]*memoryMessage
+	// hashes maps cumulative hashes to the IDs of the messages in the order they have been added
+	hashes map[string][]uuid.UUID
+	tools  map[string]*memoryTool
+	// lru orders the conversation IDs by their last use, the most recently used conversation first
+	lru *list.List
+	// order is incremented for every stored entity to sort entities created at the same time
+	order uint64
+}
+
+type memoryConversation struct {
+	Conversation
+	order    uint64
+	element  *list.Element
+	branches []uuid.UUID
+	messages []uuid.UUID
+}
+
+type memoryMessage struct {
+	Message
+	order    uint64
+	hash     string
+	children int
+}
+
+type memoryTool struct {
+	Tool
+	references int
+}
+
+// NewMemoryStorage creates an empty in-memory storage. If maxConversations is greater than zero, at most this number
+// of conversations is kept.
+func NewMemoryStorage(maxConversations int) *MemoryStorage {
+	return &MemoryStorage{
+		maxConversations: maxConversations,
+		conversations:    map[uuid.UUID]*memoryConversation{},
+		branches:         map[uuid.UUID]*Branch{},
+		messages:         map[uuid.UUID]*memoryMessage{},
+		hashes:           map[string][]uuid.UUID{},
+		tools:            map[string]*memoryTool{},
+		lru:              list.New(),
+	}
+}
+
+// CreateConversation creates a new conversation with the given metadata and returns the conversation and its initial
+// branch. The least recently used conversation is evicted if the maximum number of conversations is exceeded.
+func (s *MemoryStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*Conversation, *Branch, error) {
+	metadata, err := copyMetadata(metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.order++
+	conv := &memoryConversation{
+		Conversation: Conversation{ID: uuid.New(), CreatedAt: now, RequestType: requestType, Metadata: metadata},
+		order:        s.order,
+	}
+	branch := &Branch{ID: uuid.New(), ConversationID: conv.ID, CreatedAt: now}
+	conv.branches = append(conv.branches, branch.ID)
+	conv.element = s.lru.PushFront(conv.ID)
+	s.conversations[conv.ID] = conv
+	s.branches[branch.ID] = branch
+
+	for s.maxConversations > 0 && len(s.conversations) > s.maxConversations {
+		s.evict(s.lru.Back().Value.(uuid.UUID))
+	}
+
+	resultBranch := *branch
+	return conv.copy(), &resultBranch, nil
+}
+
+// GetConversation retrieves a conversation by its ID.
+// Returns nil if the conversation doesn't exist.
+func (s *MemoryStorage) GetConversation(ctx context.Context, id uuid.UUID) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[id]
+	if !ok {
+		return nil, nil
+	}
+	s.touch(conv)
+	return conv.copy(), nil
+}
+
+// AddMessage adds a new message to a conversation, forking a new branch if the parent message already has a child.
+func (s *MemoryStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *Message) (*Message, error) {
+	metadata, err := copyMetadata(message.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy message metadata: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var branch *Branch
+	var lastHash string
+	var lastSeq int
+
+	if parentMessageID != uuid.Nil {
+		parent, ok := s.messages[parentMessageID]
+		if !ok {
+			return nil, fmt.Errorf("parent message %s not found", parentMessageID)
+		}
+		branch = s.branches[parent.BranchID]
+		lastHash = parent.hash
+		lastSeq = parent.SequenceNumber
+
+		if parent.children > 0 {
+			// Fork a new branch from the parent message
+			parentBranchID := branch.ID
+			parentID := parent.ID
+			branch = &Branch{
+				ID:              uuid.New(),
+				ConversationID:  branch.ConversationID,
+				ParentBranchID:  &parentBranchID,
+				ParentMessageID: &parentID,
+				CreatedAt:       now,
+			}
+			s.branches[branch.ID] = branch
+			conv := s.conversations[branch.ConversationID]
+			conv.branches = append(conv.branches, branch.ID)
+			parent.ChildBranchIDs = append(parent.ChildBranchIDs, branch.ID)
+		}
+		parent.children++
+	} else {
+		// Without a parent, this is the first message of the branch given by the message
+		if message.BranchID == uuid.Nil {
+			return nil, fmt.Errorf("branchID is required when parentMessageID is empty")
+		}
+		var ok bool
+		branch, ok = s.branches[message.BranchID]
+		if !ok {
+			return nil, fmt.Errorf("branch %s not found", message.BranchID)
+		}
+	}
+
+	s.order++
+	m := &memoryMessage{
+		Message: Message{
+			SimpleMessage:      message.SimpleMessage,
+			ID:                 uuid.New(),
+			ConversationID:     branch.ConversationID,
+			BranchID:           branch.ID,
+			SequenceNumber:     lastSeq + 1,
+			CreatedAt:          now,
+			ParentMessageID:    optionalUUID(parentMessageID),
+			UpstreamStatusCode: message.UpstreamStatusCode,
+			UpstreamError:      message.UpstreamError,
+		},
+		order: s.order,
+		hash:  computeHash(lastHash, message.Role, message.Content),
+	}
+	m.Metadata = metadata
+	// Like the database storages, only the tool calls of a message are stored, not the ID of the answered call
+	m.ToolCallID = ""
+	m.ToolCalls = slices.Clone(message.ToolCalls)
+	m.Tools = nil
+	for _, tool := range message.Tools {
+		m.Tools = append(m.Tools, s.addTool(tool))
+	}
+
+	s.messages[m.ID] = m
+	s.hashes[m.hash] = append(s.hashes[m.hash], m.ID)
+	conv := s.conversations[branch.ConversationID]
+	conv.messages = append(conv.messages, m.ID)
+	s.touch(conv)
+
+	return m.copy(), nil
+}
+
+// GetBranchHistory retrieves the complete history of messages for a given branch.
+func (s *MemoryStorage) GetBranchHistory(ctx context.Context, branchID uuid.UUID) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	branch, ok := s.branches[branchID]
+	if !ok {
+		return nil, nil
+	}
+	conv := s.conversations[branch.ConversationID]
+	s.touch(conv)
+
+	// Collect the messages of the branch and the messages of its ancestors up to the fork points
+	maxSeq := map[uuid.UUID]int{branch.ID: -1}
+	for b := branch; b.ParentBranchID != nil; b = s.branches[*b.ParentBranchID] {
+		maxSeq[*b.ParentBranchID] = s.messages[*b.ParentMessageID].SequenceNumber
+	}
+	var history []Message
+	for _, id := range conv.messages {
+		m := s.messages[id]
+		if limit, ok := maxSeq[m.BranchID]; ok && (limit < 0 || m.SequenceNumber <= limit) {
+			history = append(history, *m.copy())
+		}
+	}
+	slices.SortStableFunc(history, func(a, b Message) int {
+		return a.SequenceNumber - b.SequenceNumber
+	})
+	return history, nil
+}
+
+// FindMessageByHistory returns the ID of the most recent message with the given history within a specific request
+// type, or uuid.Nil if there is none.
+func (s *MemoryStorage) FindMessageByHistory(ctx context.Context, history []SimpleMessage, requestType string) (uuid.UUID, error) {
+	if len(history) == 0 {
+		return uuid.Nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.hashes[computeHistoryHash(history)]
+	for i := len(ids) - 1; i >= 0; i-- {
+		m := s.messages[ids[i]]
+		if s.conversations[m.ConversationID].RequestType == requestType {
+			return m.ID, nil
+		}
+	}
+	return uuid.Nil, nil
+}
+
+// ListConversations retrieves a paginated list of the active or archived conversations with their first messages,
+// most recent first.
+func (s *MemoryStorage) ListConversations(ctx context.Context, filter ConversationFilter, p Pagination) ([]ConversationOverview, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversations := slices.SortedFunc(maps.Values(s.conversations), func(a, b *memoryConversation) int {
+		return cmp.Compare(b.order, a.order)
+	})
+	conversations = slices.DeleteFunc(conversations, func(conv *memoryConversation) bool {
+		return (conv.ArchivedAt != nil) != filter.Archived
+	})
+	var overviews []ConversationOverview
+	for _, conv := range paginate(conversations, p) {
+		o := ConversationOverview{Conversation: *conv.copy(), BranchCount: len(conv.branches)}
+		for _, id := range conv.messages {
+			m := s.messages[id]
+			o.ToolCallCount += len(m.ToolCalls)
+			if m.Role == "system" {
+				if o.SystemPrompt == nil || m.SequenceNumber < o.SystemPrompt.SequenceNumber {
+					o.SystemPrompt = m.copy()
+				}
+			} else if o.FirstMessage == nil || m.SequenceNumber < o.FirstMessage.SequenceNumber {
+				o.FirstMessage = m.copy()
+			}
+		}
+		overviews = append(overviews, o)
+	}
+	return overviews, nil
+}
+
+// SearchMessages searches for messages containing the query string of the filter and matching its metadata values,
+// most recent first.
+func (s *MemoryStorage) SearchMessages(ctx context.Context, filter MessageFilter, p Pagination) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	var matches []*memoryMessage
+	for _, m := range s.messages {
+		if query != "" && !strings.Contains(strings.ToLower(m.Content), query) {
+			continue
+		}
+		if !matchesMetadata(m.Metadata, filter.Metadata) {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	slices.SortFunc(matches, func(a, b *memoryMessage) int {
+		return cmp.Compare(b.order, a.order)
+	})
+
+	var messages []Message
+	for _, m := range paginate(matches, p) {
+		messages = append(messages, *m.copy())
+	}
+	return messages, nil
+}
+
+// GetConversationMessages retrieves messages for the initial branch of a given conversation ID.
+func (s *MemoryStorage) GetConversationMessages(ctx context.Context, conversationID uuid.UUID) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	s.touch(conv)
+
+	var messages []Message
+	for _, id := range conv.messages {
+		m := s.messages[id]
+		if s.branches[m.BranchID].ParentBranchID == nil {
+			messages = append(messages, *m.copy())
+		}
+	}
+	slices.SortStableFunc(messages, func(a, b Message) int {
+		return a.SequenceNumber - b.SequenceNumber
+	})
+	return messages, nil
+}
+
+// GetBranch retrieves a branch by its ID.
+// Returns nil if the branch doesn't exist.
+func (s *MemoryStorage) GetBranch(ctx context.Context, branchID uuid.UUID) (*Branch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	branch, ok := s.branches[branchID]
+	if !ok {
+		return nil, nil
+	}
+	result := *branch
+	return &result, nil
+}
+
+// DeleteConversation deletes a conversation with all its branches and messages.
+func (s *MemoryStorage) DeleteConversation(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conversations[id]; !ok {
+		return ErrNotFound
+	}
+	s.evict(id)
+	return nil
+}
+
+// DeleteBranch deletes a branch with its messages and all branches forked from it, and removes it from the child
+// branches of the message it has been forked from.
+func (s *MemoryStorage) DeleteBranch(ctx context.Context, branchID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	branch, ok := s.branches[branchID]
+	if !ok {
+		return ErrNotFound
+	}
+	if branch.ParentMessageID == nil {
+		return ErrInitialBranch
+	}
+	conv := s.conversations[branch.ConversationID]
+
+	// Collect the branch and its descendants, forks always come after the branches they are forked from
+	deleted := map[uuid.UUID]bool{branchID: true}
+	for _, id := range conv.branches {
+		if b := s.branches[id]; b.ParentBranchID != nil && deleted[*b.ParentBranchID] {
+			deleted[id] = true
+		}
+	}
+
+	parent := s.messages[*branch.ParentMessageID]
+	parent.ChildBranchIDs = slices.DeleteFunc(parent.ChildBranchIDs, func(id uuid.UUID) bool { return id == branchID })
+	var messages []*memoryMessage
+	conv.messages = slices.DeleteFunc(conv.messages, func(id uuid.UUID) bool {
+		m := s.messages[id]
+		if !deleted[m.BranchID] {
+			return false
+		}
+		messages = append(messages, m)
+		return true
+	})
+	for _, m := range messages {
+		if m.ParentMessageID != nil {
+			if p := s.messages[*m.ParentMessageID]; !deleted[p.BranchID] {
+				p.children--
+			}
+		}
+	}
+	for _, m := range messages {
+		s.removeMessage(m)
+	}
+	conv.branches = slices.DeleteFunc(conv.branches, func(id uuid.UUID) bool {
+		if deleted[id] {
+			delete(s.branches, id)
+			return true
+		}
+		return false
+	})
+	s.touch(conv)
+	return nil
+}
+
+// ArchiveConversation hides a conversation from the list of active conversations, keeping the time it has been
+// archived first.
+func (s *MemoryStorage) ArchiveConversation(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if conv.ArchivedAt == nil {
+		now := time.Now()
+		conv.ArchivedAt = &now
+	}
+	return nil
+}
+
+// RestoreConversation moves an archived conversation back to the list of active conversations.
+func (s *MemoryStorage) RestoreConversation(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, ok := s.conversations[id]
+	if !ok {
+		return ErrNotFound
+	}
+	conv.ArchivedAt = nil
+	return nil
+}
+
+// touch marks a conversation as most recently used
+func (s *MemoryStorage) touch(conv *memoryConversation) {
+	s.lru.MoveToFront(conv.element)
+}
+
+// evict removes a conversation together with its branches and messages
+func (s *MemoryStorage) evict(id uuid.UUID) {
+	conv := s.conversations[id]
+	for _, messageID := range conv.messages {
+		s.removeMessage(s.messages[messageID])
+	}
+	for _, branchID := range conv.branches {
+		delete(s.branches, branchID)
+	}
+	s.lru.Remove(conv.element)
+	delete(s.conversations, id)
+}
+
+// removeMessage removes a message together with its hash and tool references
+func (s *MemoryStorage) removeMessage(m *memoryMessage) {
+	s.hashes[m.hash] = slices.DeleteFunc(s.hashes[m.hash], func(other uuid.UUID) bool { return other == m.ID })
+	if len(s.hashes[m.hash]) == 0 {
+		delete(s.hashes, m.hash)
+	}
+	for _, tool := range m.Tools {
+		s.removeTool(tool)
+	}
+	delete(s.messages, m.ID)
+}
+
+// addTool returns the stored tool with the same hash, storing it if it is new
+func (s *MemoryStorage) addTool(tool Tool) Tool {
+	hash := computeToolHash(tool)
+	stored, ok := s.tools[hash]
+	if !ok {
+		tool.ID = uuid.New()
+		tool.Parameters = slices.Clone(tool.Parameters)
+		stored = &memoryTool{Tool: tool}
+		s.tools[hash] = stored
+	}
+	stored.references++
+	return stored.Tool
+}
+
+// removeTool removes a reference to a tool, deleting the tool once it isn't referenced anymore
+func (s *MemoryStorage) removeTool(tool Tool) {
+	hash := computeToolHash(tool)
+	if stored, ok := s.tools[hash]; ok {
+		stored.references--
+		if stored.references <= 0 {
+			delete(s.tools, hash)
+		}
+	}
+}
+
+// copy returns a copy of the conversation which can be modified by the caller
+func (c *memoryConversation) copy() *Conversation {
+	result := c.Conversation
+	result.Metadata, _ = copyMetadata(c.Metadata)
+	if c.ArchivedAt != nil {
+		archivedAt := *c.ArchivedAt
+		result.ArchivedAt = &archivedAt
+	}
+	return &result
+}
+
+// copy returns a copy of the message which can be modified by the caller
+func (m *memoryMessage) copy() *Message {
+	result := m.Message
+	result.ChildBranchIDs = slices.Clone(m.ChildBranchIDs)
+	result.Tools = slices.Clone(m.Tools)
+	result.ToolCalls = slices.Clone(m.ToolCalls)
+	result.Metadata, _ = copyMetadata(m.Metadata)
+	return &result
+}
+
+// copyMetadata returns a deep copy of metadata, normalizing all values to their JSON representation like they would be
+// read from a database
+func copyMetadata(metadata map[string]any) (map[string]any, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]any
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// matchesMetadata reports whether the values at the dot separated paths of the filter match the JSON text
+// representations of the metadata values
+func matchesMetadata(metadata map[string]any, filter map[string]string) bool {
+	for path, expected := range filter {
+		var value any = metadata
+		for _, key := range strings.Split(path, ".") {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return false
+			}
+			value = object[key]
+		}
+		switch v := value.(type) {
+		case nil:
+			return false
+		case string:
+			if v != expected {
+				return false
+			}
+		case float64:
+			if strconv.FormatFloat(v, 'f', -1, 64) != expected {
+				return false
+			}
+		default:
+			data, err := json.Marshal(v)
+			if err != nil || string(data) != expected {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// paginate returns the page of items selected by the pagination
+func paginate[T any](items []T, p Pagination) []T {
+	if p.Offset >= len(items) {
+		return nil
+	}
+	items = items[max(p.Offset, 0):]
+	if p.Limit > 0 && p.Limit < len(items) {
+		items = items[:p.Limit]
+	}
+	return items
+}
+
+// Purge deletes the conversations expired according to the retention policy. Tools are deleted together with the
+// last message using them.
+func (s *MemoryStorage) Purge(ctx context.Context, policy RetentionPolicy, now time.Time) (PurgeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	models := make([]*regexp.Regexp, len(policy.Rules))
+	for i, rule := range policy.Rules {
+		if rule.Model != "" {
+			models[i] = glob.Compile(rule.Model)
+		}
+	}
+
+	var result PurgeResult
+	tools := len(s.tools)
+	for id, conv := range s.conversations {
+		if s.expired(conv, policy, models, now) {
+			s.evict(id)
+			result.Expired++
+		}
+	}
+	if policy.MaxConversations > 0 && len(s.conversations) > policy.MaxConversations {
+		conversations := slices.SortedFunc(maps.Values(s.conversations), func(a, b *memoryConversation) int {
+			return cmp.Compare(b.order, a.order)
+		})
+		for _, conv := range conversations[policy.MaxConversations:] {
+			s.evict(conv.ID)
+			result.Exceeded++
+		}
+	}
+	result.Tools = int64(tools - len(s.tools))
+	return result, nil
+}
+
+// expired reports whether the last message of a conversation is older than the maximum age of the first matching rule
+// of the policy, or the maximum age of the policy if no rule matches. models contains the compiled model patterns of
+// the rules.
+func (s *MemoryStorage) expired(conv *memoryConversation, policy RetentionPolicy, models []*regexp.Regexp, now time.Time) bool {
+	lastActivity := conv.CreatedAt
+	for _, id := range conv.messages {
+		lastActivity = later(lastActivity, s.messages[id].CreatedAt)
+	}
+
+	maxAge := policy.MaxAge
+	for i, rule := range policy.Rules {
+		if rule.RequestType != "" && rule.RequestType != conv.RequestType {
+			continue
+		}
+		if models[i] != nil && !slices.ContainsFunc(conv.messages, func(id uuid.UUID) bool {
+			return models[i].MatchString(s.messages[id].Model)
+		}) {
+			continue
+		}
+		maxAge = rule.MaxAge
+		break
+	}
+	return maxAge > 0 && lastActivity.Before(now.Add(-maxAge))
+}
+
+// later returns the later of two times
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// memoryInspector inspects the data of a MemoryStorage
+type memoryInspector struct {
+	s *MemoryStorage
+}
+
+func (i memoryInspector) countTools() (int, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	return len(i.s.tools), nil
+}
+
+func (i memoryInspector) addToolCall(messageID uuid.UUID, call ToolCall) error {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	m, ok := i.s.messages[messageID]
+	if !ok {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+	m.ToolCalls = append(m.ToolCalls, call)
+	return nil
+}
+
+func TestMemoryStorage_Branching(t *testing.T) {
+	storage := NewMemoryStorage(0)
+	testStorageBranching(t, storage, memoryInspector{storage})
+}
+
+func TestMemoryStorage_SearchMessagesByMetadata(t *testing.T) {
+	testStorageSearchMessagesByMetadata(t, NewMemoryStorage(0))
+}
+
+func TestMemoryStorage_Tools(t *testing.T) {
+	storage := NewMemoryStorage(0)
+	testStorageTools(t, storage, memoryInspector{storage})
+}
+
+func TestMemoryStorage_Purge(t *testing.T) {
+	storage := NewMemoryStorage(0)
+	testStoragePurge(t, storage, memoryInspector{storage})
+}
+
+func TestMemoryStorage_DeleteAndArchive(t *testing.T) {
+	testStorageDeleteAndArchive(t, NewMemoryStorage(0))
+}
+
+func TestMemoryStorage_EvictsLeastRecentlyUsed(t *testing.T) {
+	storage := NewMemoryStorage(2)
+	ctx := context.Background()
+
+	var convs []uuid.UUID
+	for _, content := range []string{"first", "second"} {
+		conv, branch, err := storage.CreateConversation(ctx, nil, "chat")
+		if err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+		tool := Tool{Name: "tool_" + content}
+		if _, err := storage.AddMessage(ctx, uuid.Nil, &Message{BranchID: branch.ID, SimpleMessage: SimpleMessage{Role: "user", Content: content, Tools: []Tool{tool}}}); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		convs = append(convs, conv.ID)
+	}
+
+	// Reading the first conversation makes the second one the least recently used
+	if _, err := storage.GetConversationMessages(ctx, convs[0]); err != nil {
+		t.Fatalf("Failed to get messages: %v", err)
+	}
+	if _, _, err := storage.CreateConversation(ctx, nil, "chat"); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	if conv, _ := storage.GetConversation(ctx, convs[1]); conv != nil {
+		t.Errorf("Expected second conversation to be evicted")
+	}
+	if conv, _ := storage.GetConversation(ctx, convs[0]); conv == nil {
+		t.Errorf("Expected first conversation to be kept")
+	}
+	id, err := storage.FindMessageByHistory(ctx, []SimpleMessage{{Role: "user", Content: "second"}}, "chat")
+	if err != nil || id != uuid.Nil {
+		t.Errorf("Expected messages of the evicted conversation to be removed, got %s (%v)", id, err)
+	}
+	if count, _ := (memoryInspector{storage}).countTools(); count != 1 {
+		t.Errorf("Expected only the tool of the kept conversation, got %d tools", count)
+	}
+	overviews, err := storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 10})
+	if err != nil || len(overviews) != 2 {
+		t.Errorf("Expected 2 conversations, got %d (%v)", len(overviews), err)
+	}
+}
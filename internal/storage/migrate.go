@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"llm-monitor/internal/config"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockKey is the key of the PostgreSQL advisory lock held while migrating
+const migrationLockKey int64 = 0x6c6c6d6d6f6e6974
+
+// Migration is a versioned change of the database schema.
+// Migrations are stored as files named "<version>_<name>.sql" in migrations/<dialect>.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus is a migration together with the time it has been applied, which is nil if it is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies the migrations of a dialect ("postgres" or "sqlite") to a database.
+// The applied versions are recorded in the schema_version table.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator applying the embedded migrations of the dialect to the database.
+func NewMigrator(db *sql.DB, dialect string) (*Migrator, error) {
+	return newMigrator(db, dialect, migrationFiles)
+}
+
+// newMigrator creates a migrator reading the migrations from migrations/<dialect> of fsys
+func newMigrator(db *sql.DB, dialect string, fsys fs.FS) (*Migrator, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations of %s: %w", dialect, err)
+	}
+
+	m := &Migrator{db: db, dialect: dialect}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		prefix, name, _ := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m.migrations = append(m.migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+	slices.SortFunc(m.migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	for i := 1; i < len(m.migrations); i++ {
+		if m.migrations[i].Version == m.migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.migrations[i].Version)
+		}
+	}
+	return m, nil
+}
+
+// Status returns all migrations in the order they are applied, together with the time they have been applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	applied, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			s.AppliedAt = &appliedAt
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Pending returns the migrations which haven't been applied yet, in the order they would be applied.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all pending migrations and returns them. Each migration runs in its own transaction. On PostgreSQL, an
+// advisory lock keeps processes started at the same time from migrating concurrently.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if m.dialect == "postgres" {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer func() {
+			_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		}()
+	}
+
+	if err := m.createVersionTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, migration := range m.migrations {
+		ok, err := m.apply(ctx, conn, migration)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if ok {
+			logrus.WithFields(logrus.Fields{
+				"version": migration.Version,
+				"name":    migration.Name,
+			}).Info("Applied database migration")
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// apply applies a migration unless it has already been applied, and reports whether it has been applied now
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	// Check again within the transaction, as another process may have applied the migration in the meantime
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_version WHERE version = $1)", migration.Version).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version, applied_at) VALUES ($1, $2)", migration.Version, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// createVersionTable creates the table of the applied versions if it doesn't exist
+func (m *Migrator) createVersionTable(ctx context.Context, conn *sql.Conn) error {
+	timestampType := "TIMESTAMP WITH TIME ZONE"
+	if m.dialect == "sqlite" {
+		timestampType = "TIMESTAMP"
+	}
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INT PRIMARY KEY,
+		applied_at %s DEFAULT CURRENT_TIMESTAMP
+	)`, timestampType))
+	return err
+}
+
+// appliedVersions returns the applied versions with the times they have been applied
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'schema_version')"
+	if m.dialect == "sqlite" {
+		query = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')"
+	}
+	if err := conn.QueryRowContext(ctx, query).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]time.Time{}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var version int
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.Time
+	}
+	return applied, rows.Err()
+}
+
+// Close closes the database of the migrator.
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// OpenMigrator opens the database configured for the storage without initializing it, and creates a migrator for it.
+// The database is closed by closing the migrator.
+func OpenMigrator(cfg config.Storage) (*Migrator, error) {
+	var db *sql.DB
+	var err error
+	switch {
+	case cfg.Type == "postgres" && cfg.Postgres != nil:
+		db, err = sql.Open("postgres", cfg.Postgres.DSN)
+	case cfg.Type == "sqlite" && cfg.SQLite != nil:
+		db, err = openSQLite(cfg.SQLite.Path)
+	default:
+		return nil, errors.New("migrations require a PostgreSQL or SQLite storage")
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMigrator(db, cfg.Type)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// migrateSchema applies the pending migrations of the dialect when a storage is created
+func migrateSchema(ctx context.Context, db *sql.DB, dialect string) error {
+	m, err := NewMigrator(db, dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		logrus.WithField("version", m.migrations[len(m.migrations)-1].Version).Info("Database schema is up to date")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestMigrator creates a migrator for a new in-memory SQLite database and the given migration files
+func newTestMigrator(t *testing.T, files fstest.MapFS) *Migrator {
+	db, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	m, err := newMigrator(db, "sqlite", files)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	return m
+}
+
+func TestMigrator_AppliesPendingMigrationsInOrder(t *testing.T) {
+	files := fstest.MapFS{
+		"migrations/sqlite/0002_add_color.sql": {Data: []byte("ALTER TABLE things ADD COLUMN color TEXT;")},
+		"migrations/sqlite/0001_create.sql":    {Data: []byte("CREATE TABLE things (id INT PRIMARY KEY);")},
+	}
+	m := newTestMigrator(t, files)
+	ctx := context.Background()
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get pending migrations: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Version != 1 || pending[1].Version != 2 || pending[1].Name != "add_color" {
+		t.Fatalf("Unexpected pending migrations: %+v", pending)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("Expected 2 applied migrations, got %d", len(applied))
+	}
+	if _, err := m.db.Exec("INSERT INTO things (id, color) VALUES (1, 'red')"); err != nil {
+		t.Errorf("Expected migrated table: %v", err)
+	}
+
+	// A new migration is applied to the existing database, the others are skipped
+	files["migrations/sqlite/0003_add_size.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE things ADD COLUMN size INT;")}
+	m, err = newMigrator(m.db, "sqlite", files)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	applied, err = m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 3 {
+		t.Errorf("Expected only migration 3 to be applied, got %+v", applied)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	for _, s := range status {
+		if s.AppliedAt == nil || s.AppliedAt.IsZero() {
+			t.Errorf("Expected migration %d to be applied", s.Version)
+		}
+	}
+}
+
+func TestMigrator_FailedMigrationIsRolledBack(t *testing.T) {
+	m := newTestMigrator(t, fstest.MapFS{
+		"migrations/sqlite/0001_create.sql": {Data: []byte("CREATE TABLE things (id INT PRIMARY KEY);")},
+		"migrations/sqlite/0002_broken.sql": {Data: []byte("CREATE TABLE others (id INT); ALTER TABLE missing ADD COLUMN x INT;")},
+	})
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	if err == nil {
+		t.Fatalf("Expected migration to fail")
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Errorf("Expected only migration 1 to be applied, got %+v", applied)
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get pending migrations: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Version != 2 {
+		t.Errorf("Expected migration 2 to be pending, got %+v", pending)
+	}
+	var exists bool
+	if err := m.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'others')").Scan(&exists); err != nil || exists {
+		t.Errorf("Expected the failed migration to be rolled back (%v)", err)
+	}
+}
+
+func TestMigrator_EmbeddedMigrations(t *testing.T) {
+	postgres, err := NewMigrator(nil, "postgres")
+	if err != nil {
+		t.Fatalf("Failed to load PostgreSQL migrations: %v", err)
+	}
+	sqlite, err := NewMigrator(nil, "sqlite")
+	if err != nil {
+		t.Fatalf("Failed to load SQLite migrations: %v", err)
+	}
+	if len(postgres.migrations) == 0 || postgres.migrations[0].Version != 9 {
+		t.Errorf("Expected the migrations to start with the initial schema, got %+v", postgres.migrations)
+	}
+
+	// Every schema change is migrated for both databases
+	if len(postgres.migrations) != len(sqlite.migrations) {
+		t.Fatalf("Expected the same migrations for both databases, got %d and %d", len(postgres.migrations), len(sqlite.migrations))
+	}
+	for i := range postgres.migrations {
+		if postgres.migrations[i].Version != sqlite.migrations[i].Version || postgres.migrations[i].Name != sqlite.migrations[i].Name {
+			t.Errorf("Expected the same migrations for both databases, got %d_%s and %d_%s", postgres.migrations[i].Version,
+				postgres.migrations[i].Name, sqlite.migrations[i].Version, sqlite.migrations[i].Name)
+		}
+	}
+}
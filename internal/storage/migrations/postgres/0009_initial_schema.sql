@@ -88,11 +88,3 @@ CREATE INDEX idx_messages_conversation ON messages (conversation_id);
 CREATE INDEX idx_messages_hash ON messages (cumulative_hash);
 CREATE INDEX idx_messages_children ON messages USING GIN (child_branch_ids);
 CREATE INDEX idx_messages_parent ON messages (parent_message_id);
-
--- Schema versioning
-CREATE TABLE IF NOT EXISTS schema_version (
-    version INT PRIMARY KEY,
-    applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-);
-
-INSERT INTO schema_version (version) VALUES (9) ON CONFLICT (version) DO UPDATE SET version = 9;
@@ -0,0 +1,82 @@
+-- SQLite version of the PostgreSQL migration with the same version.
+-- UUIDs are stored as text, arrays and JSON documents as JSON text.
+-- Random version 4 UUIDs are generated by the default expression of the id columns.
+
+-- 1. Conversation Table: High-level container
+CREATE TABLE conversations (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    created_at TIMESTAMP NOT NULL,
+    request_type VARCHAR(50) NOT NULL,
+    metadata TEXT
+);
+
+-- 2. Branch Table: Defines paths within a conversation
+CREATE TABLE branches (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    parent_branch_id TEXT REFERENCES branches(id),
+    parent_message_id TEXT REFERENCES messages(id),
+    created_at TIMESTAMP NOT NULL
+);
+
+-- 3. Message Table: The actual content
+CREATE TABLE messages (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    branch_id TEXT NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL,
+    content TEXT NOT NULL,
+    model VARCHAR(255),
+    sequence_number INT NOT NULL,
+    cumulative_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    child_branch_ids TEXT NOT NULL DEFAULT '[]',
+    upstream_status_code INT,
+    upstream_error TEXT,
+    prompt_tokens INT,
+    completion_tokens INT,
+    prompt_eval_duration BIGINT,
+    eval_duration BIGINT,
+    parent_message_id TEXT REFERENCES messages(id),
+    client_host VARCHAR(128),
+    upstream_host VARCHAR(128),
+    metadata TEXT,
+
+    UNIQUE (branch_id, sequence_number)
+);
+
+-- 4. Tools Table: Stores reusable tool definitions
+CREATE TABLE tools (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    parameters TEXT, -- The JSON schema of the tool parameters
+    hash VARCHAR(64) NOT NULL UNIQUE
+);
+
+-- 5. Message Tools Table: Links tool definitions to specific messages
+CREATE TABLE message_tools (
+    message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    tool_id TEXT NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, tool_id)
+);
+
+CREATE INDEX idx_message_tools_message_id ON message_tools(message_id);
+
+-- 6. Message Tool Calls Table: Actual tool calls made by the assistant
+CREATE TABLE message_tool_calls (
+    id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+    message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    tool_call_id VARCHAR(255) NOT NULL, -- The ID provided by the LLM (e.g., call_abc123)
+    type VARCHAR(50) NOT NULL,          -- e.g., 'function'
+    function_name VARCHAR(255) NOT NULL,
+    function_arguments TEXT NOT NULL    -- The JSON string of arguments
+);
+
+CREATE INDEX idx_tool_calls_message_id ON message_tool_calls(message_id);
+
+-- Indexes for performance
+CREATE INDEX idx_messages_branch_seq ON messages (branch_id, sequence_number);
+CREATE INDEX idx_messages_conversation ON messages (conversation_id);
+CREATE INDEX idx_messages_hash ON messages (cumulative_hash);
+CREATE INDEX idx_messages_parent ON messages (parent_message_id);
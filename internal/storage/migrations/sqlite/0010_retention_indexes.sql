@@ -0,0 +1,3 @@
+-- Indexes used to purge expired conversations and unused tools
+CREATE INDEX idx_conversations_created_at ON conversations (created_at);
+CREATE INDEX idx_message_tools_tool_id ON message_tools (tool_id);
@@ -0,0 +1,2 @@
+-- Conversations are archived by setting the time of archiving
+ALTER TABLE conversations ADD COLUMN archived_at TIMESTAMP;
@@ -5,11 +5,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
-	_ "embed"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,11 +24,8 @@ type PostgresStorage struct {
 	db *sql.DB
 }
 
-//go:embed schema.sql
-var schemaSQL string
-
 // NewPostgresStorage creates a new PostgreSQL storage instance with the given DSN.
-// It initializes the database schema if it doesn't already exist.
+// It applies all pending schema migrations.
 // Returns a pointer to PostgresStorage and an error if initialization fails.
 func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
 	db, err := sql.Open("postgres", dsn)
@@ -35,39 +34,16 @@ func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
 	}
 
 	s := &PostgresStorage{db: db}
-	if err := s.initSchema(context.Background()); err != nil {
+	if err := migrateSchema(context.Background(), db, "postgres"); err != nil {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
 	return s, nil
 }
 
-// initSchema initializes the database schema if it doesn't already exist.
-// It checks for the existence of the schema_version table and creates the schema if needed.
-// Returns an error if schema initialization fails.
-func (s *PostgresStorage) initSchema(ctx context.Context) error {
-	var exists bool
-	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'schema_version')").Scan(&exists)
-	if err != nil {
-		return err
-	}
-
-	if !exists {
-		logrus.Info("Initializing database schema")
-		_, err = s.db.ExecContext(ctx, schemaSQL)
-		if err != nil {
-			return err
-		}
-	} else {
-		var version int
-		err = s.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version)
-		if err != nil {
-			return err
-		}
-		logrus.WithField("version", version).Info("Database schema is up to date")
-	}
-
-	return nil
+// Close closes the database.
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
 }
 
 // CreateConversation creates a new conversation with the given metadata and returns the conversation and its initial branch.
@@ -119,9 +95,9 @@ func (s *PostgresStorage) GetConversation(ctx context.Context, id uuid.UUID) (*C
 	var conv Conversation
 	var metadataJSON []byte
 	err := s.db.QueryRowContext(ctx,
-		"SELECT id, created_at, request_type, metadata FROM conversations WHERE id = $1",
+		"SELECT id, created_at, request_type, metadata, archived_at FROM conversations WHERE id = $1",
 		id,
-	).Scan(&conv.ID, &conv.CreatedAt, &conv.RequestType, &metadataJSON)
+	).Scan(&conv.ID, &conv.CreatedAt, &conv.RequestType, &metadataJSON, &conv.ArchivedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -310,11 +286,11 @@ func (s *PostgresStorage) FindMessageByHistory(ctx context.Context, history []Si
 	return uuid.Nil, nil
 }
 
-// ListConversations retrieves a paginated list of conversations with their first messages.
+// ListConversations retrieves a paginated list of the active or archived conversations with their first messages.
 // Returns a slice of ConversationOverview and an error.
-func (s *PostgresStorage) ListConversations(ctx context.Context, p Pagination) ([]ConversationOverview, error) {
+func (s *PostgresStorage) ListConversations(ctx context.Context, filter ConversationFilter, p Pagination) ([]ConversationOverview, error) {
 	query := `
-		SELECT c.id, c.created_at, c.request_type, c.metadata,
+		SELECT c.id, c.created_at, c.request_type, c.metadata, c.archived_at,
 	   			m1.id, m1.conversation_id, m1.branch_id, m1.role, m1.content, m1.model, m1.sequence_number, m1.created_at, m1.child_branch_ids, m1.upstream_status_code, m1.upstream_error, m1.prompt_tokens, m1.completion_tokens, m1.prompt_eval_duration, m1.eval_duration, m1.parent_message_id, m1.client_host, m1.upstream_host, m1.metadata,
 	   			m2.id, m2.conversation_id, m2.branch_id, m2.role, m2.content, m2.model, m2.sequence_number, m2.created_at, m2.child_branch_ids, m2.upstream_status_code, m2.upstream_error, m2.prompt_tokens, m2.completion_tokens, m2.prompt_eval_duration, m2.eval_duration, m2.parent_message_id, m2.client_host, m2.upstream_host, m2.metadata,
 	   			COALESCE(b.branch_count, 0),
@@ -339,10 +315,11 @@ func (s *PostgresStorage) ListConversations(ctx context.Context, p Pagination) (
 			WHERE m.conversation_id = c.id AND m.role = 'system'
 			ORDER BY m.sequence_number ASC LIMIT 1
 		) m2 ON true
+		WHERE (c.archived_at IS NOT NULL) = $3
 		ORDER BY c.created_at DESC
 		LIMIT $1 OFFSET $2
 	`
-	rows, err := s.db.QueryContext(ctx, query, p.Limit, p.Offset)
+	rows, err := s.db.QueryContext(ctx, query, p.Limit, p.Offset, filter.Archived)
 	if err != nil {
 		return nil, err
 	}
@@ -373,7 +350,7 @@ func (s *PostgresStorage) ListConversations(ctx context.Context, p Pagination) (
 		var toolCallCount int
 
 		err := rows.Scan(
-			&o.ID, &o.CreatedAt, &o.RequestType, &metadata,
+			&o.ID, &o.CreatedAt, &o.RequestType, &metadata, &o.ArchivedAt,
 			&m1ID, &m1ConvID, &m1BranchID, &m1Role, &m1Content, &m1Model, &m1Seq, &m1CreatedAt, pq.Array(&m1ChildBranchIDs), &m1Status, &m1Error, &m1PromptTokens, &m1CompletionTokens, &m1PromptEvalDuration, &m1EvalDuration, &m1ParentID, &m1ClientHost, &m1UpstreamHost, &m1Metadata,
 			&m2ID, &m2ConvID, &m2BranchID, &m2Role, &m2Content, &m2Model, &m2Seq, &m2CreatedAt, pq.Array(&m2ChildBranchIDs), &m2Status, &m2Error, &m2PromptTokens, &m2CompletionTokens, &m2PromptEvalDuration, &m2EvalDuration, &m2ParentID, &m2ClientHost, &m2UpstreamHost, &m2Metadata,
 			&branchCount,
@@ -498,17 +475,35 @@ func (s *PostgresStorage) ListConversations(ctx context.Context, p Pagination) (
 	return overviews, nil
 }
 
-// SearchMessages searches for messages containing the specified query string.
+// SearchMessages searches for messages containing the query string of the filter and matching its metadata values.
 // Returns a slice of Message and an error.
-func (s *PostgresStorage) SearchMessages(ctx context.Context, query string, p Pagination) ([]Message, error) {
-	sqlQuery := `
+func (s *PostgresStorage) SearchMessages(ctx context.Context, filter MessageFilter, p Pagination) ([]Message, error) {
+	var conditions []string
+	var args []any
+	if filter.Query != "" {
+		args = append(args, "%"+filter.Query+"%")
+		conditions = append(conditions, fmt.Sprintf("content ILIKE $%d", len(args)))
+	}
+	// Sort the paths to get stable queries
+	paths := slices.Sorted(maps.Keys(filter.Metadata))
+	for _, path := range paths {
+		args = append(args, pq.Array(strings.Split(path, ".")), filter.Metadata[path])
+		conditions = append(conditions, fmt.Sprintf("metadata #>> $%d = $%d", len(args)-1, len(args)))
+	}
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, p.Limit, p.Offset)
+	sqlQuery := fmt.Sprintf(`
 		SELECT id, conversation_id, branch_id, role, content, model, sequence_number, created_at, child_branch_ids, upstream_status_code, upstream_error, prompt_tokens, completion_tokens, prompt_eval_duration, eval_duration, parent_message_id, client_host, upstream_host, metadata
 		FROM messages
-		WHERE content ILIKE $1
+		WHERE %s
 		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
-	`
-	rows, err := s.db.QueryContext(ctx, sqlQuery, "%"+query+"%", p.Limit, p.Offset)
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args))
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -717,3 +712,85 @@ func computeToolHash(tool Tool) string {
 	h.Write(tool.Parameters)
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// DeleteConversation deletes a conversation, deleting its branches and messages through ON DELETE CASCADE.
+func (s *PostgresStorage) DeleteConversation(ctx context.Context, id uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM conversations WHERE id = $1", id)
+	return checkAffected(res, err)
+}
+
+// DeleteBranch deletes a branch with its messages and all branches forked from it, and removes it from the child
+// branches of the message it has been forked from.
+func (s *PostgresStorage) DeleteBranch(ctx context.Context, branchID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var parentMessageID *uuid.UUID
+	err = tx.QueryRowContext(ctx, "SELECT parent_message_id FROM branches WHERE id = $1 FOR UPDATE", branchID).Scan(&parentMessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if parentMessageID == nil {
+		return ErrInitialBranch
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		WITH RECURSIVE descendants AS (
+			SELECT id FROM branches WHERE id = $1
+			UNION ALL
+			SELECT b.id FROM branches b JOIN descendants d ON b.parent_branch_id = d.id
+		)
+		DELETE FROM branches WHERE id IN (SELECT id FROM descendants)
+	`, branchID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE messages SET child_branch_ids = array_remove(child_branch_ids, $1) WHERE id = $2",
+		branchID, *parentMessageID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ArchiveConversation hides a conversation from the list of active conversations, keeping the time it has been
+// archived first.
+func (s *PostgresStorage) ArchiveConversation(ctx context.Context, id uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE conversations SET archived_at = COALESCE(archived_at, $2) WHERE id = $1", id, time.Now())
+	return checkAffected(res, err)
+}
+
+// RestoreConversation moves an archived conversation back to the list of active conversations.
+func (s *PostgresStorage) RestoreConversation(ctx context.Context, id uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE conversations SET archived_at = NULL WHERE id = $1", id)
+	return checkAffected(res, err)
+}
+
+// Purge deletes the conversations expired according to the retention policy, and tools which aren't used anymore.
+func (s *PostgresStorage) Purge(ctx context.Context, policy RetentionPolicy, now time.Time) (PurgeResult, error) {
+	return purgeSQL(ctx, s.db, "postgres", policy, now)
+}
+
+// checkAffected returns ErrNotFound if a statement succeeded without affecting any row
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
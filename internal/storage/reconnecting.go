@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned while no connection to the storage backend could be established.
+var ErrUnavailable = errors.New("storage is unavailable")
+
+// ReconnectingStorage wraps a storage backend which could not be created, e.g. because the database was down
+// at startup. It retries to create the backend when it is used, at most once per interval, and returns
+// ErrUnavailable until this succeeds.
+type ReconnectingStorage struct {
+	connect  func() (Storage, error)
+	interval time.Duration
+
+	mu          sync.Mutex
+	store       Storage
+	connecting  bool
+	closed      bool
+	lastAttempt time.Time
+}
+
+// NewReconnectingStorage creates a storage which creates its backend using connect once it is available.
+func NewReconnectingStorage(connect func() (Storage, error), interval time.Duration) *ReconnectingStorage {
+	return &ReconnectingStorage{
+		connect:     connect,
+		interval:    interval,
+		lastAttempt: time.Now(),
+	}
+}
+
+// backend returns the storage backend, trying to create it if the retry interval has passed.
+func (s *ReconnectingStorage) backend() (Storage, error) {
+	s.mu.Lock()
+	if s.store != nil {
+		defer s.mu.Unlock()
+		return s.store, nil
+	}
+	// Don't let callers wait for a connection attempt which is already in progress
+	if s.closed || s.connecting || time.Since(s.lastAttempt) < s.interval {
+		s.mu.Unlock()
+		return nil, ErrUnavailable
+	}
+	s.connecting = true
+	s.mu.Unlock()
+
+	store, err := s.connect()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connecting = false
+	s.lastAttempt = time.Now()
+	if err != nil {
+		logrus.WithError(err).Debug("Storage backend is still unavailable")
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if s.closed {
+		// The storage has been closed while connecting
+		_ = Close(store)
+		return nil, ErrUnavailable
+	}
+	logrus.Info("Connected to storage backend")
+	s.store = store
+	return store, nil
+}
+
+func (s *ReconnectingStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*Conversation, *Branch, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, nil, err
+	}
+	return store.CreateConversation(ctx, metadata, requestType)
+}
+
+func (s *ReconnectingStorage) GetConversation(ctx context.Context, id uuid.UUID) (*Conversation, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetConversation(ctx, id)
+}
+
+func (s *ReconnectingStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *Message) (*Message, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.AddMessage(ctx, parentMessageID, message)
+}
+
+func (s *ReconnectingStorage) GetBranchHistory(ctx context.Context, branchID uuid.UUID) ([]Message, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetBranchHistory(ctx, branchID)
+}
+
+func (s *ReconnectingStorage) FindMessageByHistory(ctx context.Context, history []SimpleMessage, requestType string) (uuid.UUID, error) {
+	store, err := s.backend()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return store.FindMessageByHistory(ctx, history, requestType)
+}
+
+func (s *ReconnectingStorage) ListConversations(ctx context.Context, filter ConversationFilter, p Pagination) ([]ConversationOverview, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListConversations(ctx, filter, p)
+}
+
+func (s *ReconnectingStorage) SearchMessages(ctx context.Context, filter MessageFilter, p Pagination) ([]Message, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.SearchMessages(ctx, filter, p)
+}
+
+func (s *ReconnectingStorage) GetConversationMessages(ctx context.Context, conversationID uuid.UUID) ([]Message, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetConversationMessages(ctx, conversationID)
+}
+
+func (s *ReconnectingStorage) GetBranch(ctx context.Context, branchID uuid.UUID) (*Branch, error) {
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetBranch(ctx, branchID)
+}
+
+// Purge purges the storage backend if it supports retention policies.
+func (s *ReconnectingStorage) Purge(ctx context.Context, policy RetentionPolicy, now time.Time) (PurgeResult, error) {
+	store, err := s.backend()
+	if err != nil {
+		return PurgeResult{}, err
+	}
+	purger, ok := store.(Purger)
+	if !ok {
+		return PurgeResult{}, fmt.Errorf("storage %T doesn't support retention policies", store)
+	}
+	return purger.Purge(ctx, policy, now)
+}
+
+func (s *ReconnectingStorage) DeleteConversation(ctx context.Context, id uuid.UUID) error {
+	store, err := s.backend()
+	if err != nil {
+		return err
+	}
+	return store.DeleteConversation(ctx, id)
+}
+
+func (s *ReconnectingStorage) DeleteBranch(ctx context.Context, branchID uuid.UUID) error {
+	store, err := s.backend()
+	if err != nil {
+		return err
+	}
+	return store.DeleteBranch(ctx, branchID)
+}
+
+func (s *ReconnectingStorage) ArchiveConversation(ctx context.Context, id uuid.UUID) error {
+	store, err := s.backend()
+	if err != nil {
+		return err
+	}
+	return store.ArchiveConversation(ctx, id)
+}
+
+func (s *ReconnectingStorage) RestoreConversation(ctx context.Context, id uuid.UUID) error {
+	store, err := s.backend()
+	if err != nil {
+		return err
+	}
+	return store.RestoreConversation(ctx, id)
+}
+
+// Close closes the storage backend if it has been created, and stops creating it.
+func (s *ReconnectingStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.store == nil {
+		return nil
+	}
+	return Close(s.store)
+}
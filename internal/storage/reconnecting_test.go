@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type stubStorage struct {
+	Storage
+}
+
+func (s *stubStorage) GetConversation(ctx context.Context, id uuid.UUID) (*Conversation, error) {
+	return &Conversation{ID: id}, nil
+}
+
+func TestReconnectingStorage_ConnectsOnceAvailable(t *testing.T) {
+	attempts := 0
+	s := NewReconnectingStorage(func() (Storage, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &stubStorage{}, nil
+	}, 0)
+
+	id := uuid.New()
+	if _, err := s.GetConversation(context.Background(), id); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+	conversation, err := s.GetConversation(context.Background(), id)
+	if err != nil || conversation.ID != id {
+		t.Errorf("Expected conversation after reconnecting, got %v (%v)", conversation, err)
+	}
+	if _, err := s.GetConversation(context.Background(), id); err != nil || attempts != 2 {
+		t.Errorf("Expected connection to be reused, got %d attempts (%v)", attempts, err)
+	}
+}
+
+type closingStorage struct {
+	stubStorage
+	closed bool
+}
+
+func (s *closingStorage) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestReconnectingStorage_Close(t *testing.T) {
+	backend := &closingStorage{}
+	s := NewReconnectingStorage(func() (Storage, error) {
+		return backend, nil
+	}, 0)
+	if _, err := s.GetConversation(context.Background(), uuid.New()); err != nil {
+		t.Fatalf("Expected the backend to be connected, got %v", err)
+	}
+
+	if err := Close(s); err != nil || !backend.closed {
+		t.Errorf("Expected the backend to be closed, got %v", err)
+	}
+	if err := Close(NewMemoryStorage(0)); err != nil {
+		t.Errorf("Expected storages without database to be ignored, got %v", err)
+	}
+}
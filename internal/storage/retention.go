@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetentionRule overrides the maximum age of the conversations matching a model and/or request type.
+type RetentionRule struct {
+	// Model is a glob pattern (e.g. "gpt-4*") matching any model of the messages of a conversation
+	Model string
+	// RequestType is the request type of the conversation, e.g. "chat" or "embedding"
+	RequestType string
+	// MaxAge is the time since the last message after which a matching conversation expires, zero keeps it forever
+	MaxAge time.Duration
+}
+
+// RetentionPolicy defines which conversations are purged. A conversation expires after the maximum age of the first
+// matching rule, or MaxAge if no rule matches. Independently of their age, only the MaxConversations most recent
+// conversations are kept. Zero values disable a limit.
+type RetentionPolicy struct {
+	MaxAge           time.Duration
+	MaxConversations int
+	Rules            []RetentionRule
+	// BatchSize is the maximum number of conversations deleted at once
+	BatchSize int
+}
+
+// PurgeResult reports what has been deleted by a purge.
+type PurgeResult struct {
+	Expired  int64 // conversations older than their maximum age
+	Exceeded int64 // conversations exceeding the maximum number of conversations
+	Tools    int64 // tool definitions not used by any message anymore
+}
+
+// Conversations returns the total number of deleted conversations.
+func (r PurgeResult) Conversations() int64 {
+	return r.Expired + r.Exceeded
+}
+
+// Purger is implemented by storages which can delete conversations according to a retention policy.
+type Purger interface {
+	// Purge deletes the conversations expired at the given time, including their branches and messages, as well as
+	// tools which aren't used anymore.
+	Purge(ctx context.Context, policy RetentionPolicy, now time.Time) (PurgeResult, error)
+}
+
+// RetentionStats contains the counters of a retention job.
+type RetentionStats struct {
+	Runs                 int64
+	Failures             int64
+	DeletedConversations int64
+	DeletedTools         int64
+	LastRun              time.Time
+	LastDuration         time.Duration
+}
+
+// RetentionJob periodically purges a storage according to a retention policy.
+type RetentionJob struct {
+	purger   Purger
+	policy   RetentionPolicy
+	interval time.Duration
+
+	runs                 atomic.Int64
+	failures             atomic.Int64
+	deletedConversations atomic.Int64
+	deletedTools         atomic.Int64
+	lastRun              atomic.Int64
+	lastDuration         atomic.Int64
+
+	// running serializes runs, mu guards the state of the background goroutine
+	running sync.Mutex
+	mu      sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+	started bool
+	closed  bool
+}
+
+// NewRetentionJob creates a job purging the storage every interval. The batch size of the policy defaults to 1000.
+func NewRetentionJob(purger Purger, policy RetentionPolicy, interval time.Duration) *RetentionJob {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 1000
+	}
+	return &RetentionJob{
+		purger:   purger,
+		policy:   policy,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Run purges the storage once and logs the result.
+func (j *RetentionJob) Run(ctx context.Context) (PurgeResult, error) {
+	j.running.Lock()
+	defer j.running.Unlock()
+
+	start := time.Now()
+	result, err := j.purger.Purge(ctx, j.policy, start)
+	duration := time.Since(start)
+
+	j.runs.Add(1)
+	j.deletedConversations.Add(result.Conversations())
+	j.deletedTools.Add(result.Tools)
+	j.lastRun.Store(start.UnixNano())
+	j.lastDuration.Store(int64(duration))
+	fields := logrus.Fields{
+		"expired":  result.Expired,
+		"exceeded": result.Exceeded,
+		"tools":    result.Tools,
+		"duration": duration,
+	}
+	if err != nil {
+		j.failures.Add(1)
+		logrus.WithError(err).WithFields(fields).Warn("Failed to purge expired conversations")
+	} else if result.Conversations() > 0 || result.Tools > 0 {
+		logrus.WithFields(fields).Info("Purged expired conversations")
+	} else {
+		logrus.WithFields(fields).Debug("No expired conversations")
+	}
+	return result, err
+}
+
+// Start purges the storage in the background, once immediately and then every interval.
+func (j *RetentionJob) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.started || j.closed {
+		return
+	}
+	j.started = true
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-j.done:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			_, _ = j.Run(ctx)
+			cancel()
+
+			select {
+			case <-j.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stats returns the counters of the job.
+func (j *RetentionJob) Stats() RetentionStats {
+	stats := RetentionStats{
+		Runs:                 j.runs.Load(),
+		Failures:             j.failures.Load(),
+		DeletedConversations: j.deletedConversations.Load(),
+		DeletedTools:         j.deletedTools.Load(),
+		LastDuration:         time.Duration(j.lastDuration.Load()),
+	}
+	if lastRun := j.lastRun.Load(); lastRun != 0 {
+		stats.LastRun = time.Unix(0, lastRun)
+	}
+	return stats
+}
+
+// Close stops the job, cancelling a running purge.
+func (j *RetentionJob) Close() {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return
+	}
+	j.closed = true
+	close(j.done)
+	j.mu.Unlock()
+	j.wg.Wait()
+}
+
+// purgeSQL implements Purger for the databases sharing the schema of the PostgreSQL storage, the dialect is "postgres"
+// or "sqlite". Conversations are deleted in batches, deleting their branches and messages through ON DELETE CASCADE.
+func purgeSQL(ctx context.Context, db *sql.DB, dialect string, policy RetentionPolicy, now time.Time) (PurgeResult, error) {
+	var result PurgeResult
+	condition, args := expiryCondition(policy, dialect, now)
+	if condition != "" {
+		query := fmt.Sprintf("DELETE FROM conversations WHERE id IN (SELECT c.id FROM conversations c WHERE %s LIMIT %d)", condition, policy.BatchSize)
+		n, err := deleteInBatches(ctx, db, policy.BatchSize, query, args...)
+		result.Expired = n
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if policy.MaxConversations > 0 {
+		query := "DELETE FROM conversations WHERE id IN (SELECT id FROM conversations ORDER BY created_at DESC, id LIMIT $1 OFFSET $2)"
+		n, err := deleteInBatches(ctx, db, policy.BatchSize, query, policy.BatchSize, policy.MaxConversations)
+		result.Exceeded = n
+		if err != nil {
+			return result, err
+		}
+	}
+
+	res, err := db.ExecContext(ctx, "DELETE FROM tools WHERE NOT EXISTS (SELECT 1 FROM message_tools mt WHERE mt.tool_id = tools.id)")
+	if err != nil {
+		return result, err
+	}
+	result.Tools, err = res.RowsAffected()
+	return result, err
+}
+
+// deleteInBatches runs a statement deleting at most batchSize rows until fewer rows are deleted, and returns the total
+// number of deleted rows
+func deleteInBatches(ctx context.Context, db *sql.DB, batchSize int, query string, args ...any) (int64, error) {
+	var total int64
+	for {
+		res, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		total += n
+		if err != nil || n < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+// expiryCondition returns the SQL condition selecting the expired conversations "c" and its arguments, or an empty
+// condition if no conversation can expire. A conversation expires if its last message is older than the maximum age
+// of the first matching rule, or the maximum age of the policy if no rule matches.
+func expiryCondition(policy RetentionPolicy, dialect string, now time.Time) (string, []any) {
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	lastActivity := "COALESCE((SELECT MAX(m.created_at) FROM messages m WHERE m.conversation_id = c.id), c.created_at)"
+
+	var clauses, previous []string
+	expire := func(match string, maxAge time.Duration) {
+		if maxAge <= 0 {
+			return
+		}
+		conditions := make([]string, 0, len(previous)+2)
+		if match != "" {
+			conditions = append(conditions, match)
+		}
+		for _, p := range previous {
+			conditions = append(conditions, "NOT "+p)
+		}
+		conditions = append(conditions, lastActivity+" < "+arg(now.Add(-maxAge).UTC()))
+		clauses = append(clauses, "("+strings.Join(conditions, " AND ")+")")
+	}
+
+	for _, rule := range policy.Rules {
+		var conditions []string
+		if rule.RequestType != "" {
+			conditions = append(conditions, "c.request_type = "+arg(rule.RequestType))
+		}
+		if rule.Model != "" {
+			model := "m.model LIKE " + arg(globToLike(rule.Model)) + " ESCAPE '\\'"
+			if dialect == "sqlite" {
+				// LIKE ignores the case of ASCII characters in SQLite, GLOB has the same wildcards but respects the case
+				model = "m.model GLOB " + arg(strings.ReplaceAll(rule.Model, "[", "[[]"))
+			}
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM messages m WHERE m.conversation_id = c.id AND "+model+")")
+		}
+		match := "(" + strings.Join(conditions, " AND ") + ")"
+		if len(conditions) == 0 {
+			// A rule without conditions matches all remaining conversations
+			match = ""
+		}
+		expire(match, rule.MaxAge)
+		if match == "" {
+			return strings.Join(clauses, " OR "), args
+		}
+		previous = append(previous, match)
+	}
+	expire("", policy.MaxAge)
+	return strings.Join(clauses, " OR "), args
+}
+
+// globToLike converts a glob pattern into a LIKE pattern using "\" as escape character
+func globToLike(pattern string) string {
+	var sb strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteRune('%')
+		case '?':
+			sb.WriteRune('_')
+		case '%', '_', '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubPurger returns the result for every purge, or fails while failing is set
+type stubPurger struct {
+	result  PurgeResult
+	failing atomic.Bool
+	calls   atomic.Int32
+}
+
+func (p *stubPurger) Purge(ctx context.Context, policy RetentionPolicy, now time.Time) (PurgeResult, error) {
+	p.calls.Add(1)
+	if p.failing.Load() {
+		return PurgeResult{}, errors.New("connection refused")
+	}
+	return p.result, nil
+}
+
+func TestRetentionJob_Stats(t *testing.T) {
+	purger := &stubPurger{result: PurgeResult{Expired: 2, Exceeded: 1, Tools: 4}}
+	job := NewRetentionJob(purger, RetentionPolicy{}, time.Hour)
+
+	if _, err := job.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	purger.failing.Store(true)
+	if _, err := job.Run(context.Background()); err == nil {
+		t.Errorf("Expected run to fail")
+	}
+
+	stats := job.Stats()
+	if stats.Runs != 2 || stats.Failures != 1 || stats.DeletedConversations != 3 || stats.DeletedTools != 4 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.LastRun.IsZero() {
+		t.Errorf("Expected the time of the last run")
+	}
+}
+
+func TestRetentionJob_StartPurgesImmediately(t *testing.T) {
+	purger := &stubPurger{}
+	job := NewRetentionJob(purger, RetentionPolicy{}, time.Hour)
+	job.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for purger.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the storage to be purged after starting the job")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	job.Close()
+	job.Close()
+	if calls := purger.calls.Load(); calls != 1 {
+		t.Errorf("Expected 1 purge, got %d", calls)
+	}
+}
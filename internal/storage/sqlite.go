@@ -0,0 +1,614 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStorage represents a SQLite storage backend for conversations, branches, and messages.
+// It uses the same data model as PostgresStorage, storing UUIDs as text and arrays as JSON.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// sqliteMessageColumns are the columns of the messages table in the order expected by scanMessage.
+const sqliteMessageColumns = "m.id, m.conversation_id, m.branch_id, m.role, m.content, m.model, m.sequence_number, m.created_at, m.child_branch_ids, m.upstream_status_code, m.upstream_error, m.prompt_tokens, m.completion_tokens, m.prompt_eval_duration, m.eval_duration, m.parent_message_id, m.client_host, m.upstream_host, m.metadata"
+
+// NewSQLiteStorage creates a new SQLite storage instance using the database file at the given path.
+// The special path ":memory:" creates an in-memory database.
+// It applies all pending schema migrations.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStorage{db: db}
+	if err := migrateSchema(context.Background(), db, "sqlite"); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	return s, nil
+}
+
+// openSQLite opens the database file at the given path
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_time_format=sqlite&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	if path != ":memory:" {
+		dsn += "&_pragma=journal_mode(WAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer only, and every connection to an in-memory database opens a new database
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// Close closes the database.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// CreateConversation creates a new conversation with the given metadata and returns the conversation and its initial branch.
+func (s *SQLiteStorage) CreateConversation(ctx context.Context, metadata map[string]interface{}, requestType string) (*Conversation, *Branch, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	conv := Conversation{ID: uuid.New(), CreatedAt: now, RequestType: requestType, Metadata: metadata}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO conversations (id, created_at, metadata, request_type) VALUES ($1, $2, $3, $4)",
+		conv.ID, now, string(metadataJSON), requestType,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	branch := Branch{ID: uuid.New(), ConversationID: conv.ID, CreatedAt: now}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO branches (id, conversation_id, created_at) VALUES ($1, $2, $3)",
+		branch.ID, conv.ID, now,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &conv, &branch, nil
+}
+
+// GetConversation retrieves a conversation by its ID.
+func (s *SQLiteStorage) GetConversation(ctx context.Context, id uuid.UUID) (*Conversation, error) {
+	var conv Conversation
+	var metadataJSON sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, created_at, request_type, metadata, archived_at FROM conversations WHERE id = $1",
+		id,
+	).Scan(&conv.ID, &conv.CreatedAt, &conv.RequestType, &metadataJSON, &conv.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadataJSON.Valid {
+		if err := json.Unmarshal([]byte(metadataJSON.String), &conv.Metadata); err != nil {
+			logrus.WithError(err).Warn("Failed to unmarshal conversation metadata")
+		}
+	}
+
+	return &conv, nil
+}
+
+// AddMessage adds a new message to a conversation, potentially forking the branch if needed.
+func (s *SQLiteStorage) AddMessage(ctx context.Context, parentMessageID uuid.UUID, message *Message) (*Message, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	now := time.Now().UTC()
+	var branchID uuid.UUID
+	var lastHash string
+	var lastSeq int
+
+	if parentMessageID != uuid.Nil {
+		err = tx.QueryRowContext(ctx,
+			"SELECT branch_id, cumulative_hash, sequence_number FROM messages WHERE id = $1",
+			parentMessageID,
+		).Scan(&branchID, &lastHash, &lastSeq)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fork if the parent message already has a child message
+		var hasChildren bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM messages WHERE parent_message_id = $1)", parentMessageID).Scan(&hasChildren)
+		if err != nil {
+			return nil, err
+		}
+
+		if hasChildren {
+			newBranchID := uuid.New()
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO branches (id, conversation_id, parent_branch_id, parent_message_id, created_at) VALUES ($1, (SELECT conversation_id FROM branches WHERE id = $2), $2, $3, $4)",
+				newBranchID, branchID, parentMessageID, now,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				"UPDATE messages SET child_branch_ids = json_insert(child_branch_ids, '$[#]', $1) WHERE id = $2",
+				newBranchID.String(), parentMessageID,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			branchID = newBranchID
+		}
+	} else {
+		// Without a parent, this is the first message of the branch given by the message
+		branchID = message.BranchID
+		if branchID == uuid.Nil {
+			return nil, fmt.Errorf("branchID is required when parentMessageID is empty")
+		}
+	}
+
+	metadataJSON, err := json.Marshal(message.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message metadata: %w", err)
+	}
+
+	msg := Message{
+		SimpleMessage:      message.SimpleMessage,
+		ID:                 uuid.New(),
+		BranchID:           branchID,
+		SequenceNumber:     lastSeq + 1,
+		CreatedAt:          now,
+		ParentMessageID:    optionalUUID(parentMessageID),
+		UpstreamStatusCode: message.UpstreamStatusCode,
+		UpstreamError:      message.UpstreamError,
+	}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO messages (id, conversation_id, branch_id, role, content, model, sequence_number, cumulative_hash, created_at, upstream_status_code, upstream_error, prompt_tokens, completion_tokens, prompt_eval_duration, eval_duration, parent_message_id, client_host, upstream_host, metadata) VALUES ($1, (SELECT conversation_id FROM branches WHERE id = $2), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) RETURNING conversation_id",
+		msg.ID, branchID, message.Role, message.Content, message.Model, msg.SequenceNumber, computeHash(lastHash, message.Role, message.Content), now, message.UpstreamStatusCode, message.UpstreamError, message.PromptTokens, message.CompletionTokens, int64(message.PromptEvalDuration), int64(message.EvalDuration), msg.ParentMessageID, message.ClientHost, message.UpstreamHost, string(metadataJSON),
+	).Scan(&msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tool := range message.Tools {
+		toolHash := computeToolHash(tool)
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO tools (id, name, description, parameters, hash) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (hash) DO NOTHING",
+			uuid.New(), tool.Name, optional(tool.Description), optionalJSON(tool.Parameters), toolHash,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert tool: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO message_tools (message_id, tool_id) VALUES ($1, (SELECT id FROM tools WHERE hash = $2)) ON CONFLICT DO NOTHING",
+			msg.ID, toolHash,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert message tool: %w", err)
+		}
+	}
+
+	for _, tc := range message.ToolCalls {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO message_tool_calls (id, message_id, tool_call_id, type, function_name, function_arguments) VALUES ($1, $2, $3, $4, $5, $6)",
+			uuid.New(), msg.ID, tc.ID, tc.Type, tc.Function.Name, tc.Function.Arguments,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert tool call: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// GetBranchHistory retrieves the complete history of messages for a given branch.
+func (s *SQLiteStorage) GetBranchHistory(ctx context.Context, branchID uuid.UUID) ([]Message, error) {
+	query := `
+		WITH RECURSIVE branch_path AS (
+			SELECT id, parent_branch_id, parent_message_id, 0 as level
+			FROM branches WHERE id = $1
+			UNION ALL
+			SELECT b.id, b.parent_branch_id, b.parent_message_id, bp.level + 1
+			FROM branches b
+			JOIN branch_path bp ON b.id = bp.parent_branch_id
+		)
+		SELECT ` + sqliteMessageColumns + `
+		FROM messages m
+		JOIN branch_path bp ON m.branch_id = bp.id
+		WHERE (bp.level = 0)
+		   OR (m.sequence_number <= (SELECT m2.sequence_number FROM messages m2 WHERE m2.id = (SELECT bp2.parent_message_id FROM branch_path bp2 WHERE bp2.level = bp.level - 1)))
+		ORDER BY m.sequence_number ASC
+	`
+	return s.queryMessages(ctx, query, branchID)
+}
+
+// FindMessageByHistory searches for a message in the database based on a history of messages.
+// Returns the message ID if found, or uuid.Nil.
+func (s *SQLiteStorage) FindMessageByHistory(ctx context.Context, history []SimpleMessage, requestType string) (uuid.UUID, error) {
+	if len(history) == 0 {
+		return uuid.Nil, nil
+	}
+
+	var id uuid.UUID
+	err := s.db.QueryRowContext(ctx,
+		"SELECT m.id FROM messages m JOIN conversations c ON m.conversation_id = c.id WHERE m.cumulative_hash = $1 AND c.request_type = $2 ORDER BY m.created_at DESC LIMIT 1",
+		computeHistoryHash(history), requestType,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return id, err
+}
+
+// ListConversations retrieves a paginated list of conversations with their first messages.
+func (s *SQLiteStorage) ListConversations(ctx context.Context, filter ConversationFilter, p Pagination) ([]ConversationOverview, error) {
+	query := `
+		SELECT c.id, c.created_at, c.request_type, c.metadata, c.archived_at,
+			(SELECT COUNT(*) FROM branches b WHERE b.conversation_id = c.id),
+			(SELECT COUNT(*) FROM message_tool_calls tc JOIN messages m ON m.id = tc.message_id WHERE m.conversation_id = c.id),
+			(SELECT m.id FROM messages m WHERE m.conversation_id = c.id AND m.role != 'system' ORDER BY m.sequence_number ASC LIMIT 1),
+			(SELECT m.id FROM messages m WHERE m.conversation_id = c.id AND m.role = 'system' ORDER BY m.sequence_number ASC LIMIT 1)
+		FROM conversations c
+		WHERE (c.archived_at IS NOT NULL) = $3
+		ORDER BY c.created_at DESC
+		LIMIT $1 OFFSET $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, p.Limit, p.Offset, filter.Archived)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var overviews []ConversationOverview
+	var firstIDs, systemIDs []*uuid.UUID
+	for rows.Next() {
+		var o ConversationOverview
+		var metadata sql.NullString
+		var firstID, systemID *uuid.UUID
+		if err := rows.Scan(&o.ID, &o.CreatedAt, &o.RequestType, &metadata, &o.ArchivedAt, &o.BranchCount, &o.ToolCallCount, &firstID, &systemID); err != nil {
+			return nil, err
+		}
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &o.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		overviews = append(overviews, o)
+		firstIDs = append(firstIDs, firstID)
+		systemIDs = append(systemIDs, systemID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	// Only a single connection is available, so the messages are loaded after the conversations have been read
+	for i := range overviews {
+		if overviews[i].FirstMessage, err = s.getMessage(ctx, firstIDs[i]); err != nil {
+			return nil, err
+		}
+		if overviews[i].SystemPrompt, err = s.getMessage(ctx, systemIDs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return overviews, nil
+}
+
+// SearchMessages searches for messages containing the query string of the filter and matching its metadata values.
+func (s *SQLiteStorage) SearchMessages(ctx context.Context, filter MessageFilter, p Pagination) ([]Message, error) {
+	var conditions []string
+	var args []any
+	if filter.Query != "" {
+		// LIKE is case-insensitive for ASCII characters in SQLite
+		args = append(args, "%"+filter.Query+"%")
+		conditions = append(conditions, fmt.Sprintf("m.content LIKE $%d", len(args)))
+	}
+	// Sort the paths to get stable queries
+	paths := slices.Sorted(maps.Keys(filter.Metadata))
+	for _, path := range paths {
+		args = append(args, sqliteJSONPath(path), filter.Metadata[path])
+		// Compare the JSON text representation of the value like the #>> operator of Postgres
+		conditions = append(conditions, fmt.Sprintf(
+			"(CASE json_type(m.metadata, $%[1]d) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(m.metadata, $%[1]d) AS TEXT) END) = $%[2]d",
+			len(args)-1, len(args),
+		))
+	}
+	where := "1 = 1"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, p.Limit, p.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM messages m
+		WHERE %s
+		ORDER BY m.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, sqliteMessageColumns, where, len(args)-1, len(args))
+	return s.queryMessages(ctx, query, args...)
+}
+
+// GetConversationMessages retrieves messages for the initial branch of a given conversation ID.
+func (s *SQLiteStorage) GetConversationMessages(ctx context.Context, conversationID uuid.UUID) ([]Message, error) {
+	query := `
+		SELECT ` + sqliteMessageColumns + `
+		FROM messages m
+		JOIN branches b ON m.branch_id = b.id
+		WHERE m.conversation_id = $1 AND b.parent_branch_id IS NULL
+		ORDER BY m.sequence_number ASC, m.created_at ASC
+	`
+	return s.queryMessages(ctx, query, conversationID)
+}
+
+// GetBranch retrieves a branch by its ID.
+// Returns nil if the branch doesn't exist.
+func (s *SQLiteStorage) GetBranch(ctx context.Context, branchID uuid.UUID) (*Branch, error) {
+	var b Branch
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, conversation_id, parent_branch_id, parent_message_id, created_at FROM branches WHERE id = $1",
+		branchID,
+	).Scan(&b.ID, &b.ConversationID, &b.ParentBranchID, &b.ParentMessageID, &b.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// getMessage retrieves a single message by its ID, returning nil if the ID is nil.
+func (s *SQLiteStorage) getMessage(ctx context.Context, id *uuid.UUID) (*Message, error) {
+	if id == nil {
+		return nil, nil
+	}
+	messages, err := s.queryMessages(ctx, "SELECT "+sqliteMessageColumns+" FROM messages m WHERE m.id = $1", *id)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// queryMessages runs a query selecting the sqliteMessageColumns and returns the messages including their tools
+// and tool calls.
+func (s *SQLiteStorage) queryMessages(ctx context.Context, query string, args ...any) ([]Message, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var messages []Message
+	for rows.Next() {
+		m, err := s.scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The rows have to be closed before querying the tools, as only a single connection is available
+	_ = rows.Close()
+
+	for i := range messages {
+		if err := s.loadTools(ctx, &messages[i]); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+func (s *SQLiteStorage) scanMessage(rows *sql.Rows) (*Message, error) {
+	var m Message
+	var modelVal, errorText, clientHostVal, upstreamHostVal, metadataJSON sql.NullString
+	var statusCode, promptTokens, completionTokens sql.NullInt32
+	var promptEvalDuration, evalDuration sql.NullInt64
+	var childBranchIDs string
+	err := rows.Scan(
+		&m.ID, &m.ConversationID, &m.BranchID, &m.Role, &m.Content, &modelVal, &m.SequenceNumber, &m.CreatedAt, &childBranchIDs, &statusCode, &errorText, &promptTokens, &completionTokens, &promptEvalDuration, &evalDuration, &m.ParentMessageID, &clientHostVal, &upstreamHostVal, &metadataJSON,
+	)
+	if err != nil {
+		return nil, err
+	}
+	m.Model = modelVal.String
+	m.UpstreamStatusCode = int(statusCode.Int32)
+	if errorText.Valid {
+		m.UpstreamError = &errorText.String
+	}
+	m.PromptTokens = int(promptTokens.Int32)
+	m.CompletionTokens = int(completionTokens.Int32)
+	m.PromptEvalDuration = time.Duration(promptEvalDuration.Int64)
+	m.EvalDuration = time.Duration(evalDuration.Int64)
+	m.ClientHost = clientHostVal.String
+	m.UpstreamHost = upstreamHostVal.String
+	if err := json.Unmarshal([]byte(childBranchIDs), &m.ChildBranchIDs); err != nil {
+		logrus.WithError(err).Warn("Failed to unmarshal child branch IDs")
+	}
+	if metadataJSON.Valid {
+		if err := json.Unmarshal([]byte(metadataJSON.String), &m.Metadata); err != nil {
+			logrus.WithError(err).Warn("Failed to unmarshal message metadata")
+		}
+	}
+	return &m, nil
+}
+
+// loadTools loads the tools and tool calls of a message.
+func (s *SQLiteStorage) loadTools(ctx context.Context, m *Message) error {
+	toolRows, err := s.db.QueryContext(ctx,
+		"SELECT t.id, t.name, t.description, t.parameters FROM tools t JOIN message_tools mt ON t.id = mt.tool_id WHERE mt.message_id = $1",
+		m.ID,
+	)
+	if err != nil {
+		return err
+	}
+	for toolRows.Next() {
+		var t Tool
+		var desc, parameters sql.NullString
+		if err := toolRows.Scan(&t.ID, &t.Name, &desc, &parameters); err != nil {
+			_ = toolRows.Close()
+			return err
+		}
+		t.Description = desc.String
+		if parameters.Valid {
+			t.Parameters = json.RawMessage(parameters.String)
+		}
+		m.Tools = append(m.Tools, t)
+	}
+	_ = toolRows.Close()
+
+	tcRows, err := s.db.QueryContext(ctx,
+		"SELECT tool_call_id, type, function_name, function_arguments FROM message_tool_calls WHERE message_id = $1",
+		m.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(tcRows)
+	for tcRows.Next() {
+		var tc ToolCall
+		if err := tcRows.Scan(&tc.ID, &tc.Type, &tc.Function.Name, &tc.Function.Arguments); err != nil {
+			return err
+		}
+		m.ToolCalls = append(m.ToolCalls, tc)
+	}
+	return tcRows.Err()
+}
+
+// sqliteJSONPath converts a dot separated metadata path into a SQLite JSON path, quoting every key.
+func sqliteJSONPath(path string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, key := range strings.Split(path, ".") {
+		b.WriteString(`."`)
+		b.WriteString(strings.ReplaceAll(key, `"`, `\"`))
+		b.WriteString(`"`)
+	}
+	return b.String()
+}
+
+// optionalJSON returns the JSON document as a string, or nil if it is empty.
+func optionalJSON(data json.RawMessage) *string {
+	if len(data) == 0 {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
+
+// DeleteConversation deletes a conversation, deleting its branches and messages through ON DELETE CASCADE.
+func (s *SQLiteStorage) DeleteConversation(ctx context.Context, id uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM conversations WHERE id = $1", id)
+	return checkAffected(res, err)
+}
+
+// DeleteBranch deletes a branch with its messages and all branches forked from it, and removes it from the child
+// branches of the message it has been forked from.
+func (s *SQLiteStorage) DeleteBranch(ctx context.Context, branchID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var parentMessageID *uuid.UUID
+	err = tx.QueryRowContext(ctx, "SELECT parent_message_id FROM branches WHERE id = $1", branchID).Scan(&parentMessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if parentMessageID == nil {
+		return ErrInitialBranch
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		WITH RECURSIVE descendants(id) AS (
+			SELECT id FROM branches WHERE id = $1
+			UNION ALL
+			SELECT b.id FROM branches b JOIN descendants d ON b.parent_branch_id = d.id
+		)
+		DELETE FROM branches WHERE id IN (SELECT id FROM descendants)
+	`, branchID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE messages SET child_branch_ids = (SELECT json_group_array(value) FROM json_each(messages.child_branch_ids) WHERE value != $1) WHERE id = $2",
+		branchID, *parentMessageID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ArchiveConversation hides a conversation from the list of active conversations, keeping the time it has been
+// archived first.
+func (s *SQLiteStorage) ArchiveConversation(ctx context.Context, id uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE conversations SET archived_at = COALESCE(archived_at, $2) WHERE id = $1", id, time.Now().UTC())
+	return checkAffected(res, err)
+}
+
+// RestoreConversation moves an archived conversation back to the list of active conversations.
+func (s *SQLiteStorage) RestoreConversation(ctx context.Context, id uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE conversations SET archived_at = NULL WHERE id = $1", id)
+	return checkAffected(res, err)
+}
+
+// Purge deletes the conversations expired according to the retention policy, and tools which aren't used anymore.
+func (s *SQLiteStorage) Purge(ctx context.Context, policy RetentionPolicy, now time.Time) (PurgeResult, error) {
+	return purgeSQL(ctx, s.db, "sqlite", policy, now)
+}
@@ -0,0 +1,39 @@
+package storage
+
+import "testing"
+
+// newTestSQLiteStorage creates a storage using a new in-memory database
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	storage, err := NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = storage.Close()
+	})
+	return storage
+}
+
+func TestSQLiteStorage_Branching(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	testStorageBranching(t, storage, sqlInspector{storage.db})
+}
+
+func TestSQLiteStorage_SearchMessagesByMetadata(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	testStorageSearchMessagesByMetadata(t, storage)
+}
+
+func TestSQLiteStorage_Tools(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	testStorageTools(t, storage, sqlInspector{storage.db})
+}
+
+func TestSQLiteStorage_Purge(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	testStoragePurge(t, storage, sqlInspector{storage.db})
+}
+
+func TestSQLiteStorage_DeleteAndArchive(t *testing.T) {
+	testStorageDeleteAndArchive(t, newTestSQLiteStorage(t))
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"llm-monitor/internal/config"
 	"time"
 
@@ -15,6 +17,7 @@ type Conversation struct {
 	CreatedAt   time.Time              `json:"created_at"`
 	RequestType string                 `json:"request_type"`
 	Metadata    map[string]interface{} `json:"metadata,omitzero"`
+	ArchivedAt  *time.Time             `json:"archived_at,omitzero"`
 }
 
 // ConversationOverview provides a summary of a conversation.
@@ -90,6 +93,21 @@ type Pagination struct {
 	Offset int
 }
 
+// ConversationFilter restricts the conversations returned by a listing.
+type ConversationFilter struct {
+	// Archived selects the archived conversations instead of the active ones.
+	Archived bool
+}
+
+// MessageFilter restricts the messages returned by a search. All conditions which are set must match.
+type MessageFilter struct {
+	// Query is a text snippet the content of a message must contain (case-insensitive).
+	Query string
+	// Metadata maps dot separated paths into the message metadata, like "parameters.temperature" or
+	// "finish_reason", to the expected values. Values are compared with their JSON text representation.
+	Metadata map[string]string
+}
+
 // Storage defines the interface for persisting and retrieving conversation data.
 type Storage interface {
 	// CreateConversation creates a new conversation and its initial branch.
@@ -110,23 +128,63 @@ type Storage interface {
 	// for the provided sequence of (role, content) pairs within a specific request type.
 	FindMessageByHistory(ctx context.Context, history []SimpleMessage, requestType string) (messageID uuid.UUID, err error)
 
-	// ListConversations returns a list of all conversations, including their first message.
-	ListConversations(ctx context.Context, p Pagination) ([]ConversationOverview, error)
+	// ListConversations returns a list of the active or archived conversations, including their first message.
+	ListConversations(ctx context.Context, filter ConversationFilter, p Pagination) ([]ConversationOverview, error)
 
-	// SearchMessages searches for messages containing the given text snippet.
-	SearchMessages(ctx context.Context, query string, p Pagination) ([]Message, error)
+	// SearchMessages searches for messages matching the given filter.
+	SearchMessages(ctx context.Context, filter MessageFilter, p Pagination) ([]Message, error)
 
 	// GetConversationMessages retrieves all messages belonging to a conversation.
 	GetConversationMessages(ctx context.Context, conversationID uuid.UUID) ([]Message, error)
 
 	// GetBranch retrieves a branch by ID.
 	GetBranch(ctx context.Context, branchID uuid.UUID) (*Branch, error)
+
+	// DeleteConversation deletes a conversation with all its branches and messages.
+	// Returns ErrNotFound if the conversation doesn't exist.
+	DeleteConversation(ctx context.Context, id uuid.UUID) error
+
+	// DeleteBranch deletes a branch with its messages and all branches forked from it.
+	// Returns ErrNotFound if the branch doesn't exist, and ErrInitialBranch for the initial branch of a conversation.
+	DeleteBranch(ctx context.Context, branchID uuid.UUID) error
+
+	// ArchiveConversation hides a conversation from the list of active conversations without deleting it.
+	// Returns ErrNotFound if the conversation doesn't exist.
+	ArchiveConversation(ctx context.Context, id uuid.UUID) error
+
+	// RestoreConversation moves an archived conversation back to the list of active conversations.
+	// Returns ErrNotFound if the conversation doesn't exist.
+	RestoreConversation(ctx context.Context, id uuid.UUID) error
+}
+
+// ErrNotFound is returned when a conversation or branch to be modified doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrInitialBranch is returned when deleting the initial branch of a conversation, which can only be deleted together
+// with the conversation.
+var ErrInitialBranch = errors.New("the initial branch of a conversation can't be deleted")
+
+// Close closes the database of the storage. Storages without resources to release, like MemoryStorage, are ignored.
+func Close(store Storage) error {
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 // CreateStorage creates a storage instance based on configuration
 func CreateStorage(cfg config.Storage) (Storage, error) {
-	if cfg.Type == "postgres" && cfg.Postgres != nil {
+	switch {
+	case cfg.Type == "postgres" && cfg.Postgres != nil:
 		return NewPostgresStorage(cfg.Postgres.DSN)
+	case cfg.Type == "sqlite" && cfg.SQLite != nil:
+		return NewSQLiteStorage(cfg.SQLite.Path)
+	case cfg.Type == "memory":
+		var maxConversations int
+		if cfg.Memory != nil {
+			maxConversations = cfg.Memory.MaxConversations
+		}
+		return NewMemoryStorage(maxConversations), nil
 	}
 	return nil, nil
 }
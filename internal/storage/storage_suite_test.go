@@ -0,0 +1,507 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The tests in this file are run against every Storage implementation. The storage must be empty.
+
+// storageInspector gives the tests access to stored data which isn't exposed by the Storage interface
+type storageInspector interface {
+	// countTools returns the number of stored tool definitions
+	countTools() (int, error)
+	// addToolCall adds a tool call to a stored message
+	addToolCall(messageID uuid.UUID, call ToolCall) error
+}
+
+// sqlInspector inspects the storages which are backed by a database
+type sqlInspector struct {
+	db *sql.DB
+}
+
+func (i sqlInspector) countTools() (int, error) {
+	var count int
+	err := i.db.QueryRow("SELECT COUNT(*) FROM tools").Scan(&count)
+	return count, err
+}
+
+func (i sqlInspector) addToolCall(messageID uuid.UUID, call ToolCall) error {
+	_, err := i.db.Exec(`
+		INSERT INTO message_tool_calls (message_id, tool_call_id, type, function_name, function_arguments)
+		VALUES ($1, $2, $3, $4, $5)
+	`, messageID, call.ID, call.Type, call.Function.Name, call.Function.Arguments)
+	return err
+}
+
+// testStorageBranching tests branching, history lookups and listing
+func testStorageBranching(t *testing.T, storage Storage, inspector storageInspector) {
+	ctx := context.Background()
+
+	// 1. Create a conversation
+	_, branch, err := storage.CreateConversation(ctx, nil, "chat")
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	// 2. Add two messages
+	m1, err := storage.AddMessage(ctx, uuid.Nil, &Message{BranchID: branch.ID, SimpleMessage: SimpleMessage{Role: "user", Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("Failed to add message 1: %v", err)
+	}
+	m2, err := storage.AddMessage(ctx, m1.ID, &Message{SimpleMessage: SimpleMessage{Role: "assistant", Content: "Hi there!"}})
+	if err != nil {
+		t.Fatalf("Failed to add message 2: %v", err)
+	}
+
+	// 3. Add a third message to the same branch
+	m3, err := storage.AddMessage(ctx, m2.ID, &Message{SimpleMessage: SimpleMessage{Role: "user", Content: "How are you?"}})
+	if err != nil {
+		t.Fatalf("Failed to add message 3: %v", err)
+	}
+
+	// 4. Now fork from m2 by adding a DIFFERENT message.
+	// We use m2.ID as the parent.
+	m4, err := storage.AddMessage(ctx, m2.ID, &Message{SimpleMessage: SimpleMessage{Role: "user", Content: "What is the weather?"}})
+	if err != nil {
+		t.Fatalf("Failed to add message 4: %v", err)
+	}
+
+	// Check branch properties
+	forked, err := storage.GetBranch(ctx, m4.BranchID)
+	if err != nil || forked == nil {
+		t.Fatalf("Failed to get branch: %v", err)
+	}
+	if forked.ParentMessageID == nil || *forked.ParentMessageID != m2.ID {
+		t.Errorf("New branch parent message ID expected %s, got %v", m2.ID, forked.ParentMessageID)
+	}
+
+	if m4.BranchID == branch.ID {
+		t.Errorf("Expected a new branch for m4, but got same branch ID")
+	}
+
+	if m4.SequenceNumber != 3 {
+		t.Errorf("Expected sequence number 3 for m4, got %d", m4.SequenceNumber)
+	}
+
+	// 5. Verify m3 is still in the original branch
+	historyOriginal, err := storage.GetBranchHistory(ctx, branch.ID)
+	if err != nil {
+		t.Fatalf("Failed to get original branch history: %v", err)
+	}
+	if len(historyOriginal) != 3 {
+		t.Errorf("Expected 3 messages in original branch history, got %d", len(historyOriginal))
+	}
+	foundM3 := false
+	for _, m := range historyOriginal {
+		if m.ID == m3.ID {
+			foundM3 = true
+			break
+		}
+	}
+	if !foundM3 {
+		t.Errorf("m3 not found in original branch history")
+	}
+
+	// 6. Verify m4 is in the new branch history
+	historyNew, err := storage.GetBranchHistory(ctx, m4.BranchID)
+	if err != nil {
+		t.Fatalf("Failed to get new branch history: %v", err)
+	}
+	if len(historyNew) != 3 {
+		t.Errorf("Expected 3 messages in new branch history, got %d", len(historyNew))
+	}
+	// History should be m1, m2, m4
+	expectedIDs := []uuid.UUID{m1.ID, m2.ID, m4.ID}
+	for i, m := range historyNew {
+		if m.ID != expectedIDs[i] {
+			t.Errorf("At index %d: expected message ID %s, got %s", i, expectedIDs[i], m.ID)
+		}
+	}
+
+	// 7. Test Idempotency (now removed, should create a new message)
+	m4_repeat, err := storage.AddMessage(ctx, m2.ID, &Message{SimpleMessage: SimpleMessage{Role: "user", Content: "What is the weather?"}})
+	if err != nil {
+		t.Fatalf("Failed to add message 4 repeat: %v", err)
+	}
+	if m4_repeat.ID == m4.ID {
+		t.Errorf("Idempotency should be removed: expected different message ID, got same %s", m4.ID)
+	}
+
+	// 8. Test FindMessageByHistory
+	history := []SimpleMessage{
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi there!"},
+		{Role: "user", Content: "What is the weather?"},
+	}
+	foundID, err := storage.FindMessageByHistory(ctx, history, "chat")
+	if err != nil {
+		t.Fatalf("FindMessageByHistory failed: %v", err)
+	}
+	// m4 and m4_repeat have the same history, the most recent message is returned
+	if foundID != m4_repeat.ID {
+		t.Errorf("FindMessageByHistory: expected %s, got %s", m4_repeat.ID, foundID)
+	}
+
+	// Test partial history
+	historyPartial := []SimpleMessage{
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi there!"},
+	}
+	foundIDPartial, err := storage.FindMessageByHistory(ctx, historyPartial, "chat")
+	if err != nil {
+		t.Fatalf("FindMessageByHistory failed: %v", err)
+	}
+	if foundIDPartial != m2.ID {
+		t.Errorf("FindMessageByHistory (partial): expected %s, got %s", m2.ID, foundIDPartial)
+	}
+
+	// 9. Test ListConversations
+	overviews, err := storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 1000, Offset: 0})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if len(overviews) != 1 {
+		t.Errorf("Expected 1 conversation overview, got %d", len(overviews))
+	} else {
+		if overviews[0].FirstMessage == nil {
+			t.Errorf("Expected first message to be populated")
+		} else if overviews[0].FirstMessage.ID != m1.ID {
+			t.Errorf("Expected first message ID %s, got %s", m1.ID, overviews[0].FirstMessage.ID)
+		}
+		if overviews[0].ToolCallCount != 0 {
+			t.Errorf("Expected 0 tool calls, got %d", overviews[0].ToolCallCount)
+		}
+	}
+
+	// 9b. Test ToolCallCount
+	// Add a tool call to m2
+	for i, name := range []string{"test_func", "test_func_2"} {
+		call := ToolCall{ID: fmt.Sprintf("call_%d", i+1), Type: "function"}
+		call.Function.Name = name
+		call.Function.Arguments = "{}"
+		if err := inspector.addToolCall(m2.ID, call); err != nil {
+			t.Fatalf("Failed to add tool call: %v", err)
+		}
+	}
+
+	overviews, err = storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 1000, Offset: 0})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if overviews[0].ToolCallCount != 2 {
+		t.Errorf("Expected 2 tool calls, got %d", overviews[0].ToolCallCount)
+	}
+
+	// 10. Test SearchMessages
+	searchResults, err := storage.SearchMessages(ctx, MessageFilter{Query: "weather"}, Pagination{Limit: 1000, Offset: 0})
+	if err != nil {
+		t.Fatalf("SearchMessages failed: %v", err)
+	}
+	// m4 and m4_repeat both have "weather"
+	if len(searchResults) != 2 {
+		t.Errorf("Expected 2 search results, got %d", len(searchResults))
+	}
+
+	// 11. Test GetConversationMessages
+	convMessages, err := storage.GetConversationMessages(ctx, branch.ConversationID)
+	if err != nil {
+		t.Fatalf("GetConversationMessages failed: %v", err)
+	}
+	// Only the initial branch: m1, m2, m3
+	if len(convMessages) != 3 {
+		t.Errorf("Expected 3 conversation messages, got %d", len(convMessages))
+	}
+
+	// 12. Test GetBranch
+	b, err := storage.GetBranch(ctx, m4.BranchID)
+	if err != nil {
+		t.Fatalf("GetBranch failed: %v", err)
+	}
+	if b == nil {
+		t.Fatalf("Expected branch to be found")
+	}
+	if b.ID != m4.BranchID {
+		t.Errorf("Expected branch ID %s, got %s", m4.BranchID, b.ID)
+	}
+	if b.ParentMessageID == nil || *b.ParentMessageID != m2.ID {
+		t.Errorf("Expected parent message ID %s, got %v", m2.ID, b.ParentMessageID)
+	}
+}
+
+// testStorageSearchMessagesByMetadata tests filtering search results by metadata values
+func testStorageSearchMessagesByMetadata(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	_, branch, err := storage.CreateConversation(ctx, nil, "chat")
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	m1, err := storage.AddMessage(ctx, uuid.Nil, &Message{BranchID: branch.ID, SimpleMessage: SimpleMessage{Role: "user", Content: "Tell me a story"}})
+	if err != nil {
+		t.Fatalf("Failed to add message 1: %v", err)
+	}
+	m2, err := storage.AddMessage(ctx, m1.ID, &Message{SimpleMessage: SimpleMessage{Role: "assistant", Content: "Once upon a time", Metadata: map[string]any{
+		"finish_reason": "length",
+		"parameters":    map[string]any{"temperature": 1.5, "seed": 42},
+	}}})
+	if err != nil {
+		t.Fatalf("Failed to add message 2: %v", err)
+	}
+	_, err = storage.AddMessage(ctx, m1.ID, &Message{SimpleMessage: SimpleMessage{Role: "assistant", Content: "Once there was", Metadata: map[string]any{
+		"finish_reason": "stop",
+		"parameters":    map[string]any{"temperature": 0.2, "seed": 42},
+	}}})
+	if err != nil {
+		t.Fatalf("Failed to add message 3: %v", err)
+	}
+
+	results, err := storage.SearchMessages(ctx, MessageFilter{Metadata: map[string]string{"parameters.temperature": "1.5", "finish_reason": "length"}}, Pagination{Limit: 100})
+	if err != nil {
+		t.Fatalf("SearchMessages failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != m2.ID {
+		t.Errorf("Expected only message %s, got %+v", m2.ID, results)
+	}
+
+	results, err = storage.SearchMessages(ctx, MessageFilter{Query: "once", Metadata: map[string]string{"parameters.seed": "42"}}, Pagination{Limit: 100})
+	if err != nil {
+		t.Fatalf("SearchMessages failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 search results, got %d", len(results))
+	}
+}
+
+// testStorageTools tests storing tools, which are deduplicated by their hashes, and tool calls
+func testStorageTools(t *testing.T, storage Storage, inspector storageInspector) {
+	ctx := context.Background()
+
+	_, branch, err := storage.CreateConversation(ctx, nil, "chat")
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	tool := Tool{Name: "get_weather", Description: "Get the weather", Parameters: []byte(`{"type":"object"}`)}
+	m1, err := storage.AddMessage(ctx, uuid.Nil, &Message{BranchID: branch.ID, SimpleMessage: SimpleMessage{Role: "user", Content: "Weather?", Tools: []Tool{tool}}})
+	if err != nil {
+		t.Fatalf("Failed to add message 1: %v", err)
+	}
+	toolCall := ToolCall{ID: "call_1", Type: "function"}
+	toolCall.Function.Name = "get_weather"
+	toolCall.Function.Arguments = `{"city":"Berlin"}`
+	m2, err := storage.AddMessage(ctx, m1.ID, &Message{SimpleMessage: SimpleMessage{Role: "assistant", ToolCalls: []ToolCall{toolCall}}})
+	if err != nil {
+		t.Fatalf("Failed to add message 2: %v", err)
+	}
+	_, err = storage.AddMessage(ctx, m2.ID, &Message{SimpleMessage: SimpleMessage{Role: "user", Content: "And tomorrow?", Tools: []Tool{tool}}})
+	if err != nil {
+		t.Fatalf("Failed to add message 3: %v", err)
+	}
+
+	toolCount, err := inspector.countTools()
+	if err != nil {
+		t.Fatalf("Failed to count tools: %v", err)
+	}
+	if toolCount != 1 {
+		t.Errorf("Expected tool to be stored once, got %d", toolCount)
+	}
+
+	history, err := storage.GetBranchHistory(ctx, branch.ID)
+	if err != nil {
+		t.Fatalf("Failed to get branch history: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(history))
+	}
+	if len(history[0].Tools) != 1 || history[0].Tools[0].Name != tool.Name || history[0].Tools[0].Description != tool.Description {
+		t.Errorf("Unexpected tools: %+v", history[0].Tools)
+	}
+	if len(history[2].Tools) != 1 || history[2].Tools[0].ID != history[0].Tools[0].ID {
+		t.Errorf("Expected the same tool for both messages, got %+v", history[2].Tools)
+	}
+	if len(history[1].ToolCalls) != 1 || history[1].ToolCalls[0] != toolCall {
+		t.Errorf("Unexpected tool calls: %+v", history[1].ToolCalls)
+	}
+}
+
+// testStoragePurge tests purging conversations according to a retention policy
+func testStoragePurge(t *testing.T, storage Storage, inspector storageInspector) {
+	ctx := context.Background()
+	purger, ok := storage.(Purger)
+	if !ok {
+		t.Fatalf("Storage %T doesn't implement Purger", storage)
+	}
+
+	// Conversations are created in this order, each with a single message
+	add := func(requestType, model string, tools ...Tool) *Conversation {
+		conv, branch, err := storage.CreateConversation(ctx, nil, requestType)
+		if err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+		_, err = storage.AddMessage(ctx, uuid.Nil, &Message{BranchID: branch.ID, SimpleMessage: SimpleMessage{Role: "user", Content: "Hi", Model: model, Tools: tools}})
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		return conv
+	}
+	gpt := add("chat", "gpt-4o")
+	// Model patterns are case-sensitive in all storages
+	add("chat", "GPT-4o")
+	embed := add("embed", "llama3")
+	add("chat", "llama3", Tool{Name: "unused"})
+
+	policy := RetentionPolicy{
+		MaxAge: 24 * time.Hour,
+		Rules: []RetentionRule{
+			{Model: "gpt-4*"},
+			{RequestType: "embed", MaxAge: 100 * time.Hour},
+		},
+		BatchSize: 1,
+	}
+	result, err := purger.Purge(ctx, policy, time.Now().Add(48*time.Hour))
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if result.Expired != 2 || result.Exceeded != 0 || result.Tools != 1 {
+		t.Errorf("Expected 2 expired conversations and 1 tool, got %+v", result)
+	}
+	if count, err := inspector.countTools(); err != nil || count != 0 {
+		t.Errorf("Expected unused tool to be deleted, got %d tools (%v)", count, err)
+	}
+	overviews, err := storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if len(overviews) != 2 || overviews[0].ID != embed.ID || overviews[1].ID != gpt.ID {
+		t.Errorf("Expected the embedding and GPT conversations to be kept, got %+v", overviews)
+	}
+
+	// The most recent conversations are kept independently of their age
+	policy = RetentionPolicy{MaxConversations: 1, BatchSize: 1}
+	result, err = purger.Purge(ctx, policy, time.Now())
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if result.Expired != 0 || result.Exceeded != 1 {
+		t.Errorf("Expected 1 exceeding conversation, got %+v", result)
+	}
+	overviews, err = storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if len(overviews) != 1 || overviews[0].ID != embed.ID {
+		t.Errorf("Expected only the most recent conversation to be kept, got %+v", overviews)
+	}
+}
+
+// testStorageDeleteAndArchive tests deleting branches and conversations, and archiving and restoring conversations
+func testStorageDeleteAndArchive(t *testing.T, storage Storage) {
+	ctx := context.Background()
+
+	conv, branch, err := storage.CreateConversation(ctx, nil, "chat")
+	if err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	add := func(parent uuid.UUID, content string) *Message {
+		m, err := storage.AddMessage(ctx, parent, &Message{BranchID: branch.ID, SimpleMessage: SimpleMessage{Role: "user", Content: content}})
+		if err != nil {
+			t.Fatalf("Failed to add message %q: %v", content, err)
+		}
+		return m
+	}
+	// m1 has the children m2 (initial branch), m3 (fork) and m6 (fork), m3 has the children m4 and m5 (fork)
+	m1 := add(uuid.Nil, "m1")
+	add(m1.ID, "m2")
+	m3 := add(m1.ID, "m3")
+	add(m3.ID, "m4")
+	m5 := add(m3.ID, "m5")
+	m6 := add(m1.ID, "m6")
+
+	if err := storage.DeleteBranch(ctx, branch.ID); !errors.Is(err, ErrInitialBranch) {
+		t.Errorf("Expected ErrInitialBranch, got %v", err)
+	}
+	if err := storage.DeleteBranch(ctx, uuid.New()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Deleting the branch of m3 deletes the branch forked from it as well
+	if err := storage.DeleteBranch(ctx, m3.BranchID); err != nil {
+		t.Fatalf("DeleteBranch failed: %v", err)
+	}
+	for _, id := range []uuid.UUID{m3.BranchID, m5.BranchID} {
+		if b, err := storage.GetBranch(ctx, id); err != nil || b != nil {
+			t.Errorf("Expected branch %s to be deleted, got %+v (%v)", id, b, err)
+		}
+	}
+	id, err := storage.FindMessageByHistory(ctx, []SimpleMessage{{Role: "user", Content: "m1"}, {Role: "user", Content: "m3"}}, "chat")
+	if err != nil || id != uuid.Nil {
+		t.Errorf("Expected messages of the deleted branch to be deleted, got %s (%v)", id, err)
+	}
+	history, err := storage.GetBranchHistory(ctx, m6.BranchID)
+	if err != nil {
+		t.Fatalf("Failed to get branch history: %v", err)
+	}
+	if len(history) != 2 || history[0].ID != m1.ID || history[1].ID != m6.ID {
+		t.Errorf("Expected the other fork to be kept, got %+v", history)
+	}
+	if len(history[0].ChildBranchIDs) != 1 || history[0].ChildBranchIDs[0] != m6.BranchID {
+		t.Errorf("Expected only the remaining fork as child branch, got %v", history[0].ChildBranchIDs)
+	}
+
+	// Archived conversations are listed separately
+	if err := storage.ArchiveConversation(ctx, conv.ID); err != nil {
+		t.Fatalf("ArchiveConversation failed: %v", err)
+	}
+	active, err := storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	archived, err := storage.ListConversations(ctx, ConversationFilter{Archived: true}, Pagination{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if len(active) != 0 || len(archived) != 1 || archived[0].ID != conv.ID || archived[0].ArchivedAt == nil {
+		t.Errorf("Expected only an archived conversation, got %+v and %+v", active, archived)
+	}
+	if c, err := storage.GetConversation(ctx, conv.ID); err != nil || c.ArchivedAt == nil {
+		t.Errorf("Expected archived conversation, got %+v (%v)", c, err)
+	}
+
+	if err := storage.RestoreConversation(ctx, conv.ID); err != nil {
+		t.Fatalf("RestoreConversation failed: %v", err)
+	}
+	active, err = storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListConversations failed: %v", err)
+	}
+	if len(active) != 1 || active[0].ArchivedAt != nil {
+		t.Errorf("Expected restored conversation, got %+v", active)
+	}
+
+	// Deleting the conversation deletes all its branches
+	if err := storage.DeleteConversation(ctx, conv.ID); err != nil {
+		t.Fatalf("DeleteConversation failed: %v", err)
+	}
+	if b, err := storage.GetBranch(ctx, m6.BranchID); err != nil || b != nil {
+		t.Errorf("Expected branches to be deleted, got %+v (%v)", b, err)
+	}
+	active, err = storage.ListConversations(ctx, ConversationFilter{}, Pagination{Limit: 10})
+	if err != nil || len(active) != 0 {
+		t.Errorf("Expected no conversations, got %+v (%v)", active, err)
+	}
+	for name, err := range map[string]error{
+		"DeleteConversation":  storage.DeleteConversation(ctx, conv.ID),
+		"ArchiveConversation": storage.ArchiveConversation(ctx, conv.ID),
+		"RestoreConversation": storage.RestoreConversation(ctx, conv.ID),
+	} {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
@@ -6,7 +6,7 @@ import App from './App.vue'
 import 'vuetify/styles'
 import { createVuetify } from 'vuetify'
 import { aliases, mdi } from 'vuetify/iconsets/mdi-svg'
-import { mdiMagnify, mdiMessageTextOutline, mdiArrowLeft, mdiHistory, mdiAccount, mdiRobot, mdiSourceBranch, mdiThemeLightDark, mdiMemory, mdiTimerOutline, mdiContentCopy, mdiCog, mdiChatOutline, mdiAutoFix, mdiRobotIndustrial, mdiInformationOutline, mdiWrench, mdiChevronRight } from '@mdi/js'
+import { mdiMagnify, mdiMessageTextOutline, mdiArrowLeft, mdiHistory, mdiAccount, mdiRobot, mdiSourceBranch, mdiThemeLightDark, mdiMemory, mdiTimerOutline, mdiContentCopy, mdiCog, mdiChatOutline, mdiAutoFix, mdiRobotIndustrial, mdiInformationOutline, mdiWrench, mdiChevronRight, mdiReplay, mdiTextLong, mdiVectorPolyline } from '@mdi/js'
 
 import Conversations from './views/Conversations.vue'
 import ConversationDetail from './views/ConversationDetail.vue'
@@ -34,9 +34,12 @@ const vuetify = createVuetify({
       'content-copy': mdiContentCopy,
       chat: mdiChatOutline,
       generate: mdiAutoFix,
+      completion: mdiTextLong,
+      embedding: mdiVectorPolyline,
       'information-outline': mdiInformationOutline,
       'wrench': mdiWrench,
       'chevron-right': mdiChevronRight,
+      replay: mdiReplay,
     },
     sets: { mdi },
   },
@@ -39,6 +39,13 @@ export type ToolCall = {
   }
 }
 
+export type Attempt = {
+  upstream: string
+  status_code?: number
+  error?: string
+  duration: number
+}
+
 export type Message = {
   id: string
   conversation_id: string
@@ -59,6 +66,7 @@ export type Message = {
   tools?: Tool[]
   tool_calls?: ToolCall[]
   tool_call_id?: string
+  metadata?: Record<string, any>
 }
 
 export async function listConversations(limit = 20, offset = 0) {
@@ -73,9 +81,11 @@ export async function getConversationMessages(id: string) {
   return data
 }
 
-export async function searchMessages(q: string, limit = 50, offset = 0) {
+// metadata maps dot separated metadata paths, e.g. "parameters.temperature", to the expected values
+export async function searchMessages(q: string, limit = 50, offset = 0, metadata: Record<string, string> = {}) {
+  const filters = Object.fromEntries(Object.entries(metadata).map(([path, value]) => [`metadata.${path}`, value]))
   const { data } = await axios.get<Message[]>(`${apiBase}/api/v1/search`, {
-    params: { q, limit, offset },
+    params: { q: q || undefined, limit, offset, ...filters },
   })
   return data
 }